@@ -0,0 +1,378 @@
+// Package admin implements an HTTP API and web dashboard that operators use
+// to manage the running bot.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/client"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// maxBodySize caps the size of API request bodies.
+const maxBodySize = 64 << 10
+
+// Session is the running bot session that the admin API manages.
+type Session interface {
+	Conversations() []client.Conversation
+	ResetConversation(screenName string) error
+	Ban(screenName string) error
+	Unban(screenName string) error
+	Bans() []string
+	Allow(screenName string) error
+	Disallow(screenName string) error
+	Allowed() []string
+	SendIM(ctx context.Context, screenName string, text string) error
+	StartTakeover(screenName string, operator string)
+	EndTakeover(screenName string) bool
+	Takeovers() []client.Takeover
+	TakeoverReply(ctx context.Context, screenName string, text string) error
+	WatchTakeover(screenName string) (recent []client.TakeoverEvent, events <-chan client.TakeoverEvent, stop func(), ok bool)
+}
+
+// ConversationStore provides the conversation transcripts.
+type ConversationStore interface {
+	History(screenName string) ([]store.Turn, error)
+}
+
+// NewServer creates a Server that manages session. Requests must carry
+// token, either as a bearer token or as the password of HTTP basic auth.
+// prompt may be nil if the chat bot's system prompt can't be changed.
+func NewServer(logger *slog.Logger, token string, session Session, conversations ConversationStore, prompt bot.PromptEditor) *Server {
+	s := &Server{
+		logger:        logger,
+		token:         token,
+		session:       session,
+		conversations: conversations,
+		prompt:        prompt,
+		mux:           http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /{$}", s.dashboard)
+	s.mux.HandleFunc("GET /api/conversations", s.listConversations)
+	s.mux.HandleFunc("GET /api/conversations/{screenName}", s.getConversation)
+	s.mux.HandleFunc("POST /api/conversations/{screenName}/reset", s.resetConversation)
+	s.mux.HandleFunc("GET /api/bans", s.listBans)
+	s.mux.HandleFunc("PUT /api/bans/{screenName}", s.ban)
+	s.mux.HandleFunc("DELETE /api/bans/{screenName}", s.unban)
+	s.mux.HandleFunc("GET /api/allowed", s.listAllowed)
+	s.mux.HandleFunc("PUT /api/allowed/{screenName}", s.allow)
+	s.mux.HandleFunc("DELETE /api/allowed/{screenName}", s.disallow)
+	s.mux.HandleFunc("GET /api/prompt", s.getPrompt)
+	s.mux.HandleFunc("PUT /api/prompt", s.setPrompt)
+	s.mux.HandleFunc("POST /api/messages", s.sendMessage)
+	s.mux.HandleFunc("GET /api/takeovers", s.listTakeovers)
+	s.mux.HandleFunc("PUT /api/takeovers/{screenName}", s.startTakeover)
+	s.mux.HandleFunc("DELETE /api/takeovers/{screenName}", s.endTakeover)
+	s.mux.HandleFunc("POST /api/takeovers/{screenName}/messages", s.takeoverReply)
+	s.mux.HandleFunc("GET /api/takeovers/{screenName}/events", s.watchTakeover)
+	return s
+}
+
+// Server is the admin HTTP API and dashboard.
+type Server struct {
+	logger        *slog.Logger
+	token         string
+	session       Session
+	conversations ConversationStore
+	prompt        bot.PromptEditor
+	mux           *http.ServeMux
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="SmarterSmarterChild admin"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+		// browsers send basic auth credentials along with cross-site
+		// requests, so don't let other sites change anything
+		writeError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r carries the admin token.
+func (s *Server) authorized(r *http.Request) bool {
+	var token string
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// sameOrigin reports whether a state-changing request came from the
+// dashboard or a non-browser client. Cross-site HTML forms can't send JSON,
+// and browsers label cross-site requests with an Origin header.
+func sameOrigin(r *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (s *Server) dashboard(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	_, _ = w.Write(dashboardHTML)
+}
+
+func (s *Server) listConversations(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.session.Conversations())
+}
+
+func (s *Server) getConversation(w http.ResponseWriter, r *http.Request) {
+	screenName := r.PathValue("screenName")
+	history, err := s.conversations.History(screenName)
+	if err != nil {
+		s.logger.Error("unable to load conversation history", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to load conversation history")
+		return
+	}
+	if history == nil {
+		history = []store.Turn{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ScreenName string       `json:"screen_name"`
+		History    []store.Turn `json:"history"`
+	}{
+		ScreenName: screenName,
+		History:    history,
+	})
+}
+
+func (s *Server) resetConversation(w http.ResponseWriter, r *http.Request) {
+	screenName := r.PathValue("screenName")
+	if err := s.session.ResetConversation(screenName); err != nil {
+		s.logger.Error("unable to reset conversation", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to reset conversation")
+		return
+	}
+	s.logger.Info("operator reset conversation", "screen_name", screenName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listBans(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.session.Bans())
+}
+
+func (s *Server) ban(w http.ResponseWriter, r *http.Request) {
+	s.updateAccess(w, s.session.Ban(r.PathValue("screenName")))
+}
+
+func (s *Server) unban(w http.ResponseWriter, r *http.Request) {
+	s.updateAccess(w, s.session.Unban(r.PathValue("screenName")))
+}
+
+func (s *Server) listAllowed(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.session.Allowed())
+}
+
+func (s *Server) allow(w http.ResponseWriter, r *http.Request) {
+	s.updateAccess(w, s.session.Allow(r.PathValue("screenName")))
+}
+
+func (s *Server) disallow(w http.ResponseWriter, r *http.Request) {
+	s.updateAccess(w, s.session.Disallow(r.PathValue("screenName")))
+}
+
+// updateAccess responds to a change of the allow or deny list that failed
+// with err, if not nil.
+func (s *Server) updateAccess(w http.ResponseWriter, err error) {
+	if err != nil {
+		s.logger.Error("unable to update access list", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to update access list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type promptBody struct {
+	Prompt string `json:"prompt"`
+}
+
+func (s *Server) getPrompt(w http.ResponseWriter, _ *http.Request) {
+	if s.prompt == nil {
+		writeError(w, http.StatusNotImplemented, "the chat bot has no prompt")
+		return
+	}
+	writeJSON(w, http.StatusOK, promptBody{Prompt: s.prompt.Prompt()})
+}
+
+func (s *Server) setPrompt(w http.ResponseWriter, r *http.Request) {
+	if s.prompt == nil {
+		writeError(w, http.StatusNotImplemented, "the chat bot has no prompt")
+		return
+	}
+	var body promptBody
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Prompt) == "" {
+		writeError(w, http.StatusBadRequest, "prompt is required")
+		return
+	}
+	s.prompt.SetPrompt(body.Prompt)
+	s.logger.Info("operator changed prompt", "prompt", body.Prompt)
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ScreenName string `json:"screen_name"`
+		Text       string `json:"text"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.ScreenName == "" || strings.TrimSpace(body.Text) == "" {
+		writeError(w, http.StatusBadRequest, "screen_name and text are required")
+		return
+	}
+	switch err := s.session.SendIM(r.Context(), body.ScreenName, body.Text); {
+	case errors.Is(err, client.ErrOffline):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		s.logger.Error("unable to send operator IM", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to send IM")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) listTakeovers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.session.Takeovers())
+}
+
+func (s *Server) startTakeover(w http.ResponseWriter, r *http.Request) {
+	s.session.StartTakeover(r.PathValue("screenName"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) endTakeover(w http.ResponseWriter, r *http.Request) {
+	if !s.session.EndTakeover(r.PathValue("screenName")) {
+		writeError(w, http.StatusNotFound, client.ErrNoTakeover.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) takeoverReply(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	switch err := s.session.TakeoverReply(r.Context(), r.PathValue("screenName"), body.Text); {
+	case errors.Is(err, client.ErrNoTakeover):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, client.ErrOffline):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		s.logger.Error("unable to send operator reply", "err", err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to send IM")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// watchTakeover streams the messages of a taken-over conversation as
+// server-sent events, starting with the recent ones. The stream ends with an
+// "end" event when the conversation is handed back to the bot.
+func (s *Server) watchTakeover(w http.ResponseWriter, r *http.Request) {
+	recent, events, stop, ok := s.session.WatchTakeover(r.PathValue("screenName"))
+	if !ok {
+		writeError(w, http.StatusNotFound, client.ErrNoTakeover.Error())
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	send := func(event string, v any) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for _, ev := range recent {
+		if !send("message", ev) {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, open := <-events:
+			if !open {
+				send("end", struct{}{})
+				return
+			}
+			if !send("message", ev) {
+				return
+			}
+		}
+	}
+}
+
+// readJSON decodes the JSON request body into v. If it fails, it writes an
+// error response and returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: msg})
+}
@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeSession records bans. Calling any other Session method panics.
+type fakeSession struct {
+	Session
+	bans []string
+}
+
+func (f *fakeSession) Ban(screenName string) error {
+	f.bans = append(f.bans, screenName)
+	return nil
+}
+
+func (f *fakeSession) Bans() []string {
+	return f.bans
+}
+
+func newTestServer(session Session) *Server {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewServer(logger, "s3cret", session, nil, nil)
+}
+
+func TestServerAuthorized(t *testing.T) {
+	tests := []struct {
+		name      string
+		setAuth   func(r *http.Request)
+		wantCode  int
+		challenge bool
+	}{
+		{
+			name:      "missing token",
+			setAuth:   func(*http.Request) {},
+			wantCode:  http.StatusUnauthorized,
+			challenge: true,
+		},
+		{
+			name:      "wrong bearer token",
+			setAuth:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") },
+			wantCode:  http.StatusUnauthorized,
+			challenge: true,
+		},
+		{
+			name:     "bearer token",
+			setAuth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "wrong basic auth password",
+			setAuth:   func(r *http.Request) { r.SetBasicAuth("admin", "nope") },
+			wantCode:  http.StatusUnauthorized,
+			challenge: true,
+		},
+		{
+			name:     "basic auth password",
+			setAuth:  func(r *http.Request) { r.SetBasicAuth("admin", "s3cret") },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "token as basic auth user name",
+			setAuth:   func(r *http.Request) { r.SetBasicAuth("s3cret", "") },
+			wantCode:  http.StatusUnauthorized,
+			challenge: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(&fakeSession{})
+			r := httptest.NewRequest(http.MethodGet, "/api/bans", nil)
+			tt.setAuth(r)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+				t.Errorf("sent WWW-Authenticate = %v, want %v", got, tt.challenge)
+			}
+		})
+	}
+}
+
+func TestServerAuthorizedWithoutToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := NewServer(logger, "", &fakeSession{}, nil, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/bans", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestServerSameOrigin(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		origin      string
+		wantCode    int
+	}{
+		{
+			name:        "non-browser client",
+			contentType: "application/json",
+			wantCode:    http.StatusNoContent,
+		},
+		{
+			name:        "same origin",
+			contentType: "application/json; charset=utf-8",
+			origin:      "http://admin.example",
+			wantCode:    http.StatusNoContent,
+		},
+		{
+			name:        "cross origin",
+			contentType: "application/json",
+			origin:      "http://evil.example",
+			wantCode:    http.StatusForbidden,
+		},
+		{
+			name:        "malformed origin",
+			contentType: "application/json",
+			origin:      "://admin.example",
+			wantCode:    http.StatusForbidden,
+		},
+		{
+			name:     "form post",
+			origin:   "http://admin.example",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:        "non-JSON content type",
+			contentType: "text/plain",
+			wantCode:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &fakeSession{}
+			srv := newTestServer(session)
+			r := httptest.NewRequest(http.MethodPut, "http://admin.example/api/bans/spammer", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if banned := len(session.bans) > 0; banned != (tt.wantCode == http.StatusNoContent) {
+				t.Errorf("banned = %v with status %d", banned, w.Code)
+			}
+		})
+	}
+}
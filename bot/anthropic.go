@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+const (
+	// defaultAnthropicURL is the Anthropic Messages API endpoint.
+	defaultAnthropicURL = "https://api.anthropic.com/v1/messages"
+	// anthropicVersion is the Messages API version the provider speaks.
+	anthropicVersion = "2023-06-01"
+)
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature float64            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice  *anthropicChoice   `json:"tool_choice,omitempty"`
+	Metadata    *anthropicMetadata `json:"metadata,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block. Which fields are set depends on Type:
+// "text", "tool_use" or "tool_result".
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicChoice struct {
+	Type string `json:"type"`
+}
+
+type anthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicEvent is a server-sent event from a streamed response. Which
+// fields are set depends on Type.
+type anthropicEvent struct {
+	Type         string         `json:"type"`
+	Index        int            `json:"index"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	// Message is the reply's metadata, sent in the message_start event.
+	Message anthropicResponse `json:"message"`
+	// Usage is the cumulative output token count, sent in message_delta
+	// events.
+	Usage anthropicUsage `json:"usage"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewAnthropicProvider creates a provider for the Anthropic Messages API.
+func NewAnthropicProvider(cfg config.Config) *AnthropicProvider {
+	apiURL := cfg.APIUrl
+	if apiURL == "" {
+		apiURL = defaultAnthropicURL
+	}
+	return &AnthropicProvider{
+		secretKey:   cfg.AnthropicKey,
+		model:       cfg.Model,
+		maxTokens:   cfg.MaxTokens,
+		temperature: cfg.Temperature,
+		apiURL:      apiURL,
+		client:      &http.Client{Timeout: cfg.APITimeout},
+		retry:       newRetryPolicy(cfg),
+	}
+}
+
+// AnthropicProvider requests completions from the Anthropic Messages API.
+// Only the temperature sampling setting is sent, since the API doesn't allow
+// both temperature and top-p to be set for all models.
+type AnthropicProvider struct {
+	secretKey   string
+	model       string
+	maxTokens   int
+	temperature float64
+	apiURL      string
+	client      *http.Client
+	retry       retryPolicy
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, c Completion) (Message, error) {
+	resp, err := p.post(ctx, p.newRequest(c))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var response anthropicResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Message{}, malformed(err)
+	}
+	msg := fromAnthropicBlocks(response.Content)
+	msg.Model = response.Model
+	msg.Usage = response.Usage.toUsage()
+	return msg, nil
+}
+
+func (p *AnthropicProvider) CompleteStream(ctx context.Context, c Completion, onDelta func(delta string) error) (Message, error) {
+	req := p.newRequest(c)
+	req.Stream = true
+	resp, err := p.post(ctx, req)
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	// The response is a series of server-sent events that build up the
+	// content blocks of the reply one delta at a time.
+	var blocks []anthropicBlock
+	var toolInput []string
+	var model string
+	var tokens anthropicUsage
+	err = readEvents(resp.Body, func(data []byte) error {
+		var event anthropicEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return malformed(fmt.Errorf("unable to parse completion event: %w", err))
+		}
+		switch event.Type {
+		case "message_start":
+			model = event.Message.Model
+			tokens = event.Message.Usage
+		case "message_delta":
+			tokens.OutputTokens = event.Usage.OutputTokens
+		case "content_block_start":
+			if event.Index != len(blocks) {
+				return fmt.Errorf("content block index %d out of order", event.Index)
+			}
+			blocks = append(blocks, event.ContentBlock)
+			toolInput = append(toolInput, "")
+		case "content_block_delta":
+			if event.Index < 0 || event.Index >= len(blocks) {
+				return fmt.Errorf("content block index %d out of range", event.Index)
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				blocks[event.Index].Text += event.Delta.Text
+				if event.Delta.Text != "" {
+					return onDelta(event.Delta.Text)
+				}
+			case "input_json_delta":
+				toolInput[event.Index] += event.Delta.PartialJSON
+			}
+		case "error":
+			apiErr := &APIError{Kind: ErrorKindServer, StatusCode: resp.StatusCode, Message: event.Error.Message}
+			refineAnthropicError(apiErr, event.Error.Type)
+			return apiErr
+		}
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" {
+			blocks[i].Input = json.RawMessage(toolInput[i])
+		}
+	}
+	msg := fromAnthropicBlocks(blocks)
+	msg.Model = model
+	msg.Usage = tokens.toUsage()
+	return msg, nil
+}
+
+// newRequest converts c to the Messages API format.
+func (p *AnthropicProvider) newRequest(c Completion) anthropicRequest {
+	req := anthropicRequest{
+		Model:       p.model,
+		MaxTokens:   p.maxTokens,
+		System:      c.System,
+		Temperature: p.temperature,
+	}
+	if c.User != "" {
+		req.Metadata = &anthropicMetadata{UserID: c.User}
+	}
+	for _, tool := range c.Tools {
+		req.Tools = append(req.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	if c.DisableTools && len(req.Tools) > 0 {
+		req.ToolChoice = &anthropicChoice{Type: "none"}
+	}
+
+	for _, m := range c.Messages {
+		var role string
+		var blocks []anthropicBlock
+		switch m.Role {
+		case RoleTool:
+			// tool results are sent by the user
+			role = "user"
+			blocks = []anthropicBlock{{
+				Type:      "tool_result",
+				ToolUseID: m.ToolCallID,
+				Content:   m.Content,
+			}}
+		case RoleAssistant:
+			role = "assistant"
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Name,
+					Input: input,
+				})
+			}
+		default:
+			role = "user"
+			blocks = []anthropicBlock{{Type: "text", Text: m.Content}}
+		}
+
+		// consecutive messages from the same role must be merged, e.g. the
+		// results of several tool calls
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == role {
+			req.Messages[n-1].Content = append(req.Messages[n-1].Content, blocks...)
+			continue
+		}
+		req.Messages = append(req.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	return req
+}
+
+// fromAnthropicBlocks converts the content blocks of a reply to a Message.
+func fromAnthropicBlocks(blocks []anthropicBlock) Message {
+	msg := Message{Role: RoleAssistant}
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			msg.Content += block.Text
+		case "tool_use":
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: args,
+			})
+		}
+	}
+	return msg
+}
+
+func (u anthropicUsage) toUsage() Usage {
+	return Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens}
+}
+
+// anthropicError classifies an unsuccessful response from the Messages API.
+func anthropicError(resp *http.Response, body []byte) *APIError {
+	var response anthropicErrorResponse
+	_ = json.Unmarshal(body, &response) // error bodies aren't always JSON
+	apiErr := newStatusError(resp, response.Error.Message)
+	refineAnthropicError(apiErr, response.Error.Type)
+	return apiErr
+}
+
+// refineAnthropicError classifies apiErr by the error type reported by the
+// Messages API.
+func refineAnthropicError(apiErr *APIError, errType string) {
+	switch {
+	case errType == "authentication_error" || errType == "permission_error":
+		apiErr.Kind = ErrorKindAuth
+	case errType == "billing_error" || strings.Contains(apiErr.Message, "credit balance"):
+		apiErr.Kind = ErrorKindQuota
+	case errType == "rate_limit_error":
+		apiErr.Kind = ErrorKindRateLimited
+	case errType == "overloaded_error" || errType == "api_error":
+		apiErr.Kind = ErrorKindServer
+	}
+}
+
+// post sends a request to the Messages API, retrying transient failures.
+func (p *AnthropicProvider) post(ctx context.Context, data anthropicRequest) (*http.Response, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.retry.do(ctx, p.client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", p.secretKey)
+		req.Header.Set("Anthropic-Version", anthropicVersion)
+		return req, nil
+	}, anthropicError)
+}
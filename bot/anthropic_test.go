@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+func newTestAnthropicProvider(url string) *AnthropicProvider {
+	return NewAnthropicProvider(config.Config{
+		APIUrl:       url,
+		AnthropicKey: "sk-ant-test",
+		Model:        "claude-3-5-haiku-latest",
+		MaxTokens:    256,
+		Temperature:  0.5,
+	})
+}
+
+func TestAnthropicProviderComplete(t *testing.T) {
+	srv, lastRequest := newUpstream(t, "application/json", `{
+		"model": "claude-3-5-haiku-20241022",
+		"content": [
+			{"type": "text", "text": "let me check"},
+			{"type": "tool_use", "id": "toolu_2", "name": "time", "input": {}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 20, "output_tokens": 7}
+	}`)
+
+	msg, err := newTestAnthropicProvider(srv.URL).Complete(context.Background(), Completion{
+		System: "be nice",
+		Messages: []Message{
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{
+				{ID: "toolu_1", Name: "calc", Arguments: `{"expr":"1+1"}`},
+				{ID: "toolu_3", Name: "time"},
+			}},
+			{Role: RoleTool, ToolCallID: "toolu_1", Content: "2"},
+			{Role: RoleTool, ToolCallID: "toolu_3", Content: "noon"},
+		},
+		Tools: []Tool{{Name: "calc", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		User:  "abc",
+	})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	req := lastRequest()
+	if got := req.header.Get("X-API-Key"); got != "sk-ant-test" {
+		t.Errorf("X-API-Key = %q, want %q", got, "sk-ant-test")
+	}
+	if got := req.header.Get("Anthropic-Version"); got != anthropicVersion {
+		t.Errorf("Anthropic-Version = %q, want %q", got, anthropicVersion)
+	}
+	var body anthropicRequest
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("unable to parse request body: %v", err)
+	}
+	if body.Model != "claude-3-5-haiku-latest" || body.MaxTokens != 256 || body.System != "be nice" || body.Temperature != 0.5 {
+		t.Errorf("unexpected request settings: %+v", body)
+	}
+	if body.Metadata == nil || body.Metadata.UserID != "abc" {
+		t.Errorf("metadata = %+v, want user abc", body.Metadata)
+	}
+	if len(body.Tools) != 1 || string(body.Tools[0].InputSchema) != `{"type":"object"}` || body.ToolChoice != nil {
+		t.Errorf("tools = %+v, tool_choice = %+v, want calc with tools enabled", body.Tools, body.ToolChoice)
+	}
+	// the two tool results are merged into a single user message
+	if len(body.Messages) != 3 {
+		t.Fatalf("got %d messages, want 3: %+v", len(body.Messages), body.Messages)
+	}
+	if m := body.Messages[1]; m.Role != "assistant" || len(m.Content) != 2 || m.Content[0].Type != "tool_use" || string(m.Content[1].Input) != "{}" {
+		t.Errorf("assistant message = %+v, want two tool_use blocks", m)
+	}
+	if m := body.Messages[2]; m.Role != "user" || len(m.Content) != 2 || m.Content[0].ToolUseID != "toolu_1" || m.Content[1].ToolUseID != "toolu_3" {
+		t.Errorf("tool results = %+v, want both results in one user message", m)
+	}
+
+	if msg.Content != "let me check" || msg.Model != "claude-3-5-haiku-20241022" {
+		t.Errorf("message = %+v, want the reply text and reported model", msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0] != (ToolCall{ID: "toolu_2", Name: "time", Arguments: "{}"}) {
+		t.Errorf("tool calls = %+v, want a call to time", msg.ToolCalls)
+	}
+	if want := (Usage{PromptTokens: 20, CompletionTokens: 7}); msg.Usage != want {
+		t.Errorf("usage = %+v, want %+v", msg.Usage, want)
+	}
+}
+
+func TestAnthropicProviderCompleteStream(t *testing.T) {
+	stream := `event: message_start
+data: {"type":"message_start","message":{"model":"claude-3-5-haiku-20241022","usage":{"input_tokens":9,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"2u"}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"calc","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"expr\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"1+1\"}"}}
+
+event: message_delta
+data: {"type":"message_delta","usage":{"output_tokens":15}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+	srv, lastRequest := newUpstream(t, "text/event-stream", stream)
+
+	var deltas []string
+	msg, err := newTestAnthropicProvider(srv.URL).CompleteStream(context.Background(), Completion{
+		Messages: []Message{{Role: RoleUser, Content: "hello"}},
+	}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CompleteStream() error = %v", err)
+	}
+
+	var body anthropicRequest
+	if err := json.Unmarshal(lastRequest().body, &body); err != nil {
+		t.Fatalf("unable to parse request body: %v", err)
+	}
+	if !body.Stream {
+		t.Error("request doesn't ask for a stream")
+	}
+	if got := strings.Join(deltas, "|"); got != "hi|2u" {
+		t.Errorf("deltas = %q, want %q", got, "hi|2u")
+	}
+	if msg.Content != "hi2u" || msg.Model != "claude-3-5-haiku-20241022" {
+		t.Errorf("message = %+v, want the streamed text and reported model", msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0] != (ToolCall{ID: "toolu_1", Name: "calc", Arguments: `{"expr":"1+1"}`}) {
+		t.Errorf("tool calls = %+v, want the merged calc call", msg.ToolCalls)
+	}
+	if want := (Usage{PromptTokens: 9, CompletionTokens: 15}); msg.Usage != want {
+		t.Errorf("usage = %+v, want %+v", msg.Usage, want)
+	}
+}
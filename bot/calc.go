@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// maxExprLen is the longest expression the calculator will evaluate.
+const maxExprLen = 256
+
+// evalExpr evaluates an arithmetic expression made of numbers, parentheses,
+// unary minus and the operators + - * / % ^.
+func evalExpr(expr string) (float64, error) {
+	if len(expr) > maxExprLen {
+		return 0, errors.New("expression is too long")
+	}
+	p := exprParser{src: expr}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.src[p.pos], p.pos+1)
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return v, nil
+}
+
+// exprParser is a recursive descent parser for arithmetic expressions.
+type exprParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of the input.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// parseSum parses terms separated by + and -.
+func (p *exprParser) parseSum() (float64, error) {
+	v, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			rhs, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			v += rhs
+		case '-':
+			p.pos++
+			rhs, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			v -= rhs
+		default:
+			return v, nil
+		}
+	}
+}
+
+// parseProduct parses factors separated by *, / and %.
+func (p *exprParser) parseProduct() (float64, error) {
+	v, err := p.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return v, nil
+		}
+		p.pos++
+		rhs, err := p.parsePower()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case op == '*':
+			v *= rhs
+		case rhs == 0:
+			return 0, errors.New("division by zero")
+		case op == '/':
+			v /= rhs
+		default:
+			v = math.Mod(v, rhs)
+		}
+	}
+}
+
+// parsePower parses right-associative exponentiation.
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exp, err := p.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exp), nil
+}
+
+// parseUnary parses a number or parenthesized expression with optional
+// leading signs.
+func (p *exprParser) parseUnary() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExprLen {
+		return 0, errors.New("expression is nested too deeply")
+	}
+
+	switch c := p.peek(); {
+	case c == '-':
+		p.pos++
+		v, err := p.parseUnary()
+		return -v, err
+	case c == '+':
+		p.pos++
+		return p.parseUnary()
+	case c == '(':
+		p.pos++
+		v, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return v, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return v, nil
+	case c == 0:
+		return 0, errors.New("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+	}
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    float64
+		wantErr string
+	}{
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 - 4 - 3", want: 3},
+		{expr: "24 / 4 / 2", want: 3},
+		{expr: "2 * 3 % 4", want: 2},
+		{expr: "2 ^ 3 ^ 2", want: 512},
+		{expr: "2 * 3 ^ 2", want: 18},
+		{expr: "-3 + +5", want: 2},
+		{expr: "--4", want: 4},
+		{expr: " 1.5 * .5 ", want: 0.75},
+		{expr: "1 / 0", wantErr: "division by zero"},
+		{expr: "5 % (2 - 2)", wantErr: "division by zero"},
+		{expr: "1 / 0.0", wantErr: "division by zero"},
+		{expr: "10 ^ 400", wantErr: "not a finite number"},
+		{expr: "(1 + 2", wantErr: "missing closing parenthesis"},
+		{expr: "1 +", wantErr: "unexpected end"},
+		{expr: "1 2", wantErr: `unexpected '2' at position 3`},
+		{expr: "1.2.3", wantErr: "invalid number"},
+		{expr: "x", wantErr: "unexpected 'x'"},
+		{name: "too long", expr: strings.Repeat("(", 200) + "1" + strings.Repeat(")", 200), wantErr: "too long"},
+	}
+	for _, tt := range tests {
+		name := tt.name
+		if name == "" {
+			name = tt.expr
+		}
+		t.Run(name, func(t *testing.T) {
+			got, err := evalExpr(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evalExpr() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalExpr() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("evalExpr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
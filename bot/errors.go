@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+// ErrorKind classifies a failed upstream API call.
+type ErrorKind int
+
+const (
+	// ErrorKindServer means the upstream API is down, overloaded or
+	// unreachable. It's usually transient.
+	ErrorKindServer ErrorKind = iota
+	// ErrorKindAuth means the API key is missing, invalid or lacks access.
+	ErrorKindAuth
+	// ErrorKindRateLimited means too many requests were sent too quickly.
+	ErrorKindRateLimited
+	// ErrorKindQuota means the account ran out of credits or hit its usage
+	// limit.
+	ErrorKindQuota
+	// ErrorKindMalformed means the upstream API sent a response that
+	// couldn't be understood.
+	ErrorKindMalformed
+	// ErrorKindRequest means the upstream API rejected the request, e.g.
+	// because the model doesn't exist.
+	ErrorKindRequest
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindServer:
+		return "server"
+	case ErrorKindAuth:
+		return "auth"
+	case ErrorKindRateLimited:
+		return "rate_limited"
+	case ErrorKindQuota:
+		return "quota"
+	case ErrorKindMalformed:
+		return "malformed"
+	case ErrorKindRequest:
+		return "request"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// APIError is a failed upstream API call.
+type APIError struct {
+	Kind ErrorKind
+	// StatusCode is the HTTP status of the response, or 0 if no response was
+	// received.
+	StatusCode int
+	// Message is the error message sent by the upstream API, if any.
+	Message string
+	// RetryAfter is how long the upstream API asked to wait before trying
+	// again, if it said.
+	RetryAfter time.Duration
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Message != "":
+		return fmt.Sprintf("%s error from upstream api (status %d): %s", e.Kind, e.StatusCode, e.Message)
+	case e.Err != nil:
+		return fmt.Sprintf("%s error from upstream api: %s", e.Kind, e.Err.Error())
+	default:
+		return fmt.Sprintf("%s error from upstream api (status %d)", e.Kind, e.StatusCode)
+	}
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether the call may succeed if tried again.
+func (e *APIError) Transient() bool {
+	return e.Kind == ErrorKindServer || e.Kind == ErrorKindRateLimited
+}
+
+// ErrorKindOf returns the kind of the first APIError in err's chain. It
+// returns false if there's no APIError.
+func ErrorKindOf(err error) (ErrorKind, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind, true
+	}
+	return 0, false
+}
+
+// newStatusError creates an APIError for an unsuccessful HTTP response,
+// classified by status code. Providers refine the kind using the error
+// details in the response body.
+func newStatusError(resp *http.Response, message string) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		RetryAfter: retryAfter(resp.Header),
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = ErrorKindAuth
+	case resp.StatusCode == http.StatusPaymentRequired:
+		apiErr.Kind = ErrorKindQuota
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = ErrorKindRateLimited
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		apiErr.Kind = ErrorKindServer
+	default:
+		apiErr.Kind = ErrorKindRequest
+	}
+	return apiErr
+}
+
+// malformed wraps an error parsing a response from the upstream API.
+func malformed(err error) error {
+	return &APIError{Kind: ErrorKindMalformed, StatusCode: http.StatusOK, Err: err}
+}
+
+// retryAfter parses the delay the upstream API asked for, in either the
+// standard Retry-After header or OpenAI's retry-after-ms header.
+func retryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// maxErrorBodySize caps how much of an error response is read.
+const maxErrorBodySize = 64 << 10
+
+// retryPolicy retries upstream API calls that fail with transient errors.
+type retryPolicy struct {
+	// provider names the upstream API in metrics.
+	provider string
+	// attempts is the maximum number of times a call is made.
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func newRetryPolicy(cfg config.Config) retryPolicy {
+	provider := strings.ToLower(cfg.Provider)
+	if provider == "" {
+		provider = "openai"
+	}
+	return retryPolicy{
+		provider:  provider,
+		attempts:  cfg.RetryAttempts,
+		baseDelay: cfg.RetryBaseDelay,
+		maxDelay:  cfg.RetryMaxDelay,
+	}
+}
+
+// do sends the request created by newReq, retrying transient failures with
+// exponential backoff, or after the delay the upstream API asked for. It
+// returns the response if the call succeeds. Otherwise, toErr converts the
+// unsuccessful response and its body to an APIError.
+func (p retryPolicy) do(
+	ctx context.Context,
+	client *http.Client,
+	newReq func() (*http.Request, error),
+	toErr func(resp *http.Response, body []byte) *APIError,
+) (*http.Response, error) {
+
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		var apiErr *APIError
+		start := time.Now()
+		resp, err := client.Do(req)
+		upstreamDuration.Observe(time.Since(start).Seconds(), p.provider)
+		if err != nil {
+			upstreamResponses.Inc(p.provider, "none")
+		} else {
+			upstreamResponses.Inc(p.provider, strconv.Itoa(resp.StatusCode))
+		}
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			apiErr = &APIError{Kind: ErrorKindServer, Err: err}
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		default:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+			resp.Body.Close()
+			apiErr = toErr(resp, body)
+		}
+
+		if !apiErr.Transient() || attempt >= p.attempts {
+			upstreamErrors.Inc(p.provider, apiErr.Kind.String())
+			return nil, apiErr
+		}
+
+		delay := apiErr.RetryAfter
+		if delay == 0 {
+			delay = p.backoff(attempt)
+		}
+		if delay > p.maxDelay {
+			// not worth keeping the user waiting
+			upstreamErrors.Inc(p.provider, apiErr.Kind.String())
+			return nil, apiErr
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns how long to wait before retrying after the nth failed
+// attempt. The delay doubles after each attempt, with up to 50% jitter.
+func (p retryPolicy) backoff(n int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < n && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		status        int
+		wantKind      ErrorKind
+		wantTransient bool
+	}{
+		{status: http.StatusBadRequest, wantKind: ErrorKindRequest},
+		{status: http.StatusUnauthorized, wantKind: ErrorKindAuth},
+		{status: http.StatusPaymentRequired, wantKind: ErrorKindQuota},
+		{status: http.StatusForbidden, wantKind: ErrorKindAuth},
+		{status: http.StatusNotFound, wantKind: ErrorKindRequest},
+		{status: http.StatusRequestTimeout, wantKind: ErrorKindServer, wantTransient: true},
+		{status: http.StatusTooManyRequests, wantKind: ErrorKindRateLimited, wantTransient: true},
+		{status: http.StatusInternalServerError, wantKind: ErrorKindServer, wantTransient: true},
+		{status: http.StatusServiceUnavailable, wantKind: ErrorKindServer, wantTransient: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			apiErr := newStatusError(&http.Response{StatusCode: tt.status, Header: http.Header{}}, "")
+			if apiErr.Kind != tt.wantKind {
+				t.Errorf("kind = %s, want %s", apiErr.Kind, tt.wantKind)
+			}
+			if apiErr.Transient() != tt.wantTransient {
+				t.Errorf("Transient() = %v, want %v", apiErr.Transient(), tt.wantTransient)
+			}
+		})
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		toErr    func(*http.Response, []byte) *APIError
+		status   int
+		body     string
+		wantKind ErrorKind
+		wantMsg  string
+	}{
+		{
+			name:     "openai quota",
+			toErr:    openAIError,
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`,
+			wantKind: ErrorKindQuota,
+			wantMsg:  "You exceeded your current quota",
+		},
+		{
+			name:     "openai rate limit",
+			toErr:    openAIError,
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":{"message":"Rate limit reached","type":"requests"}}`,
+			wantKind: ErrorKindRateLimited,
+			wantMsg:  "Rate limit reached",
+		},
+		{
+			name:     "openai non-JSON body",
+			toErr:    openAIError,
+			status:   http.StatusBadGateway,
+			body:     `<html>bad gateway</html>`,
+			wantKind: ErrorKindServer,
+		},
+		{
+			name:     "anthropic overloaded",
+			toErr:    anthropicError,
+			status:   529,
+			body:     `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantKind: ErrorKindServer,
+			wantMsg:  "Overloaded",
+		},
+		{
+			name:     "anthropic billing",
+			toErr:    anthropicError,
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low"}}`,
+			wantKind: ErrorKindQuota,
+			wantMsg:  "Your credit balance is too low",
+		},
+		{
+			name:     "anthropic permission",
+			toErr:    anthropicError,
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"permission_error","message":"no access"}}`,
+			wantKind: ErrorKindAuth,
+			wantMsg:  "no access",
+		},
+		{
+			name:     "ollama missing model",
+			toErr:    ollamaError,
+			status:   http.StatusNotFound,
+			body:     `{"error":"model \"llama9\" not found"}`,
+			wantKind: ErrorKindRequest,
+			wantMsg:  `model "llama9" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := tt.toErr(&http.Response{StatusCode: tt.status, Header: http.Header{}}, []byte(tt.body))
+			if apiErr.Kind != tt.wantKind {
+				t.Errorf("kind = %s, want %s", apiErr.Kind, tt.wantKind)
+			}
+			if apiErr.Message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", apiErr.Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:    "seconds",
+			header:  map[string]string{"Retry-After": "3"},
+			wantMin: 3 * time.Second,
+			wantMax: 3 * time.Second,
+		},
+		{
+			name:    "fractional seconds",
+			header:  map[string]string{"Retry-After": "0.5"},
+			wantMin: 500 * time.Millisecond,
+			wantMax: 500 * time.Millisecond,
+		},
+		{
+			name:    "milliseconds take precedence",
+			header:  map[string]string{"Retry-After": "3", "Retry-After-Ms": "250"},
+			wantMin: 250 * time.Millisecond,
+			wantMax: 250 * time.Millisecond,
+		},
+		{
+			name:    "HTTP date",
+			header:  map[string]string{"Retry-After": time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)},
+			wantMin: 85 * time.Second,
+			wantMax: 90 * time.Second,
+		},
+		{
+			name:   "HTTP date in the past",
+			header: map[string]string{"Retry-After": time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+		},
+		{
+			name:   "garbage",
+			header: map[string]string{"Retry-After": "soon"},
+		},
+		{
+			name:   "negative",
+			header: map[string]string{"Retry-After": "-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			if got := retryAfter(h); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("retryAfter() = %s, want between %s and %s", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrNoBackends is returned by FallbackChatBot when every backend either
+// failed or was skipped because its circuit breaker is open.
+var ErrNoBackends = errors.New("no chat bot backend available")
+
+// Backend is a chat bot that FallbackChatBot can fall back to.
+type Backend interface {
+	Respond(ctx context.Context, req Request) (Response, error)
+}
+
+// StreamingBackend is a Backend that can deliver its reply incrementally.
+type StreamingBackend interface {
+	Backend
+	RespondStream(ctx context.Context, req Request, onDelta func(delta string) error) (Response, error)
+}
+
+// NamedBackend is a Backend identified by name in logs.
+type NamedBackend struct {
+	Name    string
+	Backend Backend
+	// Canned marks a backend that doesn't call an AI model, such as the
+	// static bot. It doesn't count towards Healthy.
+	Canned bool
+}
+
+// probePrompt is sent to a backend whose circuit is open to find out whether
+// it has recovered.
+const probePrompt = "Reply with OK."
+
+// NewFallbackChatBot creates a FallbackChatBot that tries backends in order.
+// A backend is skipped after threshold consecutive failures, and probed
+// again once cooldown has passed.
+func NewFallbackChatBot(logger *slog.Logger, threshold int, cooldown time.Duration, backends ...NamedBackend) *FallbackChatBot {
+	fb := &FallbackChatBot{logger: logger}
+	for _, b := range backends {
+		fb.backends = append(fb.backends, &fallbackBackend{
+			NamedBackend: b,
+			breaker:      &circuitBreaker{threshold: threshold, cooldown: cooldown},
+		})
+	}
+	return fb
+}
+
+// FallbackChatBot is a chat bot that tries each of its backends in turn
+// until one replies. Each backend has a circuit breaker, so a backend that's
+// down doesn't slow down every reply.
+type FallbackChatBot struct {
+	logger   *slog.Logger
+	backends []*fallbackBackend
+}
+
+type fallbackBackend struct {
+	NamedBackend
+	breaker *circuitBreaker
+}
+
+// Respond returns the reply of the first backend that succeeds.
+func (f *FallbackChatBot) Respond(ctx context.Context, req Request) (Response, error) {
+	return f.try(ctx, func(b Backend) (Response, bool, error) {
+		resp, err := b.Respond(ctx, req)
+		return resp, false, err
+	})
+}
+
+// RespondStream streams the reply of the first backend that succeeds. A
+// backend that doesn't stream delivers its whole reply as a single delta.
+// Once part of a reply has been delivered, a failure can't be recovered from
+// by switching to another backend, so the error is returned.
+func (f *FallbackChatBot) RespondStream(ctx context.Context, req Request, onDelta func(delta string) error) (Response, error) {
+	return f.try(ctx, func(b Backend) (Response, bool, error) {
+		streamer, canStream := b.(StreamingBackend)
+		if !canStream {
+			resp, err := b.Respond(ctx, req)
+			if err != nil {
+				return resp, false, err
+			}
+			return resp, true, onDelta(resp.Text)
+		}
+
+		var started bool
+		resp, err := streamer.RespondStream(ctx, req, func(delta string) error {
+			started = true
+			return onDelta(delta)
+		})
+		return resp, started, err
+	})
+}
+
+// try calls respond with each available backend until one succeeds. respond
+// reports whether any of the reply reached the user, in which case trying
+// another backend would garble the reply.
+func (f *FallbackChatBot) try(ctx context.Context, respond func(Backend) (Response, bool, error)) (Response, error) {
+	var errs []error
+	for _, b := range f.backends {
+		if !b.breaker.allow() {
+			continue
+		}
+
+		resp, delivered, err := respond(b.Backend)
+		if err == nil {
+			b.breaker.success()
+			return resp, nil
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// the backend was too slow, and there's no time left to try
+			// another one
+			if b.breaker.failure() {
+				f.logger.Warn("chat bot backend too slow, circuit opened", "backend", b.Name)
+			}
+			return Response{}, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			// the caller gave up, which says nothing about the backend
+			b.breaker.cancel()
+			return Response{}, ctx.Err()
+		}
+
+		switch {
+		case !backendFailure(err):
+			// the request was at fault rather than the backend
+			b.breaker.cancel()
+			f.logger.Warn("chat bot backend failed", "backend", b.Name, "err", err.Error())
+		case b.breaker.failure():
+			f.logger.Warn("chat bot backend failing, circuit opened", "backend", b.Name, "err", err.Error())
+		default:
+			f.logger.Warn("chat bot backend failed", "backend", b.Name, "err", err.Error())
+		}
+		if delivered {
+			return Response{}, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+	}
+	if len(errs) == 0 {
+		return Response{}, fmt.Errorf("%w: all circuits open", ErrNoBackends)
+	}
+	return Response{}, fmt.Errorf("%w: %w", ErrNoBackends, errors.Join(errs...))
+}
+
+// backendFailure reports whether err means that the backend is failing, as
+// opposed to rejecting the request. Only backend failures count towards
+// opening its circuit. A malformed reply counts too: a one-off is forgiven by
+// the next success, but a proxy that answers every request with garbage is
+// as good as down.
+func backendFailure(err error) bool {
+	kind, ok := ErrorKindOf(err)
+	if !ok {
+		return false
+	}
+	switch kind {
+	case ErrorKindServer, ErrorKindRateLimited, ErrorKindAuth, ErrorKindMalformed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Healthy reports whether at least one backend that calls an AI model has a
+// closed circuit.
+func (f *FallbackChatBot) Healthy() bool {
+	var models int
+	for _, b := range f.backends {
+		if b.Canned {
+			continue
+		}
+		if b.breaker.closed() {
+			return true
+		}
+		models++
+	}
+	return models == 0
+}
+
+// Probe sends a short request to each AI model backend whose circuit is open
+// and whose cooldown has passed, so that a backend that has recovered is
+// noticed without waiting for a user's message to probe it. It returns the
+// combined usage and cost of the probes.
+func (f *FallbackChatBot) Probe(ctx context.Context) Response {
+	var total Response
+	for _, b := range f.backends {
+		if b.Canned || b.breaker.closed() || !b.breaker.allow() {
+			continue
+		}
+		resp, err := b.Backend.Respond(ctx, Request{Text: probePrompt, ReceivedAt: time.Now()})
+		total.Usage = total.Usage.Add(resp.Usage)
+		total.Cost += resp.Cost
+		switch {
+		case err == nil || (ctx.Err() == nil && !backendFailure(err)):
+			// an API that rejects the probe is still up
+			b.breaker.success()
+			f.logger.Info("chat bot backend recovered, circuit closed", "backend", b.Name)
+		case ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded):
+			b.breaker.cancel()
+		default:
+			b.breaker.failure()
+			f.logger.Debug("chat bot backend still failing", "backend", b.Name, "err", err.Error())
+		}
+	}
+	return total
+}
+
+// Prompt returns the system prompt of the first backend that has one.
+func (f *FallbackChatBot) Prompt() string {
+	for _, b := range f.backends {
+		if editor, ok := b.Backend.(PromptEditor); ok {
+			return editor.Prompt()
+		}
+	}
+	return ""
+}
+
+// SetPrompt replaces the system prompt of every backend that has one.
+func (f *FallbackChatBot) SetPrompt(prompt string) {
+	for _, b := range f.backends {
+		if editor, ok := b.Backend.(PromptEditor); ok {
+			editor.SetPrompt(prompt)
+		}
+	}
+}
+
+// circuitBreaker tracks the health of a backend. After threshold consecutive
+// failures the circuit opens and calls are rejected. Once cooldown has
+// passed, a single probe call is let through. If it succeeds the circuit
+// closes, otherwise it stays open for another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a call may be made.
+func (c *circuitBreaker) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.threshold <= 0 || c.failures < c.threshold {
+		return true
+	}
+	if c.probing || time.Since(c.openedAt) < c.cooldown {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// success records a successful call, closing the circuit.
+func (c *circuitBreaker) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.probing = false
+}
+
+// failure records a failed call. It returns true if the call opened the
+// circuit.
+func (c *circuitBreaker) failure() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wasOpen := c.threshold > 0 && c.failures >= c.threshold
+	c.failures++
+	c.probing = false
+	if c.threshold > 0 && c.failures >= c.threshold {
+		c.openedAt = time.Now()
+		return !wasOpen
+	}
+	return false
+}
+
+// cancel records a call that was abandoned by the caller. It lets another
+// probe through if the abandoned call was one.
+func (c *circuitBreaker) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+// closed reports whether the circuit is closed.
+func (c *circuitBreaker) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.threshold <= 0 || c.failures < c.threshold
+}
@@ -0,0 +1,253 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// each step is a call: whether the breaker allowed it, and if so, how
+	// the call went
+	type step struct {
+		wait       bool
+		wantAllow  bool
+		succeed    bool
+		wantOpened bool
+		wantClosed bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "opens after threshold failures",
+			threshold: 2,
+			steps: []step{
+				{wantAllow: true, wantClosed: true},
+				{wantAllow: true, wantOpened: true},
+				{wantAllow: false},
+			},
+		},
+		{
+			name:      "success resets the failure count",
+			threshold: 2,
+			steps: []step{
+				{wantAllow: true, wantClosed: true},
+				{wantAllow: true, succeed: true, wantClosed: true},
+				{wantAllow: true, wantClosed: true},
+				{wantAllow: true, wantOpened: true},
+			},
+		},
+		{
+			name:      "successful probe after cooldown closes the circuit",
+			threshold: 1,
+			steps: []step{
+				{wantAllow: true, wantOpened: true},
+				{wantAllow: false},
+				{wait: true, wantAllow: true, succeed: true, wantClosed: true},
+				{wantAllow: true, succeed: true, wantClosed: true},
+			},
+		},
+		{
+			name:      "failed probe keeps the circuit open",
+			threshold: 1,
+			steps: []step{
+				{wantAllow: true, wantOpened: true},
+				{wait: true, wantAllow: true},
+				{wantAllow: false},
+				{wait: true, wantAllow: true, succeed: true, wantClosed: true},
+			},
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{wantAllow: true, wantClosed: true},
+				{wantAllow: true, wantClosed: true},
+				{wantAllow: true, wantClosed: true},
+			},
+		},
+	}
+	const cooldown = 20 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &circuitBreaker{threshold: tt.threshold, cooldown: cooldown}
+			for i, s := range tt.steps {
+				if s.wait {
+					time.Sleep(cooldown)
+				}
+				if got := c.allow(); got != s.wantAllow {
+					t.Fatalf("step %d: allow() = %v, want %v", i, got, s.wantAllow)
+				}
+				if !s.wantAllow {
+					continue
+				}
+				if s.succeed {
+					c.success()
+				} else if opened := c.failure(); opened != s.wantOpened {
+					t.Fatalf("step %d: failure() = %v, want %v", i, opened, s.wantOpened)
+				}
+				if got := c.closed(); got != s.wantClosed {
+					t.Fatalf("step %d: closed() = %v, want %v", i, got, s.wantClosed)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	c := &circuitBreaker{threshold: 1, cooldown: time.Millisecond}
+	c.allow()
+	c.failure()
+	time.Sleep(2 * time.Millisecond)
+
+	if !c.allow() {
+		t.Fatal("allow() = false after cooldown, want a probe")
+	}
+	if c.allow() {
+		t.Fatal("allow() = true while probing, want false")
+	}
+	c.cancel()
+	if !c.allow() {
+		t.Fatal("allow() = false after the probe was abandoned, want another probe")
+	}
+}
+
+// failingBackend fails every request with err.
+type failingBackend struct {
+	err   error
+	calls int
+}
+
+func (b *failingBackend) Respond(context.Context, Request) (Response, error) {
+	b.calls++
+	return Response{}, b.err
+}
+
+// cannedBackend replies to every request.
+type cannedBackend struct{}
+
+func (cannedBackend) Respond(context.Context, Request) (Response, error) {
+	return Response{Text: "hi2u"}, nil
+}
+
+func TestFallbackChatBotOnlyCountsBackendFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantOpen bool
+	}{
+		{name: "server", err: &APIError{Kind: ErrorKindServer, StatusCode: 503}, wantOpen: true},
+		{name: "transport", err: &APIError{Kind: ErrorKindServer, Err: errors.New("connection refused")}, wantOpen: true},
+		{name: "rate limited", err: &APIError{Kind: ErrorKindRateLimited, StatusCode: 429}, wantOpen: true},
+		{name: "auth", err: &APIError{Kind: ErrorKindAuth, StatusCode: 401}, wantOpen: true},
+		{name: "request", err: &APIError{Kind: ErrorKindRequest, StatusCode: 400}},
+		{name: "malformed", err: malformed(errors.New("unexpected EOF")), wantOpen: true},
+		{name: "other", err: errors.New("unable to send IM")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &failingBackend{err: tt.err}
+			fb := NewFallbackChatBot(slog.New(slog.NewTextHandler(io.Discard, nil)), 2, time.Hour,
+				NamedBackend{Name: "primary", Backend: backend})
+			for i := 0; i < 3; i++ {
+				if _, err := fb.Respond(context.Background(), Request{Text: "hi"}); err == nil {
+					t.Fatal("Respond() succeeded, want an error")
+				}
+			}
+			if open := !fb.Healthy(); open != tt.wantOpen {
+				t.Errorf("circuit open = %v, want %v", open, tt.wantOpen)
+			}
+			if wantCalls := map[bool]int{true: 2, false: 3}[tt.wantOpen]; backend.calls != wantCalls {
+				t.Errorf("backend called %d times, want %d", backend.calls, wantCalls)
+			}
+		})
+	}
+}
+
+func TestFallbackChatBotHealthyIgnoresCannedBackends(t *testing.T) {
+	primary := &failingBackend{err: &APIError{Kind: ErrorKindServer, StatusCode: 503}}
+	fb := NewFallbackChatBot(slog.New(slog.NewTextHandler(io.Discard, nil)), 1, time.Hour,
+		NamedBackend{Name: "primary", Backend: primary},
+		NamedBackend{Name: "static", Backend: cannedBackend{}, Canned: true})
+
+	if !fb.Healthy() {
+		t.Fatal("Healthy() = false before any failure, want true")
+	}
+	if _, err := fb.Respond(context.Background(), Request{Text: "hi"}); err != nil {
+		t.Fatalf("Respond() error = %v, want the static backend's reply", err)
+	}
+	if fb.Healthy() {
+		t.Error("Healthy() = true with only the static backend up, want false")
+	}
+}
+
+// recoveringBackend fails with a server error until it's told to recover.
+type recoveringBackend struct {
+	recovered bool
+}
+
+func (b *recoveringBackend) Respond(context.Context, Request) (Response, error) {
+	if !b.recovered {
+		return Response{}, &APIError{Kind: ErrorKindServer, StatusCode: 503}
+	}
+	return Response{Text: "OK", Usage: Usage{PromptTokens: 12, CompletionTokens: 1}, Cost: 0.5}, nil
+}
+
+func TestFallbackChatBotProbe(t *testing.T) {
+	primary := &recoveringBackend{}
+	fb := NewFallbackChatBot(slog.New(slog.NewTextHandler(io.Discard, nil)), 1, time.Millisecond,
+		NamedBackend{Name: "primary", Backend: primary})
+
+	if _, err := fb.Respond(context.Background(), Request{Text: "hi"}); err == nil {
+		t.Fatal("Respond() succeeded, want an error")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if resp := fb.Probe(context.Background()); resp.Usage.Total() != 0 || fb.Healthy() {
+		t.Fatalf("Probe() of a failing backend = %+v, healthy = %v; want no usage, unhealthy", resp, fb.Healthy())
+	}
+
+	primary.recovered = true
+	time.Sleep(2 * time.Millisecond)
+	resp := fb.Probe(context.Background())
+	if !fb.Healthy() {
+		t.Error("Healthy() = false after a successful probe, want true")
+	}
+	if want := (Usage{PromptTokens: 12, CompletionTokens: 1}); resp.Usage != want || resp.Cost != 0.5 {
+		t.Errorf("Probe() = %+v, want usage %+v and cost 0.5", resp, want)
+	}
+
+	// a healthy backend isn't probed
+	if resp := fb.Probe(context.Background()); resp.Usage.Total() != 0 {
+		t.Errorf("Probe() of a healthy backend = %+v, want no usage", resp)
+	}
+}
+
+// flakyBackend sends a malformed reply every other request.
+type flakyBackend struct {
+	calls int
+}
+
+func (b *flakyBackend) Respond(context.Context, Request) (Response, error) {
+	b.calls++
+	if b.calls%2 == 1 {
+		return Response{}, malformed(errors.New("unexpected EOF"))
+	}
+	return Response{Text: "hi2u"}, nil
+}
+
+func TestFallbackChatBotForgivesOccasionalMalformedReplies(t *testing.T) {
+	fb := NewFallbackChatBot(slog.New(slog.NewTextHandler(io.Discard, nil)), 2, time.Hour,
+		NamedBackend{Name: "primary", Backend: &flakyBackend{}})
+	for i := 0; i < 6; i++ {
+		_, _ = fb.Respond(context.Background(), Request{Text: "hi"})
+	}
+	if !fb.Healthy() {
+		t.Error("circuit opened on malformed replies interleaved with good ones")
+	}
+}
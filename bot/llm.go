@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// NewLLMChatBot creates an LLMChatBot that gets its replies from provider.
+// The model may call the tools in tools while composing a reply. tools may be
+// nil. The cost of each reply is calculated from prices.
+func NewLLMChatBot(cfg config.Config, provider Provider, tools *ToolRegistry, prices PriceTable) *LLMChatBot {
+	return &LLMChatBot{
+		provider:    provider,
+		model:       cfg.Model,
+		prices:      prices,
+		prompt:      cfg.BotPrompt,
+		tools:       tools,
+		maxToolIter: cfg.MaxToolIterations,
+	}
+}
+
+// LLMChatBot is a chat bot backed by a large language model. It builds the
+// prompt from the request and runs any tools the model calls, leaving the
+// details of the upstream API to its Provider.
+type LLMChatBot struct {
+	provider Provider
+	// model is the configured model, used to price replies when the
+	// upstream API doesn't say which model it used.
+	model  string
+	prices PriceTable
+	// prompt is the system prompt, which can be changed while the bot is
+	// running.
+	prompt   string
+	promptMu sync.RWMutex
+	tools    *ToolRegistry
+	// maxToolIter is the number of rounds of tool calls the model may make
+	// before it must reply.
+	maxToolIter int
+}
+
+// ExchangeMessage sends a message to the model without a deadline or any
+// information about the user.
+func (b *LLMChatBot) ExchangeMessage(send string, history []store.Turn) (receive string, err error) {
+	resp, err := b.Respond(context.Background(), Request{
+		Text:       send,
+		History:    history,
+		Channel:    ChannelIM,
+		ReceivedAt: time.Now(),
+	})
+	return resp.Text, err
+}
+
+// Respond sends the user's message and conversation history to the model.
+// The upstream request is abandoned if ctx is cancelled.
+func (b *LLMChatBot) Respond(ctx context.Context, r Request) (Response, error) {
+	return b.runTools(ctx, r, b.provider.Complete)
+}
+
+// RespondStream is like Respond, except that the reply is streamed from the
+// upstream API. onDelta is called with each fragment of text as it arrives.
+// If onDelta returns an error, the stream is abandoned and the error is
+// returned.
+func (b *LLMChatBot) RespondStream(ctx context.Context, r Request, onDelta func(delta string) error) (Response, error) {
+	return b.runTools(ctx, r, func(ctx context.Context, c Completion) (Message, error) {
+		return b.provider.CompleteStream(ctx, c, onDelta)
+	})
+}
+
+// runTools asks the model for a reply using complete. Whenever the model
+// calls tools instead of replying, the tools are run and their results are
+// sent back to the model, until the model replies or runs out of tool call
+// rounds.
+func (b *LLMChatBot) runTools(ctx context.Context, r Request, complete func(context.Context, Completion) (Message, error)) (Response, error) {
+	c := b.newCompletion(r)
+	var usage Usage
+	for round := 0; ; round++ {
+		if round >= b.maxToolIter && len(c.Tools) > 0 {
+			// out of tool call rounds, make the model reply with what it has
+			c.DisableTools = true
+		}
+
+		msg, err := complete(ctx, c)
+		if err != nil {
+			return Response{}, err
+		}
+		usage = usage.Add(msg.Usage)
+		if len(msg.ToolCalls) == 0 || c.DisableTools {
+			resp := Response{
+				Text:  msg.Content,
+				Model: msg.Model,
+				Usage: usage,
+			}
+			if resp.Model == "" {
+				resp.Model = b.model
+			}
+			resp.Cost = b.prices.Cost(resp.Model, usage)
+			if resp.Text == "" {
+				resp.Text = "No response available."
+			}
+			return resp, nil
+		}
+
+		msg.Role = RoleAssistant
+		c.Messages = append(c.Messages, msg)
+		for _, call := range msg.ToolCalls {
+			c.Messages = append(c.Messages, Message{
+				Role:       RoleTool,
+				Content:    b.tools.call(ctx, r, call.Name, call.Arguments),
+				ToolCallID: call.ID,
+				ToolName:   call.Name,
+			})
+		}
+	}
+}
+
+// PromptEditor is a chat bot whose system prompt can be changed while it's
+// running.
+type PromptEditor interface {
+	// Prompt returns the system prompt.
+	Prompt() string
+	// SetPrompt replaces the system prompt. Replies already in progress keep
+	// the old prompt.
+	SetPrompt(prompt string)
+}
+
+func (b *LLMChatBot) Prompt() string {
+	b.promptMu.RLock()
+	defer b.promptMu.RUnlock()
+	return b.prompt
+}
+
+func (b *LLMChatBot) SetPrompt(prompt string) {
+	b.promptMu.Lock()
+	defer b.promptMu.Unlock()
+	b.prompt = prompt
+}
+
+// newCompletion builds a completion request that contains the system prompt,
+// the conversation history and the user's latest message.
+func (b *LLMChatBot) newCompletion(r Request) Completion {
+	prompt := b.Prompt()
+	if r.ScreenName != "" {
+		prompt += fmt.Sprintf("\nYou are chatting with the AIM user %s.", r.ScreenName)
+	}
+	if r.Channel == ChannelChatRoom {
+		prompt += fmt.Sprintf("\nYou are in the AIM chat room \"%s\" with several other users. Keep your replies short.", r.ChatRoom)
+	}
+	if r.Persona != "" {
+		prompt += fmt.Sprintf("\nThe user has asked you to stay in character as the following persona: %s", r.Persona)
+	}
+	if r.Presence != nil {
+		prompt += fmt.Sprintf("\nAccording to your buddy list, %s.", describeBuddy(*r.Presence))
+	}
+	if r.Profile != nil {
+		if r.Profile.Profile != "" {
+			prompt += fmt.Sprintf("\nThe user's AIM profile says: %q. Feel free to bring up their interests.", r.Profile.Profile)
+		}
+		if r.Profile.AwayMessage != "" {
+			prompt += fmt.Sprintf("\nThe user's away message says: %q.", r.Profile.AwayMessage)
+		}
+	}
+
+	var messages []Message
+	for _, turn := range r.History {
+		messages = append(messages,
+			Message{Role: RoleUser, Content: turn.User},
+			Message{Role: RoleAssistant, Content: turn.Bot},
+		)
+	}
+	messages = append(messages, Message{Role: RoleUser, Content: r.Text})
+
+	return Completion{
+		System:   prompt,
+		Messages: messages,
+		Tools:    b.tools.list(),
+		User:     r.ScreenName,
+	}
+}
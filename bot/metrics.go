@@ -0,0 +1,12 @@
+package bot
+
+import "github.com/mk6i/smarter-smarter-child/metrics"
+
+var (
+	upstreamDuration = metrics.NewHistogram("ssc_upstream_request_duration_seconds",
+		"Time until the AI model API sends response headers, per request attempt.", metrics.DefaultBuckets, "provider")
+	upstreamResponses = metrics.NewCounter("ssc_upstream_responses_total",
+		"Responses from the AI model API, by HTTP status code. Requests that got no response have code \"none\".", "provider", "code")
+	upstreamErrors = metrics.NewCounter("ssc_upstream_errors_total",
+		"AI model API calls that failed after any retries, by kind of error.", "provider", "kind")
+)
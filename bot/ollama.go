@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+// defaultOllamaURL is the chat endpoint of a local Ollama server.
+const defaultOllamaURL = "http://localhost:11434/api/chat"
+
+type ollamaRequest struct {
+	Model    string           `json:"model"`
+	Messages []ollamaMessage  `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    []toolDefinition `json:"tools,omitempty"`
+	Options  ollamaOptions    `json:"options"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name string `json:"name"`
+		// Arguments is a JSON object, unlike the JSON-encoded string used
+		// by OpenAI.
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	TopP        float64 `json:"top_p"`
+}
+
+// ollamaResponse is a complete response, or one line of a streamed response.
+type ollamaResponse struct {
+	Model   string        `json:"model"`
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+	// PromptEvalCount and EvalCount are the prompt and completion token
+	// counts. They're only set on the last line of a streamed response.
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// NewOllamaProvider creates a provider for the Ollama chat API.
+func NewOllamaProvider(cfg config.Config) *OllamaProvider {
+	apiURL := cfg.APIUrl
+	if apiURL == "" {
+		apiURL = defaultOllamaURL
+	}
+	return &OllamaProvider{
+		model:       cfg.Model,
+		temperature: cfg.Temperature,
+		topP:        cfg.TopP,
+		apiURL:      apiURL,
+		client:      &http.Client{Timeout: cfg.APITimeout},
+		retry:       newRetryPolicy(cfg),
+	}
+}
+
+// OllamaProvider requests completions from a local Ollama server.
+type OllamaProvider struct {
+	model       string
+	temperature float64
+	topP        float64
+	apiURL      string
+	client      *http.Client
+	retry       retryPolicy
+}
+
+func (p *OllamaProvider) Complete(ctx context.Context, c Completion) (Message, error) {
+	resp, err := p.post(ctx, p.newRequest(c, false))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var response ollamaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Message{}, malformed(err)
+	}
+	if response.Error != "" {
+		return Message{}, &APIError{Kind: ErrorKindServer, StatusCode: resp.StatusCode, Message: response.Error}
+	}
+	msg := fromOllamaMessage(response.Message)
+	msg.Model = response.Model
+	msg.Usage = response.toUsage()
+	return msg, nil
+}
+
+func (p *OllamaProvider) CompleteStream(ctx context.Context, c Completion, onDelta func(delta string) error) (Message, error) {
+	resp, err := p.post(ctx, p.newRequest(c, true))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	// The response is a series of JSON objects, one per line, the last of
+	// which is marked done.
+	reply := ollamaMessage{Role: "assistant"}
+	var last ollamaResponse
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return Message{}, malformed(fmt.Errorf("unable to parse completion chunk: %w", err))
+		}
+		if chunk.Error != "" {
+			return Message{}, &APIError{Kind: ErrorKindServer, StatusCode: resp.StatusCode, Message: chunk.Error}
+		}
+		reply.ToolCalls = append(reply.ToolCalls, chunk.Message.ToolCalls...)
+		if delta := chunk.Message.Content; delta != "" {
+			reply.Content += delta
+			if err := onDelta(delta); err != nil {
+				return Message{}, err
+			}
+		}
+		if chunk.Done {
+			last = chunk
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Message{}, fmt.Errorf("unable to read completion stream: %w", err)
+	}
+
+	msg := fromOllamaMessage(reply)
+	msg.Model = last.Model
+	msg.Usage = last.toUsage()
+	return msg, nil
+}
+
+func (r ollamaResponse) toUsage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+// newRequest converts c to the Ollama chat format.
+func (p *OllamaProvider) newRequest(c Completion, stream bool) ollamaRequest {
+	req := ollamaRequest{
+		Model:  p.model,
+		Stream: stream,
+		Options: ollamaOptions{
+			Temperature: p.temperature,
+			TopP:        p.topP,
+		},
+		Messages: []ollamaMessage{
+			{
+				Role:    "system",
+				Content: c.System,
+			},
+		},
+	}
+	for _, m := range c.Messages {
+		msg := ollamaMessage{
+			Role:     string(m.Role),
+			Content:  m.Content,
+			ToolName: m.ToolName,
+		}
+		for _, call := range m.ToolCalls {
+			var tc ollamaToolCall
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = json.RawMessage(call.Arguments)
+			if len(tc.Function.Arguments) == 0 {
+				tc.Function.Arguments = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+
+	// Ollama has no way to forbid tool calls, so leave the tools out
+	// instead
+	if !c.DisableTools {
+		for _, tool := range c.Tools {
+			req.Tools = append(req.Tools, toolDefinition{
+				Type: "function",
+				Function: functionDefinition{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  tool.Parameters,
+				},
+			})
+		}
+	}
+	return req
+}
+
+// fromOllamaMessage converts an Ollama chat message to a Message. Ollama
+// doesn't assign tool call IDs, so they're numbered here.
+func fromOllamaMessage(m ollamaMessage) Message {
+	msg := Message{
+		Role:    RoleAssistant,
+		Content: m.Content,
+	}
+	for i, call := range m.ToolCalls {
+		args := string(call.Function.Arguments)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d", i),
+			Name:      call.Function.Name,
+			Arguments: args,
+		})
+	}
+	return msg
+}
+
+// post sends a request to the Ollama chat API, retrying transient failures.
+func (p *OllamaProvider) post(ctx context.Context, data ollamaRequest) (*http.Response, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.retry.do(ctx, p.client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}, ollamaError)
+}
+
+// ollamaError classifies an unsuccessful response from the Ollama chat API.
+func ollamaError(resp *http.Response, body []byte) *APIError {
+	var response ollamaResponse
+	_ = json.Unmarshal(body, &response) // error bodies aren't always JSON
+	return newStatusError(resp, response.Error)
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+func newTestOllamaProvider(url string) *OllamaProvider {
+	return NewOllamaProvider(config.Config{
+		APIUrl:      url,
+		Model:       "llama3.2",
+		Temperature: 0.5,
+		TopP:        0.9,
+	})
+}
+
+func TestOllamaProviderComplete(t *testing.T) {
+	srv, lastRequest := newUpstream(t, "application/json", `{
+		"model": "llama3.2",
+		"message": {
+			"role": "assistant",
+			"content": "",
+			"tool_calls": [{"function": {"name": "calc", "arguments": {"expr": "1+1"}}}]
+		},
+		"done": true,
+		"prompt_eval_count": 30,
+		"eval_count": 4
+	}`)
+
+	msg, err := newTestOllamaProvider(srv.URL).Complete(context.Background(), Completion{
+		System: "be nice",
+		Messages: []Message{
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_0", Name: "time"}}},
+			{Role: RoleTool, ToolName: "time", Content: "noon"},
+		},
+		Tools:        []Tool{{Name: "calc", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		DisableTools: true,
+	})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	req := lastRequest()
+	if got := req.header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want none", got)
+	}
+	var body ollamaRequest
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("unable to parse request body: %v", err)
+	}
+	if body.Model != "llama3.2" || body.Stream || body.Options.Temperature != 0.5 || body.Options.TopP != 0.9 {
+		t.Errorf("unexpected request settings: %+v", body)
+	}
+	if len(body.Tools) != 0 {
+		t.Errorf("tools = %+v, want none since tools are disabled", body.Tools)
+	}
+	if len(body.Messages) != 4 || body.Messages[0].Role != "system" || body.Messages[0].Content != "be nice" {
+		t.Fatalf("messages = %+v, want the system prompt and 3 messages", body.Messages)
+	}
+	if m := body.Messages[2]; len(m.ToolCalls) != 1 || string(m.ToolCalls[0].Function.Arguments) != "{}" {
+		t.Errorf("assistant message = %+v, want a time call with empty arguments", m)
+	}
+	if m := body.Messages[3]; m.Role != "tool" || m.ToolName != "time" {
+		t.Errorf("tool message = %+v, want the result of time", m)
+	}
+
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0] != (ToolCall{ID: "call_0", Name: "calc", Arguments: `{"expr": "1+1"}`}) {
+		t.Errorf("tool calls = %+v, want a numbered calc call", msg.ToolCalls)
+	}
+	if want := (Usage{PromptTokens: 30, CompletionTokens: 4}); msg.Usage != want {
+		t.Errorf("usage = %+v, want %+v", msg.Usage, want)
+	}
+}
+
+func TestOllamaProviderCompleteStream(t *testing.T) {
+	stream := `{"model":"llama3.2","message":{"role":"assistant","content":"hi"},"done":false}
+{"model":"llama3.2","message":{"role":"assistant","content":"2u"},"done":false}
+{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":8,"eval_count":2}
+`
+	srv, lastRequest := newUpstream(t, "application/x-ndjson", stream)
+
+	var deltas []string
+	msg, err := newTestOllamaProvider(srv.URL).CompleteStream(context.Background(), Completion{
+		Messages: []Message{{Role: RoleUser, Content: "hello"}},
+	}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CompleteStream() error = %v", err)
+	}
+
+	var body ollamaRequest
+	if err := json.Unmarshal(lastRequest().body, &body); err != nil {
+		t.Fatalf("unable to parse request body: %v", err)
+	}
+	if !body.Stream {
+		t.Error("request doesn't ask for a stream")
+	}
+	if got := strings.Join(deltas, "|"); got != "hi|2u" {
+		t.Errorf("deltas = %q, want %q", got, "hi|2u")
+	}
+	if msg.Content != "hi2u" || msg.Model != "llama3.2" {
+		t.Errorf("message = %+v, want the streamed text and model", msg)
+	}
+	if want := (Usage{PromptTokens: 8, CompletionTokens: 2}); msg.Usage != want {
+		t.Errorf("usage = %+v, want %+v", msg.Usage, want)
+	}
+}
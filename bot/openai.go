@@ -0,0 +1,338 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+// defaultOpenAIURL is the OpenAI chat completions endpoint.
+const defaultOpenAIURL = "https://api.openai.com/v1/chat/completions"
+
+type chatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	TopP        float64   `json:"top_p"`
+	User        string    `json:"user,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+	// StreamOptions asks for token usage at the end of a streamed response.
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+	// Tools lists the functions the model may call.
+	Tools []toolDefinition `json:"tools,omitempty"`
+	// ToolChoice controls whether the model may call tools. "none" forces a
+	// text reply.
+	ToolChoice string `json:"tool_choice,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// ToolCalls are the tools the model wants to call. Only set on
+	// assistant messages.
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	// ToolCallID identifies the call a tool message is the result of.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+type toolDefinition struct {
+	Type     string             `json:"type"`
+	Function functionDefinition `json:"function"`
+}
+
+type functionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type toolCall struct {
+	// Index is the position of the call in the assistant message. It's only
+	// set in streamed responses, where a call is sent across several chunks.
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type completionResponse struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Usage   usage    `json:"usage"`
+	Choices []choice `json:"choices"`
+}
+
+// completionChunk is a fragment of a streamed chat completion.
+type completionChunk struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	// Usage is only set on the last chunk, which has no choices.
+	Usage   *usage `json:"usage"`
+	Choices []struct {
+		Delta        message `json:"delta"`
+		FinishReason string  `json:"finish_reason"`
+		Index        int     `json:"index"`
+	} `json:"choices"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    string      `json:"code"`
+	} `json:"error"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u usage) toUsage() Usage {
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+type choice struct {
+	Message      message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+	Index        int     `json:"index"`
+}
+
+// NewOpenAIProvider creates a provider for the OpenAI chat completions API.
+// It also works with other servers that implement the same API, such as
+// llama.cpp, vLLM and OpenRouter.
+func NewOpenAIProvider(cfg config.Config) *OpenAIProvider {
+	apiURL := cfg.APIUrl
+	if apiURL == "" {
+		apiURL = defaultOpenAIURL
+	}
+	return &OpenAIProvider{
+		secretKey:   cfg.OpenAIKey,
+		model:       cfg.Model,
+		temperature: cfg.Temperature,
+		topP:        cfg.TopP,
+		apiURL:      apiURL,
+		client:      &http.Client{Timeout: cfg.APITimeout},
+		retry:       newRetryPolicy(cfg),
+	}
+}
+
+// OpenAIProvider requests completions from an OpenAI-compatible chat
+// completions API.
+type OpenAIProvider struct {
+	secretKey   string
+	model       string
+	temperature float64
+	topP        float64
+	apiURL      string
+	client      *http.Client
+	retry       retryPolicy
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, c Completion) (Message, error) {
+	jsonData, err := json.Marshal(p.newChatRequest(c))
+	if err != nil {
+		return Message{}, err
+	}
+
+	resp, err := p.post(ctx, jsonData)
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var response completionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Message{}, malformed(err)
+	}
+
+	msg := Message{Role: RoleAssistant}
+	if len(response.Choices) > 0 {
+		msg = fromOpenAIMessage(response.Choices[0].Message)
+	}
+	msg.Model = response.Model
+	msg.Usage = response.Usage.toUsage()
+	return msg, nil
+}
+
+func (p *OpenAIProvider) CompleteStream(ctx context.Context, c Completion, onDelta func(delta string) error) (Message, error) {
+	data := p.newChatRequest(c)
+	data.Stream = true
+	data.StreamOptions = &streamOptions{IncludeUsage: true}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	resp, err := p.post(ctx, jsonData)
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	// The response is a series of server-sent events, each of which holds a
+	// JSON-encoded completion chunk. The stream ends with a [DONE] event.
+	reply := message{Role: "assistant"}
+	var model string
+	var tokens usage
+	err = readEvents(resp.Body, func(data []byte) error {
+		var chunk completionChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return malformed(fmt.Errorf("unable to parse completion chunk: %w", err))
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			tokens = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+
+		// tool calls arrive in pieces, the first of which carries the call
+		// ID and function name
+		for _, delta := range chunk.Choices[0].Delta.ToolCalls {
+			if delta.Index == nil {
+				continue
+			}
+			i := *delta.Index
+			if i < 0 || i > len(reply.ToolCalls) {
+				return fmt.Errorf("tool call index %d out of order", i)
+			}
+			if i == len(reply.ToolCalls) {
+				reply.ToolCalls = append(reply.ToolCalls, toolCall{ID: delta.ID, Type: delta.Type})
+				reply.ToolCalls[i].Function.Name = delta.Function.Name
+			}
+			reply.ToolCalls[i].Function.Arguments += delta.Function.Arguments
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			return nil
+		}
+		reply.Content += delta
+		return onDelta(delta)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := fromOpenAIMessage(reply)
+	msg.Model = model
+	msg.Usage = tokens.toUsage()
+	return msg, nil
+}
+
+// newChatRequest converts c to the chat completions format.
+func (p *OpenAIProvider) newChatRequest(c Completion) chatRequest {
+	messages := []message{
+		{
+			Role:    "system",
+			Content: c.System,
+		},
+	}
+	for _, m := range c.Messages {
+		msg := message{
+			Role:       string(m.Role),
+			Content:    m.Content,
+			ToolCallID: m.ToolCallID,
+		}
+		for _, call := range m.ToolCalls {
+			tc := toolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = call.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		messages = append(messages, msg)
+	}
+
+	req := chatRequest{
+		Model:       p.model,
+		Messages:    messages,
+		Temperature: p.temperature,
+		TopP:        p.topP,
+		User:        c.User,
+	}
+	for _, tool := range c.Tools {
+		req.Tools = append(req.Tools, toolDefinition{
+			Type: "function",
+			Function: functionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if c.DisableTools && len(req.Tools) > 0 {
+		req.ToolChoice = "none"
+	}
+	return req
+}
+
+// fromOpenAIMessage converts a chat completions message to a Message.
+func fromOpenAIMessage(m message) Message {
+	msg := Message{
+		Role:    Role(m.Role),
+		Content: m.Content,
+	}
+	for _, call := range m.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return msg
+}
+
+// post sends a JSON-encoded request body to the upstream API, retrying
+// transient failures.
+func (p *OpenAIProvider) post(ctx context.Context, body []byte) (*http.Response, error) {
+	return p.retry.do(ctx, p.client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if p.secretKey != "" {
+			req.Header.Set("Authorization", "Bearer "+p.secretKey)
+		}
+		return req, nil
+	}, openAIError)
+}
+
+// openAIError classifies an unsuccessful response from the chat completions
+// API.
+func openAIError(resp *http.Response, body []byte) *APIError {
+	var response errorResponse
+	_ = json.Unmarshal(body, &response) // error bodies aren't always JSON
+	apiErr := newStatusError(resp, response.Error.Message)
+	if response.Error.Code == "insufficient_quota" || response.Error.Type == "insufficient_quota" {
+		// OpenAI reports running out of credits as a 429
+		apiErr.Kind = ErrorKindQuota
+	}
+	return apiErr
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+func newTestOpenAIProvider(url string) *OpenAIProvider {
+	return NewOpenAIProvider(config.Config{
+		APIUrl:      url,
+		OpenAIKey:   "sk-test",
+		Model:       "gpt-4o-mini",
+		Temperature: 0.5,
+		TopP:        0.9,
+	})
+}
+
+func TestOpenAIProviderComplete(t *testing.T) {
+	srv, lastRequest := newUpstream(t, "application/json", `{
+		"model": "gpt-4o-mini-2024-07-18",
+		"usage": {"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15},
+		"choices": [{"message": {"role": "assistant", "content": "hi2u"}, "finish_reason": "stop"}]
+	}`)
+
+	msg, err := newTestOpenAIProvider(srv.URL).Complete(context.Background(), Completion{
+		System: "be nice",
+		Messages: []Message{
+			{Role: RoleUser, Content: "hello"},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Name: "calc", Arguments: `{"expr":"1+1"}`}}},
+			{Role: RoleTool, ToolCallID: "call_1", Content: "2"},
+		},
+		Tools:        []Tool{{Name: "calc", Description: "does math", Parameters: json.RawMessage(`{"type":"object"}`)}},
+		DisableTools: true,
+		User:         "abc",
+	})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	req := lastRequest()
+	if got := req.header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer sk-test")
+	}
+	var body chatRequest
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("unable to parse request body: %v", err)
+	}
+	if body.Model != "gpt-4o-mini" || body.Temperature != 0.5 || body.TopP != 0.9 || body.User != "abc" || body.Stream {
+		t.Errorf("unexpected request settings: %+v", body)
+	}
+	if len(body.Messages) != 4 {
+		t.Fatalf("got %d messages, want 4", len(body.Messages))
+	}
+	if m := body.Messages[0]; m.Role != "system" || m.Content != "be nice" {
+		t.Errorf("first message = %+v, want the system prompt", m)
+	}
+	if m := body.Messages[2]; len(m.ToolCalls) != 1 || m.ToolCalls[0].Function.Name != "calc" || m.ToolCalls[0].Type != "function" {
+		t.Errorf("assistant message = %+v, want a calc tool call", m)
+	}
+	if m := body.Messages[3]; m.Role != "tool" || m.ToolCallID != "call_1" {
+		t.Errorf("tool message = %+v, want the result of call_1", m)
+	}
+	if len(body.Tools) != 1 || body.Tools[0].Function.Name != "calc" || body.ToolChoice != "none" {
+		t.Errorf("tools = %+v, tool_choice = %q, want calc with tools disabled", body.Tools, body.ToolChoice)
+	}
+
+	if msg.Role != RoleAssistant || msg.Content != "hi2u" {
+		t.Errorf("message = %+v, want the assistant's reply", msg)
+	}
+	if msg.Model != "gpt-4o-mini-2024-07-18" {
+		t.Errorf("model = %q, want the model reported by the API", msg.Model)
+	}
+	if want := (Usage{PromptTokens: 12, CompletionTokens: 3}); msg.Usage != want {
+		t.Errorf("usage = %+v, want %+v", msg.Usage, want)
+	}
+}
+
+func TestOpenAIProviderCompleteStream(t *testing.T) {
+	tests := []struct {
+		name          string
+		stream        string
+		wantDeltas    []string
+		wantContent   string
+		wantToolCalls []ToolCall
+		wantUsage     Usage
+	}{
+		{
+			name: "text",
+			stream: `data: {"model":"gpt-4o-mini","choices":[{"delta":{"role":"assistant","content":"hi"}}]}
+
+data: {"model":"gpt-4o-mini","choices":[{"delta":{"content":"2u"}}]}
+
+data: {"model":"gpt-4o-mini","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2}}
+
+data: [DONE]
+
+`,
+			wantDeltas:  []string{"hi", "2u"},
+			wantContent: "hi2u",
+			wantUsage:   Usage{PromptTokens: 5, CompletionTokens: 2},
+		},
+		{
+			name: "tool calls split across chunks",
+			stream: `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"calc","arguments":""}}]}}]}
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"expr\":"}}]}}]}
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"time","arguments":"{}"}}]}}]}
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"1+1\"}"}}]}}]}
+
+data: [DONE]
+
+`,
+			wantToolCalls: []ToolCall{
+				{ID: "call_1", Name: "calc", Arguments: `{"expr":"1+1"}`},
+				{ID: "call_2", Name: "time", Arguments: "{}"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, lastRequest := newUpstream(t, "text/event-stream", tt.stream)
+
+			var deltas []string
+			msg, err := newTestOpenAIProvider(srv.URL).CompleteStream(context.Background(), Completion{
+				Messages: []Message{{Role: RoleUser, Content: "hello"}},
+			}, func(delta string) error {
+				deltas = append(deltas, delta)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("CompleteStream() error = %v", err)
+			}
+
+			var body chatRequest
+			if err := json.Unmarshal(lastRequest().body, &body); err != nil {
+				t.Fatalf("unable to parse request body: %v", err)
+			}
+			if !body.Stream || body.StreamOptions == nil || !body.StreamOptions.IncludeUsage {
+				t.Errorf("request doesn't ask for a stream with usage: %+v", body)
+			}
+
+			if strings.Join(deltas, "|") != strings.Join(tt.wantDeltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+			if msg.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", msg.Content, tt.wantContent)
+			}
+			if len(msg.ToolCalls) != len(tt.wantToolCalls) {
+				t.Fatalf("tool calls = %+v, want %+v", msg.ToolCalls, tt.wantToolCalls)
+			}
+			for i, call := range msg.ToolCalls {
+				if call != tt.wantToolCalls[i] {
+					t.Errorf("tool call %d = %+v, want %+v", i, call, tt.wantToolCalls[i])
+				}
+			}
+			if msg.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", msg.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Price is what a model costs in US dollars per million tokens.
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// PriceTable maps model names to their prices.
+type PriceTable map[string]Price
+
+// ParsePriceTable parses model prices in the form "model=prompt/completion",
+// where prompt and completion are US dollars per million tokens, e.g.
+// "gpt-4o-mini=0.15/0.60".
+func ParsePriceTable(entries []string) (PriceTable, error) {
+	table := make(PriceTable)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("model price %q must be in the form model=prompt/completion", entry)
+		}
+		prompt, completion, ok := strings.Cut(prices, "/")
+		if !ok {
+			return nil, fmt.Errorf("model price %q must be in the form model=prompt/completion", entry)
+		}
+		var price Price
+		var err error
+		if price.Prompt, err = strconv.ParseFloat(strings.TrimSpace(prompt), 64); err != nil || price.Prompt < 0 {
+			return nil, fmt.Errorf("invalid prompt price in %q", entry)
+		}
+		if price.Completion, err = strconv.ParseFloat(strings.TrimSpace(completion), 64); err != nil || price.Completion < 0 {
+			return nil, fmt.Errorf("invalid completion price in %q", entry)
+		}
+		table[strings.TrimSpace(model)] = price
+	}
+	return table, nil
+}
+
+// Cost returns the price of usage by model in US dollars. Upstream APIs often
+// report a dated snapshot of the configured model, e.g. "gpt-4o-2024-08-06",
+// so the longest model name that prefixes model is used if there's no exact
+// match. It returns 0 if the model's price is unknown.
+func (t PriceTable) Cost(model string, usage Usage) float64 {
+	price, ok := t[model]
+	if !ok {
+		longest := 0
+		for name, p := range t {
+			if len(name) > longest && strings.HasPrefix(model, name) {
+				price, longest = p, len(name)
+			}
+		}
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
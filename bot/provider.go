@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+// Role identifies the author of a Message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	// RoleTool marks a message that holds the result of a tool call.
+	RoleTool Role = "tool"
+)
+
+// Message is a single message in a conversation with a model.
+type Message struct {
+	Role    Role
+	Content string
+	// ToolCalls are the tools the model wants to call. Only set on
+	// assistant messages.
+	ToolCalls []ToolCall
+	// ToolCallID identifies the call a tool message is the result of.
+	ToolCallID string
+	// ToolName is the name of the tool a tool message is the result of.
+	ToolName string
+	// Model is the model that wrote an assistant message, as reported by the
+	// upstream API.
+	Model string
+	// Usage is the number of tokens the upstream API charged for an
+	// assistant message.
+	Usage Usage
+}
+
+// Usage is the number of tokens consumed by a completion.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Add returns the sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+	}
+}
+
+// Total returns the number of prompt and completion tokens.
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// ToolCall is a request from the model to run a tool.
+type ToolCall struct {
+	ID   string
+	Name string
+	// Arguments are the JSON-encoded tool arguments.
+	Arguments string
+}
+
+// Completion is a request for the model's next message in a conversation.
+type Completion struct {
+	// System is the system prompt.
+	System string
+	// Messages is the conversation so far, oldest message first.
+	Messages []Message
+	// Tools are the tools the model may call.
+	Tools []Tool
+	// DisableTools forbids the model from calling Tools, e.g. to force a
+	// reply after too many rounds of tool calls. Tools remain defined so
+	// that earlier tool calls in Messages make sense to the model.
+	DisableTools bool
+	// User identifies the end user to the upstream API for abuse
+	// monitoring.
+	User string
+}
+
+// Provider requests completions from a specific LLM API.
+type Provider interface {
+	// Complete returns the model's next message.
+	Complete(ctx context.Context, c Completion) (Message, error)
+	// CompleteStream is like Complete, except that the message is streamed
+	// from the upstream API. onDelta is called with each fragment of text as
+	// it arrives. If onDelta returns an error, the stream is abandoned and
+	// the error is returned.
+	CompleteStream(ctx context.Context, c Completion, onDelta func(delta string) error) (Message, error)
+}
+
+// NewProvider creates the Provider selected in cfg.
+func NewProvider(cfg config.Config) (Provider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", "openai":
+		return NewOpenAIProvider(cfg), nil
+	case "anthropic":
+		return NewAnthropicProvider(cfg), nil
+	case "ollama":
+		return NewOllamaProvider(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
+
+// maxEventSize is the largest server-sent event or JSON line accepted from
+// an upstream API.
+const maxEventSize = 1 << 20
+
+// readEvents reads a stream of server-sent events from r and calls onData
+// with the data of each event. It stops at the end of the stream, at a
+// [DONE] event or when onData returns an error.
+func readEvents(r io.Reader, onData func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+	for scanner.Scan() {
+		data, isData := strings.CutPrefix(scanner.Text(), "data:")
+		if !isData {
+			continue // blank separator line, comment or other SSE field
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if err := onData([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read completion stream: %w", err)
+	}
+	return nil
+}
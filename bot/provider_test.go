@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upstreamRequest is a request received by a fake upstream API.
+type upstreamRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newUpstream starts a fake upstream API that replies to every request with
+// reply. It returns the server and a function that returns the last request
+// it received.
+func newUpstream(t *testing.T, contentType string, reply string) (*httptest.Server, func() upstreamRequest) {
+	t.Helper()
+	var last upstreamRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("got method %s, want POST", r.Method)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unable to read request body: %v", err)
+		}
+		last = upstreamRequest{header: r.Header.Clone(), body: body}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() upstreamRequest { return last }
+}
+
+func TestReadEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{
+			name:   "data events",
+			stream: "data: one\n\ndata: two\n\n",
+			want:   []string{"one", "two"},
+		},
+		{
+			name:   "stops at done",
+			stream: "data: one\n\ndata: [DONE]\n\ndata: two\n\n",
+			want:   []string{"one"},
+		},
+		{
+			name:   "skips other fields",
+			stream: ": keep-alive\nevent: ping\ndata:one\n\n",
+			want:   []string{"one"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readEvents(strings.NewReader(tt.stream), func(data []byte) error {
+				got = append(got, string(data))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("readEvents() error = %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("readEvents() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
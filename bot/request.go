@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// Channel identifies where a message was sent to the bot.
+type Channel string
+
+const (
+	// ChannelIM is a one-on-one instant message conversation.
+	ChannelIM Channel = "im"
+	// ChannelChatRoom is a multi-user chat room.
+	ChannelChatRoom Channel = "chat_room"
+)
+
+// Request is a message sent to the bot along with what is known about the
+// user and the conversation.
+type Request struct {
+	// ScreenName is the screen name of the user who sent the message.
+	ScreenName string
+	// Text is the plain-text message sent by the user.
+	Text string
+	// History is the conversation so far, oldest turn first.
+	History []store.Turn
+	// WarningLevel is the user's current warning level.
+	WarningLevel uint16
+	// Channel is where the message was sent.
+	Channel Channel
+	// ChatRoom is the name of the chat room the message was sent in. It's
+	// only set for ChannelChatRoom.
+	ChatRoom string
+	// Persona is who the user has asked the bot to pretend to be, if anyone.
+	Persona string
+	// Presence is the user's online status according to the bot's buddy
+	// list. It's nil if the user isn't on the buddy list.
+	Presence *BuddyInfo
+	// Profile is what the user says about themselves in their AIM profile
+	// and away message. It's nil unless the bot looked them up.
+	Profile *UserProfile
+	// ReceivedAt is when the bot received the message.
+	ReceivedAt time.Time
+}
+
+// UserProfile is the plain text of an AIM user's profile and away message.
+type UserProfile struct {
+	Profile     string
+	AwayMessage string
+}
+
+// Response is the bot's reply to a Request.
+type Response struct {
+	// Text is the plain-text reply.
+	Text string
+	// Model is the model that wrote the reply, if any.
+	Model string
+	// Usage is the number of tokens consumed by the reply, including any
+	// rounds of tool calls.
+	Usage Usage
+	// Cost is the price of Usage in US dollars, according to the configured
+	// model prices. It's 0 if the model's price is unknown.
+	Cost float64
+}
+
+// MessageExchanger is a chat bot API that receives only the message text and
+// conversation history.
+type MessageExchanger interface {
+	ExchangeMessage(send string, history []store.Turn) (receive string, err error)
+}
+
+// Adapt wraps a MessageExchanger so that it can serve Requests.
+func Adapt(bot MessageExchanger) *Adapter {
+	return &Adapter{bot: bot}
+}
+
+// PairExchanger is the chat bot API that predates conversation history. It
+// receives the message text and the user's last exchange with the bot: [0] is
+// the user's previous message and [1] is the bot's reply to it.
+type PairExchanger interface {
+	ExchangeMessage(send string, exchange [2]string) (receive string, err error)
+}
+
+// AdaptPair wraps a PairExchanger so that it can serve Requests. It's passed
+// the most recent turn of the conversation history.
+func AdaptPair(bot PairExchanger) *Adapter {
+	return Adapt(pairExchanger{bot: bot})
+}
+
+type pairExchanger struct {
+	bot PairExchanger
+}
+
+func (p pairExchanger) ExchangeMessage(send string, history []store.Turn) (string, error) {
+	var exchange [2]string
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		exchange = [2]string{last.User, last.Bot}
+	}
+	return p.bot.ExchangeMessage(send, exchange)
+}
+
+// Adapter serves Requests using a MessageExchanger. Since the underlying
+// bot can't be interrupted, cancellation is only checked before and after
+// the exchange.
+type Adapter struct {
+	bot MessageExchanger
+}
+
+func (a *Adapter) Respond(ctx context.Context, req Request) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	receive, err := a.bot.ExchangeMessage(req.Text, req.History)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	return Response{Text: receive}, nil
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// echoPairBot replies with the message and the exchange it was given.
+type echoPairBot struct {
+	exchange [2]string
+}
+
+func (b *echoPairBot) ExchangeMessage(send string, exchange [2]string) (string, error) {
+	b.exchange = exchange
+	return "re: " + send, nil
+}
+
+func TestAdaptPair(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []store.Turn
+		want    [2]string
+	}{
+		{
+			name: "no history",
+		},
+		{
+			name: "last turn",
+			history: []store.Turn{
+				{User: "hi", Bot: "hi2u"},
+				{User: "a/s/l?", Bot: "bot/na/cyberspace"},
+			},
+			want: [2]string{"a/s/l?", "bot/na/cyberspace"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairBot := &echoPairBot{}
+			resp, err := AdaptPair(pairBot).Respond(context.Background(), Request{Text: "brb", History: tt.history})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text != "re: brb" {
+				t.Errorf("Respond() = %q, want %q", resp.Text, "re: brb")
+			}
+			if pairBot.exchange != tt.want {
+				t.Errorf("exchange = %q, want %q", pairBot.exchange, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdapterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pairBot := &echoPairBot{}
+	if _, err := AdaptPair(pairBot).Respond(ctx, Request{Text: "brb"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Respond() error = %v, want %v", err, context.Canceled)
+	}
+}
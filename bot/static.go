@@ -3,6 +3,8 @@ package bot
 import (
 	"math/rand"
 	"time"
+
+	"github.com/mk6i/smarter-smarter-child/store"
 )
 
 func NewStaticChatBot() *StaticChatBot {
@@ -15,7 +17,7 @@ type StaticChatBot struct {
 	r *rand.Rand
 }
 
-func (c *StaticChatBot) ExchangeMessage(send string, exchange [2]string) (receive string, err error) {
+func (c *StaticChatBot) ExchangeMessage(send string, history []store.Turn) (receive string, err error) {
 	time.Sleep(time.Duration(c.r.Intn(1000)) * time.Millisecond)
 	responses := []string{
 		"hi2u",
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ToolFunc runs a tool with the JSON-encoded arguments supplied by the model.
+// req is the request the model is responding to. The returned text is fed
+// back to the model.
+type ToolFunc func(ctx context.Context, req Request, args json.RawMessage) (string, error)
+
+// Tool is a Go function the model may call while composing a reply.
+type Tool struct {
+	// Name identifies the tool to the model, e.g. "get_current_time".
+	Name string
+	// Description tells the model what the tool does and when to use it.
+	Description string
+	// Parameters is the JSON schema of the tool's arguments.
+	Parameters json.RawMessage
+	// Call runs the tool.
+	Call ToolFunc
+}
+
+// NewToolRegistry creates an empty ToolRegistry.
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]Tool),
+	}
+}
+
+// ToolRegistry holds the tools offered to the model.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	// order preserves registration order so that the tools are always
+	// presented to the model the same way.
+	order []string
+}
+
+// Register adds a tool to the registry. It returns an error if a tool with
+// the same name is already registered.
+func (r *ToolRegistry) Register(tool Tool) error {
+	if tool.Name == "" || tool.Call == nil {
+		return fmt.Errorf("tool %q is missing a name or implementation", tool.Name)
+	}
+	if len(tool.Parameters) == 0 {
+		tool.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool %q is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	r.order = append(r.order, tool.Name)
+	return nil
+}
+
+// Len returns the number of registered tools.
+func (r *ToolRegistry) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.tools)
+}
+
+// list returns the registered tools in registration order.
+func (r *ToolRegistry) list() []Tool {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tools = append(tools, r.tools[name])
+	}
+	return tools
+}
+
+// call runs the named tool. Failures are reported as the tool's result so
+// that the model can explain the problem or try something else.
+func (r *ToolRegistry) call(ctx context.Context, req Request, name string, args string) string {
+	if r == nil {
+		return fmt.Sprintf("error: there is no tool named %q", name)
+	}
+	r.mu.RLock()
+	tool, exists := r.tools[name]
+	r.mu.RUnlock()
+	if !exists {
+		return fmt.Sprintf("error: there is no tool named %q", name)
+	}
+	if args == "" {
+		args = "{}"
+	}
+	if !json.Valid([]byte(args)) {
+		return "error: the tool arguments are not valid JSON"
+	}
+	result, err := tool.Call(ctx, req, json.RawMessage(args))
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
@@ -0,0 +1,270 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxToolReminderDelay is the furthest in the future the model may
+	// schedule a reminder.
+	maxToolReminderDelay = 24 * time.Hour
+	// maxDefinitions is the number of dictionary definitions returned to the
+	// model.
+	maxDefinitions = 3
+)
+
+// ReminderScheduler sends a user an IM at a later time.
+type ReminderScheduler interface {
+	ScheduleReminder(screenName string, delay time.Duration, text string) error
+}
+
+// BuddyInfo is what's publicly known about an AIM user.
+type BuddyInfo struct {
+	ScreenName string
+	// Online indicates whether the user is signed on. The other fields are
+	// only set for online users.
+	Online       bool
+	Away         bool
+	WarningLevel uint16
+	OnlineSince  time.Time
+	IdleFor      time.Duration
+}
+
+// BuddyDirectory looks up information about AIM users.
+type BuddyDirectory interface {
+	BuddyInfo(ctx context.Context, screenName string) (BuddyInfo, error)
+}
+
+// NewTimeTool creates a tool that tells the model the current time.
+func NewTimeTool() Tool {
+	return Tool{
+		Name:        "get_current_time",
+		Description: "Get the current date and time, optionally in a specific time zone.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {"type": "string", "description": "An IANA time zone name, e.g. America/New_York. Defaults to the server's time zone."}
+			}
+		}`),
+		Call: func(_ context.Context, _ Request, args json.RawMessage) (string, error) {
+			var params struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			loc := time.Local
+			if params.Timezone != "" {
+				var err error
+				if loc, err = time.LoadLocation(params.Timezone); err != nil {
+					return "", fmt.Errorf("unknown time zone %q", params.Timezone)
+				}
+			}
+			return time.Now().In(loc).Format("Monday, January 2, 2006 3:04 PM MST"), nil
+		},
+	}
+}
+
+// NewCalculatorTool creates a tool that evaluates arithmetic expressions so
+// that the model doesn't have to do math in its head.
+func NewCalculatorTool() Tool {
+	return Tool{
+		Name:        "calculate",
+		Description: "Evaluate an arithmetic expression. Supports + - * / % ^ and parentheses.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"expression": {"type": "string", "description": "The expression to evaluate, e.g. (2 + 3) * 4"}
+			},
+			"required": ["expression"]
+		}`),
+		Call: func(_ context.Context, _ Request, args json.RawMessage) (string, error) {
+			var params struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			v, err := evalExpr(params.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(v, 'g', 12, 64), nil
+		},
+	}
+}
+
+// NewDictionaryTool creates a tool that looks up word definitions. urlFormat
+// is the URL of a dictionaryapi.dev-compatible service, where %s is replaced
+// with the word.
+func NewDictionaryTool(client *http.Client, urlFormat string) Tool {
+	return Tool{
+		Name:        "define_word",
+		Description: "Look up the dictionary definitions of an English word.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"word": {"type": "string", "description": "The word to define."}
+			},
+			"required": ["word"]
+		}`),
+		Call: func(ctx context.Context, _ Request, args json.RawMessage) (string, error) {
+			var params struct {
+				Word string `json:"word"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			word := strings.TrimSpace(params.Word)
+			if word == "" {
+				return "", errors.New("no word given")
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(urlFormat, url.PathEscape(word)), nil)
+			if err != nil {
+				return "", err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return "", fmt.Errorf("unable to reach dictionary: %w", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode == http.StatusNotFound {
+				return fmt.Sprintf("no definitions found for %q", word), nil
+			}
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("dictionary returned status %d", resp.StatusCode)
+			}
+
+			var entries []struct {
+				Meanings []struct {
+					PartOfSpeech string `json:"partOfSpeech"`
+					Definitions  []struct {
+						Definition string `json:"definition"`
+					} `json:"definitions"`
+				} `json:"meanings"`
+			}
+			if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&entries); err != nil {
+				return "", fmt.Errorf("unable to parse dictionary response: %w", err)
+			}
+
+			var defs []string
+			for _, entry := range entries {
+				for _, meaning := range entry.Meanings {
+					for _, def := range meaning.Definitions {
+						if len(defs) == maxDefinitions {
+							break
+						}
+						defs = append(defs, fmt.Sprintf("(%s) %s", meaning.PartOfSpeech, def.Definition))
+					}
+				}
+			}
+			if len(defs) == 0 {
+				return fmt.Sprintf("no definitions found for %q", word), nil
+			}
+			return strings.Join(defs, "\n"), nil
+		},
+	}
+}
+
+// NewReminderTool creates a tool that lets the model schedule a reminder IM
+// for the user it's chatting with.
+func NewReminderTool(scheduler ReminderScheduler) Tool {
+	return Tool{
+		Name:        "schedule_reminder",
+		Description: "Send the user a reminder IM after a delay. Use this when the user asks to be reminded of something.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"delay": {"type": "string", "description": "How long to wait, as a Go duration such as 90s, 15m or 2h30m."},
+				"text": {"type": "string", "description": "What to remind the user about."}
+			},
+			"required": ["delay", "text"]
+		}`),
+		Call: func(_ context.Context, req Request, args json.RawMessage) (string, error) {
+			var params struct {
+				Delay string `json:"delay"`
+				Text  string `json:"text"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			if req.ScreenName == "" {
+				return "", errors.New("don't know who to remind")
+			}
+			delay, err := time.ParseDuration(params.Delay)
+			if err != nil {
+				return "", fmt.Errorf("invalid delay %q", params.Delay)
+			}
+			if delay <= 0 || delay > maxToolReminderDelay {
+				return "", fmt.Errorf("delay must be between 0 and %s", maxToolReminderDelay)
+			}
+			if err := scheduler.ScheduleReminder(req.ScreenName, delay, params.Text); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("reminder scheduled for %s from now", delay), nil
+		},
+	}
+}
+
+// NewBuddyInfoTool creates a tool that lets the model look up whether an AIM
+// user is online, away or idle.
+func NewBuddyInfoTool(directory BuddyDirectory) Tool {
+	return Tool{
+		Name:        "get_buddy_info",
+		Description: "Look up whether an AIM user is online, away or idle, along with their warning level.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"screen_name": {"type": "string", "description": "The AIM screen name to look up."}
+			},
+			"required": ["screen_name"]
+		}`),
+		Call: func(ctx context.Context, _ Request, args json.RawMessage) (string, error) {
+			var params struct {
+				ScreenName string `json:"screen_name"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			if params.ScreenName == "" {
+				return "", errors.New("no screen name given")
+			}
+			info, err := directory.BuddyInfo(ctx, params.ScreenName)
+			if err != nil {
+				return "", err
+			}
+			return describeBuddy(info), nil
+		},
+	}
+}
+
+// describeBuddy summarizes info for the model.
+func describeBuddy(info BuddyInfo) string {
+	if !info.Online {
+		return fmt.Sprintf("%s is offline", info.ScreenName)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s is online", info.ScreenName)
+	if info.Away {
+		sb.WriteString(" and away")
+	}
+	if info.IdleFor > 0 {
+		fmt.Fprintf(&sb, ", idle for %s", info.IdleFor)
+	}
+	if !info.OnlineSince.IsZero() {
+		fmt.Fprintf(&sb, ", signed on %s ago", time.Since(info.OnlineSince).Round(time.Minute))
+	}
+	fmt.Fprintf(&sb, ", warning level %d%%", info.WarningLevel/10)
+	return sb.String()
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// feedbagTimeout bounds an update of the bot's server-side deny list.
+const feedbagTimeout = 30 * time.Second
+
+// permitted reports whether the bot talks to screenName. If not, it returns
+// the reason the user is turned away.
+func (s *SessionManager) permitted(screenName string) (reason string, ok bool) {
+	switch {
+	case s.admins.contains(screenName):
+		return "", true
+	case s.access.IsDenied(screenName):
+		return rejectBanned, false
+	case len(s.access.Allowed()) > 0 && !s.access.IsAllowed(screenName):
+		return rejectNotAllowed, false
+	}
+	return "", true
+}
+
+// Ban adds screenName to the deny list, which makes the bot ignore their
+// IMs, chat room messages, invitations and warnings. The user is also
+// blocked on the OSCAR server so that they can't IM the bot at all.
+func (s *SessionManager) Ban(screenName string) error {
+	if err := s.access.Deny(screenName); err != nil {
+		return err
+	}
+	s.logger.Info("banned user", "screen_name", screenName)
+	go s.updateDenyList([]string{screenName}, nil)
+	return nil
+}
+
+// Unban removes screenName from the deny list.
+func (s *SessionManager) Unban(screenName string) error {
+	if err := s.access.Undeny(screenName); err != nil {
+		return err
+	}
+	s.logger.Info("unbanned user", "screen_name", screenName)
+	go s.updateDenyList(nil, []string{screenName})
+	return nil
+}
+
+// Bans returns the deny list, sorted.
+func (s *SessionManager) Bans() []string {
+	return s.access.Denied()
+}
+
+// Allow adds screenName to the allow list. Once the allow list has any
+// entries, the bot ignores everyone who isn't on it, except admins.
+func (s *SessionManager) Allow(screenName string) error {
+	if err := s.access.Allow(screenName); err != nil {
+		return err
+	}
+	s.logger.Info("allowed user", "screen_name", screenName)
+	return nil
+}
+
+// Disallow removes screenName from the allow list.
+func (s *SessionManager) Disallow(screenName string) error {
+	if err := s.access.Disallow(screenName); err != nil {
+		return err
+	}
+	s.logger.Info("disallowed user", "screen_name", screenName)
+	return nil
+}
+
+// Allowed returns the allow list, sorted.
+func (s *SessionManager) Allowed() []string {
+	return s.access.Allowed()
+}
+
+// syncDenyList blocks every user on the deny list on the OSCAR server. It's
+// called on sign on to catch up with bans made while offline.
+func (s *SessionManager) syncDenyList(ctx context.Context) {
+	if err := s.editDenyList(ctx, s.access.Denied(), nil); err != nil {
+		s.logger.Error("unable to sync deny list with the server", "err", err.Error())
+	}
+}
+
+// updateDenyList blocks the users in deny and unblocks the users in undeny on
+// the OSCAR server. It does nothing while the bot is offline since the deny
+// list is synced on sign on.
+func (s *SessionManager) updateDenyList(deny []string, undeny []string) {
+	if s.State() != StateOnline {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), feedbagTimeout)
+	defer cancel()
+	if err := s.editDenyList(ctx, deny, undeny); err != nil {
+		s.logger.Error("unable to update deny list on the server", "err", err.Error())
+	}
+}
+
+// editDenyList adds deny entries to the bot's feedbag (server-side buddy
+// list) for the users in deny and removes the entries for the users in
+// undeny. Deny entries that were added some other way, e.g. with an AIM
+// client signed on as the bot, are left alone.
+func (s *SessionManager) editDenyList(ctx context.Context, deny []string, undeny []string) error {
+	s.feedbagMu.Lock()
+	defer s.feedbagMu.Unlock()
+
+	items, err := s.queryFeedbag(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]wire.FeedbagItem)
+	var maxItemID uint16
+	for _, item := range items {
+		maxItemID = max(maxItemID, item.ItemID)
+		if item.ClassID == wire.FeedbagClassIDDeny {
+			existing[store.NormalizeScreenName(item.Name)] = item
+		}
+	}
+
+	var inserts, deletes []wire.FeedbagItem
+	for _, screenName := range deny {
+		key := store.NormalizeScreenName(screenName)
+		if _, ok := existing[key]; ok {
+			continue
+		}
+		maxItemID++
+		item := wire.FeedbagItem{
+			Name:    screenName,
+			ItemID:  maxItemID,
+			ClassID: wire.FeedbagClassIDDeny,
+		}
+		inserts = append(inserts, item)
+		existing[key] = item
+	}
+	for _, screenName := range undeny {
+		if item, ok := existing[store.NormalizeScreenName(screenName)]; ok {
+			deletes = append(deletes, item)
+		}
+	}
+
+	if len(inserts) > 0 {
+		if err := s.editFeedbag(ctx, wire.FeedbagInsertItem, wire.SNAC_0x13_0x08_FeedbagInsertItem{Items: inserts}); err != nil {
+			return err
+		}
+		s.logger.Debug("blocked users on the server", "count", len(inserts))
+	}
+	if len(deletes) > 0 {
+		if err := s.editFeedbag(ctx, wire.FeedbagDeleteItem, wire.SNAC_0x13_0x0A_FeedbagDeleteItem{Items: deletes}); err != nil {
+			return err
+		}
+		s.logger.Debug("unblocked users on the server", "count", len(deletes))
+	}
+	return nil
+}
+
+// queryFeedbag returns the items in the bot's feedbag.
+func (s *SessionManager) queryFeedbag(ctx context.Context) ([]wire.FeedbagItem, error) {
+	resp, err := s.sendRequest(ctx, wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Feedbag,
+			SubGroup:  wire.FeedbagQuery,
+		},
+		Body: struct{}{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.frame.FoodGroup != wire.Feedbag || resp.frame.SubGroup != wire.FeedbagReply {
+		return nil, fmt.Errorf("feedbag query failed: got %s",
+			wire.SubGroupName(resp.frame.FoodGroup, resp.frame.SubGroup))
+	}
+	reply := wire.SNAC_0x13_0x06_FeedbagReply{}
+	if err := wire.UnmarshalBE(&reply, resp.body); err != nil {
+		return nil, err
+	}
+	return reply.Items, nil
+}
+
+// editFeedbag sends a feedbag modification and checks that the server
+// applied it.
+func (s *SessionManager) editFeedbag(ctx context.Context, subGroup uint16, body any) error {
+	resp, err := s.sendRequest(ctx, wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Feedbag,
+			SubGroup:  subGroup,
+		},
+		Body: body,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.frame.FoodGroup != wire.Feedbag || resp.frame.SubGroup != wire.FeedbagStatus {
+		return fmt.Errorf("%s failed: got %s", wire.SubGroupName(wire.Feedbag, subGroup),
+			wire.SubGroupName(resp.frame.FoodGroup, resp.frame.SubGroup))
+	}
+	status := wire.SNAC_0x13_0x0E_FeedbagStatus{}
+	if err := wire.UnmarshalBE(&status, resp.body); err != nil {
+		return err
+	}
+	for _, code := range status.Results {
+		if code != 0 {
+			return fmt.Errorf("%s failed with status code %d", wire.SubGroupName(wire.Feedbag, subGroup), code)
+		}
+	}
+	return nil
+}
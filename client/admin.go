@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// ErrOffline is returned when an operation needs the bot to be signed on.
+var ErrOffline = errors.New("bot is not signed on")
+
+// Conversation summarizes the bot's IM conversation with a user.
+type Conversation struct {
+	ScreenName string    `json:"screen_name"`
+	LastActive time.Time `json:"last_active"`
+	WarnCount  int       `json:"warn_count"`
+	Banned     bool      `json:"banned"`
+}
+
+// Conversations returns the users who have IMed the bot since it started,
+// most recently active first.
+func (s *SessionManager) Conversations() []Conversation {
+	s.chatContextsMu.RLock()
+	convs := make([]Conversation, 0, len(s.chatContexts))
+	for screenName, chatCtx := range s.chatContexts {
+		convs = append(convs, Conversation{
+			ScreenName: screenName,
+			LastActive: chatCtx.lastActive,
+			WarnCount:  chatCtx.warnCount,
+		})
+	}
+	s.chatContextsMu.RUnlock()
+
+	for i := range convs {
+		convs[i].Banned = s.access.IsDenied(convs[i].ScreenName)
+	}
+	sort.Slice(convs, func(i, j int) bool {
+		return convs[i].LastActive.After(convs[j].LastActive)
+	})
+	return convs
+}
+
+// ResetConversation makes the bot forget its conversation with screenName:
+// the conversation history, persona and warnings.
+func (s *SessionManager) ResetConversation(screenName string) error {
+	if err := s.conversations.Reset(screenName); err != nil {
+		return err
+	}
+	s.personas.set(screenName, "")
+
+	s.chatContextsMu.Lock()
+	defer s.chatContextsMu.Unlock()
+	for name, chatCtx := range s.chatContexts {
+		if store.NormalizeScreenName(name) == store.NormalizeScreenName(screenName) {
+			chatCtx.warnCount = 0
+		}
+	}
+	return nil
+}
+
+// SendIM sends text to screenName as the bot. text may contain HTML.
+func (s *SessionManager) SendIM(ctx context.Context, screenName string, text string) error {
+	if s.State() != StateOnline {
+		return ErrOffline
+	}
+	cookie := rand.Uint64()
+	s.chatContextsMu.RLock()
+	for name, chatCtx := range s.chatContexts {
+		if store.NormalizeScreenName(name) == store.NormalizeScreenName(screenName) {
+			// continue the existing conversation window
+			cookie = chatCtx.cookie
+		}
+	}
+	s.chatContextsMu.RUnlock()
+
+	if err := sendMessageSNAC(ctx, s.msgCh, cookie, screenName, text, s.config); err != nil {
+		return err
+	}
+	s.logger.Info("sent operator IM", "screen_name", screenName, "outgoing", text)
+	return nil
+}
+
+// screenNameSet is a set of screen names that ignores case and spacing.
+type screenNameSet struct {
+	mu sync.RWMutex
+	// names maps normalized screen names to the screen name as added.
+	names map[string]string
+}
+
+func (n *screenNameSet) add(screenName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.names == nil {
+		n.names = make(map[string]string)
+	}
+	n.names[store.NormalizeScreenName(screenName)] = screenName
+}
+
+func (n *screenNameSet) remove(screenName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.names, store.NormalizeScreenName(screenName))
+}
+
+// replace swaps the contents of n for the contents of o.
+func (n *screenNameSet) replace(o *screenNameSet) {
+	o.mu.RLock()
+	names := make(map[string]string, len(o.names))
+	for key, name := range o.names {
+		names[key] = name
+	}
+	o.mu.RUnlock()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.names = names
+}
+
+func (n *screenNameSet) contains(screenName string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	_, ok := n.names[store.NormalizeScreenName(screenName)]
+	return ok
+}
+
+func (n *screenNameSet) list() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	names := make([]string, 0, len(n.names))
+	for _, name := range n.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
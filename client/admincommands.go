@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// reloadDelay is how long the bot waits after acknowledging a reload
+// before signing off, so that the acknowledgement goes out first.
+const reloadDelay = 2 * time.Second
+
+// registerAdminCommands adds the commands that admins can run over IM.
+func (s *SessionManager) registerAdminCommands() {
+	p := s.config.AdminCommandPrefix
+	builtins := []Command{
+		{
+			Name:        "help",
+			Usage:       p + "help",
+			Description: "List admin commands.",
+			Handler:     s.adminHelpCommand,
+		},
+		{
+			Name:        "stats",
+			Usage:       p + "stats",
+			Description: "Show the bot's connection state, conversations and AI model usage.",
+			Handler:     s.statsCommand,
+		},
+		{
+			Name:        "ban",
+			Usage:       p + "ban [screen name]",
+			Description: "Ignore a user's IMs, chat room messages and warnings, and block them on the server.",
+			Handler:     s.banCommand,
+		},
+		{
+			Name:        "unban",
+			Usage:       p + "unban [screen name]",
+			Description: "Lift a ban.",
+			Handler:     s.unbanCommand,
+		},
+		{
+			Name:        "allow",
+			Usage:       p + "allow [screen name]",
+			Description: "Add a user to the allow list. Once anyone is on it, the bot only talks to users on the allow list.",
+			Handler:     s.allowCommand,
+		},
+		{
+			Name:        "disallow",
+			Usage:       p + "disallow [screen name]",
+			Description: "Remove a user from the allow list.",
+			Handler:     s.disallowCommand,
+		},
+		{
+			Name:        "buddies",
+			Usage:       p + "buddies",
+			Description: "List the bot's buddies and who's online.",
+			Handler:     s.buddiesCommand,
+		},
+		{
+			Name:        "addbuddy",
+			Usage:       p + "addbuddy [screen name]",
+			Description: "Add a user to the bot's buddy list.",
+			Handler:     s.addBuddyCommand,
+		},
+		{
+			Name:        "removebuddy",
+			Usage:       p + "removebuddy [screen name]",
+			Description: "Remove a user from the bot's buddy list.",
+			Handler:     s.removeBuddyCommand,
+		},
+		{
+			Name:        "reset",
+			Usage:       p + "reset [screen name]",
+			Description: "Make the bot forget its conversation with a user.",
+			Handler:     s.adminResetCommand,
+		},
+		{
+			Name:        "prompt",
+			Usage:       p + "prompt [text]",
+			Description: "Show or replace the bot's system prompt.",
+			Handler:     s.promptCommand,
+		},
+		{
+			Name:        "broadcast",
+			Usage:       p + "broadcast [text]",
+			Description: "IM every user who has talked to the bot since it started.",
+			Handler:     s.broadcastCommand,
+		},
+		{
+			Name:        "reload",
+			Usage:       p + "reload",
+			Description: "Sign off and back on, which republishes the profile and rejoins chat rooms. Settings are not reloaded.",
+			Handler:     s.reloadCommand,
+		},
+		{
+			Name:        "takeover",
+			Usage:       p + "takeover [screen name]",
+			Description: "Reply to a user yourself instead of the bot. Their IMs are relayed to you and your IMs are sent to them as the bot.",
+			Handler:     s.takeoverCommand,
+		},
+		{
+			Name:        "handback",
+			Usage:       p + "handback",
+			Description: "Hand the conversation you took over back to the bot.",
+			Handler:     s.handbackCommand,
+		},
+	}
+	for _, cmd := range builtins {
+		if err := s.adminCommands.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (s *SessionManager) adminHelpCommand(_ context.Context, call CommandCall) (string, error) {
+	return s.adminCommands.Help(call.ScreenName), nil
+}
+
+func (s *SessionManager) takeoverCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		if user, ok := s.takeoverOf(call.ScreenName); ok {
+			return fmt.Sprintf("You're talking to %s as the bot.", html.EscapeString(user)), nil
+		}
+		return fmt.Sprintf("Usage: %stakeover [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	s.StartTakeover(call.Args, call.ScreenName)
+	return fmt.Sprintf("You're now talking to %s as the bot. Their IMs will be relayed to you. Type %shandback when you're done.",
+		html.EscapeString(call.Args), s.config.AdminCommandPrefix), nil
+}
+
+func (s *SessionManager) handbackCommand(_ context.Context, call CommandCall) (string, error) {
+	user, ok := s.takeoverOf(call.ScreenName)
+	if !ok || !s.EndTakeover(user) {
+		return "You haven't taken over a conversation.", nil
+	}
+	return fmt.Sprintf("OK, the bot is talking to %s again.", html.EscapeString(user)), nil
+}
+
+func (s *SessionManager) statsCommand(_ context.Context, _ CommandCall) (string, error) {
+	now := time.Now()
+	day, month := s.ledger.Total(now)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Up %s, %s, %d reconnects<BR>", now.Sub(s.started).Round(time.Second), s.State(), s.Reconnects())
+	if away := s.Away(); away != "" {
+		fmt.Fprintf(&sb, "Away: %s<BR>", html.EscapeString(away))
+	}
+	fmt.Fprintf(&sb, "%d conversations, %d taken over, %d banned<BR>", len(s.Conversations()), len(s.Takeovers()), len(s.Bans()))
+	fmt.Fprintf(&sb, "Today: %d tokens, $%.2f<BR>", day.Tokens, day.Cost)
+	fmt.Fprintf(&sb, "This month: %d tokens, $%.2f", month.Tokens, month.Cost)
+	return sb.String(), nil
+}
+
+func (s *SessionManager) banCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sban [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.Ban(call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Banned %s.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) unbanCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sunban [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.Unban(call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Unbanned %s.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) allowCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sallow [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.Allow(call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Allowed %s.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) disallowCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sdisallow [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.Disallow(call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Removed %s from the allow list.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) buddiesCommand(_ context.Context, _ CommandCall) (string, error) {
+	buddies := s.Buddies()
+	if len(buddies) == 0 {
+		return "The bot's buddy list is empty.", nil
+	}
+	online := s.OnlineBuddies()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d buddies, %d online:", len(buddies), len(online))
+	for _, info := range online {
+		sb.WriteString("<BR>" + html.EscapeString(info.ScreenName))
+		if info.Away {
+			sb.WriteString(" (away)")
+		} else if info.IdleFor > 0 {
+			fmt.Fprintf(&sb, " (idle %s)", info.IdleFor)
+		}
+	}
+	return sb.String(), nil
+}
+
+func (s *SessionManager) addBuddyCommand(ctx context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %saddbuddy [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.AddBuddy(ctx, call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Added %s to the buddy list.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) removeBuddyCommand(ctx context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sremovebuddy [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.RemoveBuddy(ctx, call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Removed %s from the buddy list.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) adminResetCommand(_ context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sreset [screen name]", s.config.AdminCommandPrefix), nil
+	}
+	if err := s.ResetConversation(call.Args); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("The bot forgot its conversation with %s.", html.EscapeString(call.Args)), nil
+}
+
+func (s *SessionManager) promptCommand(_ context.Context, call CommandCall) (string, error) {
+	editor, ok := s.chatBot.(bot.PromptEditor)
+	if !ok {
+		return "This bot doesn't have a prompt.", nil
+	}
+	if call.Args == "" {
+		return "The prompt is: " + html.EscapeString(editor.Prompt()), nil
+	}
+	editor.SetPrompt(call.Args)
+	s.logger.Info("admin changed the prompt", "screen_name", call.ScreenName, "prompt", call.Args)
+	return "OK, the prompt is now: " + html.EscapeString(call.Args), nil
+}
+
+func (s *SessionManager) broadcastCommand(ctx context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return fmt.Sprintf("Usage: %sbroadcast [text]", s.config.AdminCommandPrefix), nil
+	}
+	var recipients []string
+	for _, conv := range s.Conversations() {
+		if !conv.Banned {
+			recipients = append(recipients, conv.ScreenName)
+		}
+	}
+
+	go func() {
+		for i, screenName := range recipients {
+			if i > 0 {
+				select {
+				case <-time.After(s.config.MsgPartDelay):
+				case <-ctx.Done():
+					s.logger.Info("broadcast interrupted", "sent", i, "recipients", len(recipients))
+					return
+				}
+			}
+			if err := s.SendIM(ctx, screenName, call.Args); err != nil {
+				s.logger.Error("unable to send broadcast", "screen_name", screenName, "err", err.Error())
+			}
+		}
+	}()
+
+	s.logger.Info("admin sent a broadcast", "screen_name", call.ScreenName, "recipients", len(recipients))
+	return fmt.Sprintf("Broadcasting to %d users.", len(recipients)), nil
+}
+
+func (s *SessionManager) reloadCommand(_ context.Context, call CommandCall) (string, error) {
+	s.logger.Info("admin requested a reload", "screen_name", call.ScreenName)
+	time.AfterFunc(reloadDelay, s.Reconnect)
+	return "Signing off and back on...", nil
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/mk6i/retro-aim-server/wire"
 )
 
+// ErrInvalidCredentials indicates that the auth server rejected the bot's
+// screen name or password. Retrying the login will not help.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
 // Authenticate performs the BUCP auth flow with the OSCAR auth server. Upon
 // successful login, it returns a host name and auth cookie for connecting to
 // and authenticating with the BOS service.
@@ -51,7 +55,7 @@ func Authenticate(flapc FlapClient, screenName string, password string) (string,
 	if code, hasErr := loginRespSNAC.Uint16(wire.LoginTLVTagsErrorSubcode); hasErr {
 		switch code {
 		case wire.LoginErrInvalidUsernameOrPassword:
-			return "", "", fmt.Errorf("error code from SNAC(0x17,0x03): invalid username or password")
+			return "", "", fmt.Errorf("error code from SNAC(0x17,0x03): %w", ErrInvalidCredentials)
 		default:
 			return "", "", fmt.Errorf("error code from SNAC(0x17,0x03): %d", code)
 		}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// BuddyInfo looks up the online status of an AIM user. Buddies who are
+// online are answered from the presence table, everyone else via the Locate
+// food group.
+func (s *SessionManager) BuddyInfo(ctx context.Context, screenName string) (bot.BuddyInfo, error) {
+	info := bot.BuddyInfo{ScreenName: screenName}
+	if s.State() != StateOnline {
+		return info, fmt.Errorf("not connected to AIM")
+	}
+	if buddy, ok := s.presence.get(screenName); ok && buddy.Online {
+		return buddy, nil
+	}
+
+	// ask for the user info block only, no profile or away message
+	reply, online, err := s.queryUserInfo(ctx, screenName, 0)
+	if err != nil || !online {
+		return info, err
+	}
+	return newBuddyInfo(reply.TLVUserInfo), nil
+}
+
+// queryUserInfo looks up screenName via the Locate food group. infoType is a
+// combination of the wire.LocateType* flags that selects what the server
+// includes besides the user info block. online is false if the user is
+// offline or is blocking the bot.
+func (s *SessionManager) queryUserInfo(ctx context.Context, screenName string, infoType uint32) (reply wire.SNAC_0x02_0x06_LocateUserInfoReply, online bool, err error) {
+	resp, err := s.sendRequest(ctx, wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Locate,
+			SubGroup:  wire.LocateUserInfoQuery,
+		},
+		Body: wire.SNAC_0x02_0x05_LocateUserInfoQuery{
+			Type:       uint16(infoType),
+			ScreenName: screenName,
+		},
+	})
+	if err != nil {
+		return reply, false, err
+	}
+
+	switch {
+	case resp.frame.FoodGroup == wire.Locate && resp.frame.SubGroup == wire.LocateErr:
+		snacErr := wire.SNACError{}
+		if err := wire.UnmarshalBE(&snacErr, resp.body); err != nil {
+			return reply, false, err
+		}
+		if snacErr.Code == wire.ErrorCodeNotLoggedOn {
+			return reply, false, nil // offline, or blocking the bot
+		}
+		return reply, false, fmt.Errorf("user info query failed with error code %d", snacErr.Code)
+	case resp.frame.FoodGroup != wire.Locate || resp.frame.SubGroup != wire.LocateUserInfoReply:
+		return reply, false, fmt.Errorf("user info query failed: got %s",
+			wire.SubGroupName(resp.frame.FoodGroup, resp.frame.SubGroup))
+	}
+
+	if err := wire.UnmarshalBE(&reply, resp.body); err != nil {
+		return reply, false, err
+	}
+	return reply, true, nil
+}
+
+// newBuddyInfo describes the online user whose info block the server sent.
+func newBuddyInfo(userInfo wire.TLVUserInfo) bot.BuddyInfo {
+	info := bot.BuddyInfo{
+		ScreenName:   userInfo.ScreenName,
+		Online:       true,
+		WarningLevel: userInfo.WarningLevel,
+	}
+	if flags, ok := userInfo.Uint16(wire.OServiceUserInfoUserFlags); ok {
+		info.Away = flags&wire.OServiceUserFlagUnavailable != 0
+	}
+	if signon, ok := userInfo.Uint32(wire.OServiceUserInfoSignonTOD); ok {
+		info.OnlineSince = time.Unix(int64(signon), 0)
+	}
+	if idle, ok := userInfo.Uint16(wire.OServiceUserInfoIdleTime); ok {
+		info.IdleFor = time.Duration(idle) * time.Minute
+	}
+	return info
+}
@@ -0,0 +1,279 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// maxBuddies is the number of buddies an AIM buddy list holds. Users aren't
+// added automatically once the buddy list is full.
+const maxBuddies = 61
+
+// presenceTable tracks the online status of the users on the bot's buddy
+// list, as reported by the server.
+type presenceTable struct {
+	mu     sync.RWMutex
+	byUser map[string]presence
+	// since is when the table was last cleared.
+	since time.Time
+}
+
+// presence is the last status reported for a buddy.
+type presence struct {
+	info    bot.BuddyInfo
+	updated time.Time
+}
+
+// set records the status of a buddy. It reports whether the buddy just
+// signed on, as opposed to changing their status or being reported online
+// when the table was filled after the bot signed on.
+func (p *presenceTable) set(info bot.BuddyInfo) (signedOn bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byUser == nil {
+		p.byUser = make(map[string]presence)
+	}
+	key := store.NormalizeScreenName(info.ScreenName)
+	prev, known := p.byUser[key]
+	p.byUser[key] = presence{info: info, updated: time.Now()}
+	if !info.Online || prev.info.Online {
+		return false
+	}
+	return known || info.OnlineSince.After(p.since)
+}
+
+func (p *presenceTable) get(screenName string) (bot.BuddyInfo, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	entry, ok := p.byUser[store.NormalizeScreenName(screenName)]
+	if !ok {
+		return bot.BuddyInfo{}, false
+	}
+	return entry.current(), true
+}
+
+func (p *presenceTable) list() []bot.BuddyInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]bot.BuddyInfo, 0, len(p.byUser))
+	for _, entry := range p.byUser {
+		list = append(list, entry.current())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return store.NormalizeScreenName(list[i].ScreenName) < store.NormalizeScreenName(list[j].ScreenName)
+	})
+	return list
+}
+
+func (p *presenceTable) remove(screenName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.byUser, store.NormalizeScreenName(screenName))
+}
+
+// clear forgets all statuses, which the server reports again after signing
+// on.
+func (p *presenceTable) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.byUser = nil
+	p.since = time.Now()
+}
+
+// current returns the buddy's status with the idle time brought up to date.
+func (p presence) current() bot.BuddyInfo {
+	info := p.info
+	if info.IdleFor > 0 {
+		info.IdleFor += time.Since(p.updated).Round(time.Minute)
+	}
+	return info
+}
+
+// Presence returns the status of screenName if they're on the bot's buddy
+// list.
+func (s *SessionManager) Presence(screenName string) (bot.BuddyInfo, bool) {
+	return s.presence.get(screenName)
+}
+
+// OnlineBuddies returns the users on the bot's buddy list who are signed on,
+// sorted by screen name.
+func (s *SessionManager) OnlineBuddies() []bot.BuddyInfo {
+	var online []bot.BuddyInfo
+	for _, info := range s.presence.list() {
+		if info.Online {
+			online = append(online, info)
+		}
+	}
+	return online
+}
+
+// Buddies returns the screen names on the bot's buddy list, sorted.
+func (s *SessionManager) Buddies() []string {
+	return s.buddies.list()
+}
+
+// AddBuddy adds screenName to the bot's buddy list so that the bot is told
+// when they sign on and off.
+func (s *SessionManager) AddBuddy(ctx context.Context, screenName string) error {
+	if s.State() != StateOnline {
+		return ErrOffline
+	}
+	s.feedbagMu.Lock()
+	defer s.feedbagMu.Unlock()
+
+	items, err := s.queryFeedbag(ctx)
+	if err != nil {
+		return err
+	}
+
+	var maxItemID, maxGroupID uint16
+	var group *wire.FeedbagItem
+	for i, item := range items {
+		maxItemID = max(maxItemID, item.ItemID)
+		maxGroupID = max(maxGroupID, item.GroupID)
+		switch {
+		case item.ClassID == wire.FeedbagClassIdBuddy &&
+			store.NormalizeScreenName(item.Name) == store.NormalizeScreenName(screenName):
+			s.buddies.add(item.Name)
+			return nil // already a buddy
+		case item.ClassID == wire.FeedbagClassIdGroup && item.GroupID != 0 && item.Name == s.config.BuddyGroup:
+			group = &items[i]
+		}
+	}
+
+	var inserts []wire.FeedbagItem
+	if group == nil {
+		maxGroupID++
+		group = &wire.FeedbagItem{
+			Name:    s.config.BuddyGroup,
+			GroupID: maxGroupID,
+			ClassID: wire.FeedbagClassIdGroup,
+		}
+		inserts = append(inserts, *group)
+	}
+	inserts = append(inserts, wire.FeedbagItem{
+		Name:    screenName,
+		GroupID: group.GroupID,
+		ItemID:  maxItemID + 1,
+		ClassID: wire.FeedbagClassIdBuddy,
+	})
+
+	if err := s.editFeedbag(ctx, wire.FeedbagInsertItem, wire.SNAC_0x13_0x08_FeedbagInsertItem{Items: inserts}); err != nil {
+		return err
+	}
+	s.buddies.add(screenName)
+	s.logger.Info("added buddy", "screen_name", screenName, "group", group.Name)
+	return nil
+}
+
+// RemoveBuddy removes screenName from the bot's buddy list.
+func (s *SessionManager) RemoveBuddy(ctx context.Context, screenName string) error {
+	if s.State() != StateOnline {
+		return ErrOffline
+	}
+	s.feedbagMu.Lock()
+	defer s.feedbagMu.Unlock()
+
+	items, err := s.queryFeedbag(ctx)
+	if err != nil {
+		return err
+	}
+
+	var deletes []wire.FeedbagItem
+	for _, item := range items {
+		if item.ClassID == wire.FeedbagClassIdBuddy &&
+			store.NormalizeScreenName(item.Name) == store.NormalizeScreenName(screenName) {
+			deletes = append(deletes, item)
+		}
+	}
+	if len(deletes) > 0 {
+		if err := s.editFeedbag(ctx, wire.FeedbagDeleteItem, wire.SNAC_0x13_0x0A_FeedbagDeleteItem{Items: deletes}); err != nil {
+			return err
+		}
+	}
+	s.buddies.remove(screenName)
+	s.presence.remove(screenName)
+	s.logger.Info("removed buddy", "screen_name", screenName)
+	return nil
+}
+
+// autoAddBuddy adds a user who IMed the bot to its buddy list if
+// AUTO_ADD_BUDDIES is enabled and there's room.
+func (s *SessionManager) autoAddBuddy(ctx context.Context, screenName string) {
+	if !s.config.AutoAddBuddies || s.buddies.contains(screenName) || len(s.buddies.list()) >= maxBuddies {
+		return
+	}
+	if err := s.AddBuddy(ctx, screenName); err != nil {
+		s.logger.Error("unable to add buddy", "screen_name", screenName, "err", err.Error())
+	}
+}
+
+// syncFeedbag loads the bot's buddy list and activates it so that the
+// server reports the status of each buddy, then blocks the users on the deny
+// list. It's called on sign on.
+func (s *SessionManager) syncFeedbag(ctx context.Context) {
+	if err := s.loadBuddyList(ctx); err != nil {
+		s.logger.Error("unable to load buddy list", "err", err.Error())
+	}
+	s.syncDenyList(ctx)
+}
+
+// loadBuddyList reads the buddies from the bot's feedbag and tells the
+// server to start sending their arrivals and departures.
+func (s *SessionManager) loadBuddyList(ctx context.Context) error {
+	s.feedbagMu.Lock()
+	defer s.feedbagMu.Unlock()
+
+	items, err := s.queryFeedbag(ctx)
+	if err != nil {
+		return err
+	}
+	buddies := screenNameSet{}
+	for _, item := range items {
+		if item.ClassID == wire.FeedbagClassIdBuddy {
+			buddies.add(item.Name)
+		}
+	}
+	s.buddies.replace(&buddies)
+
+	select {
+	case s.msgCh <- wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Feedbag,
+			SubGroup:  wire.FeedbagUse,
+		},
+		Body: struct{}{},
+	}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.logger.Debug("loaded buddy list", "buddies", len(buddies.names))
+	return nil
+}
+
+// updatePresence records a buddy arrival or departure sent by the server.
+// Subscribers who just signed on are welcomed back.
+func (s *SessionManager) updatePresence(ctx context.Context, flapBody *bytes.Buffer, online bool) error {
+	userInfo := wire.TLVUserInfo{}
+	if err := wire.UnmarshalBE(&userInfo, flapBody); err != nil {
+		return err
+	}
+	info := bot.BuddyInfo{ScreenName: userInfo.ScreenName}
+	if online {
+		info = newBuddyInfo(userInfo)
+	}
+	signedOn := s.presence.set(info)
+	s.logger.Debug("buddy status changed", "screen_name", info.ScreenName, "online", info.Online, "away", info.Away)
+	if signedOn {
+		go s.welcomeBack(ctx, info)
+	}
+	return nil
+}
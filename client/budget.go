@@ -0,0 +1,68 @@
+package client
+
+import (
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+// budgetUnitUSD is the BUDGET_UNIT for budgets in US dollars. Any other unit
+// means tokens.
+const budgetUnitUSD = "usd"
+
+// Budget replies are sent instead of a bot response once a budget is used
+// up.
+const (
+	userDailyBudgetReply   = "Phew, we've been chatting so much today that my fingers need a rest. Let's pick this up again tomorrow!"
+	userMonthlyBudgetReply = "We've talked so much this month that I'm all talked out! Catch you next month."
+	dailyBudgetReply       = "I've been chatting with so many people today that I'm all tuckered out. Try me again tomorrow!"
+	monthlyBudgetReply     = "I've been chatting with so many people this month that I need a vacation. Try me again next month!"
+)
+
+// checkBudget reports whether screenName or all users combined have used up
+// a usage budget. If so, it returns the reply to send instead of asking the
+// bot.
+func (s *SessionManager) checkBudget(screenName string, now time.Time) (reply string, exhausted bool) {
+	cfg := s.config
+	userDay, userMonth := s.ledger.Usage(screenName, now)
+	day, month := s.ledger.Total(now)
+	switch {
+	case s.overBudget(month, cfg.MonthlyBudget):
+		return monthlyBudgetReply, true
+	case s.overBudget(day, cfg.DailyBudget):
+		return dailyBudgetReply, true
+	case s.overBudget(userMonth, cfg.UserMonthlyBudget):
+		return userMonthlyBudgetReply, true
+	case s.overBudget(userDay, cfg.UserDailyBudget):
+		return userDailyBudgetReply, true
+	}
+	return "", false
+}
+
+// overBudget reports whether usage has reached budget, measured in the
+// configured budget unit. A budget of 0 is unlimited.
+func (s *SessionManager) overBudget(usage store.Usage, budget float64) bool {
+	if budget <= 0 {
+		return false
+	}
+	if s.config.BudgetUnit == budgetUnitUSD {
+		return usage.Cost >= budget
+	}
+	return float64(usage.Tokens) >= budget
+}
+
+// recordUsage adds the usage of a bot response to screenName's tally.
+func (s *SessionManager) recordUsage(screenName string, resp bot.Response) {
+	s.setModel(resp.Model)
+	usage := store.Usage{Tokens: int64(resp.Usage.Total()), Cost: resp.Cost}
+	if usage == (store.Usage{}) {
+		return
+	}
+	tokensConsumed.Add(float64(resp.Usage.PromptTokens), "prompt")
+	tokensConsumed.Add(float64(resp.Usage.CompletionTokens), "completion")
+	costConsumed.Add(resp.Cost)
+	if err := s.ledger.Record(screenName, usage, time.Now()); err != nil {
+		s.logger.Error("unable to record usage", "err", err.Error())
+	}
+}
@@ -2,31 +2,31 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 
 	"github.com/mk6i/retro-aim-server/wire"
 
+	"github.com/mk6i/smarter-smarter-child/bot"
 	"github.com/mk6i/smarter-smarter-child/config"
+	"github.com/mk6i/smarter-smarter-child/store"
 )
 
 // chatContext stores context for a conversation with a single user.
 type chatContext struct {
-	// cookie is the unique chat identifier generated by the AIM client.
+	// cookie is the unique chat identifier generated by the AIM client. It's
+	// guarded by SessionManager.chatContextsMu.
 	cookie uint64
-	// lastExchange holds the last two exchanged messages in a chat session to
-	// provide contextual memory for ChatGPT, facilitating more relevant and
-	// coherent responses. The array format is:
-	// [0] - the most recent message received from the user,
-	// [1] - the most recent message sent by the bot.
-	lastExchange *[2]string
 	// limiter enforces rate limits on messages to prevent spam.
 	limiter *rate.Limiter
 	// rateLimited flags whether the current chat session is being rate limited
@@ -37,9 +37,23 @@ type chatContext struct {
 	semaphore chan struct{}
 	// warnCount indicates how many times the user has warned the bot.
 	warnCount int
+	// lastActive is when the user last sent the bot an IM.
+	lastActive time.Time
+
+	// mu guards the typing state and queued messages below.
+	mu sync.Mutex
+	// typing indicates whether the user's client last reported them typing.
+	typing bool
+	// lastHeard is when the user last sent an IM or typing event.
+	lastHeard time.Time
+	// queued holds the messages the user sent while the bot was busy with
+	// their previous one. They're answered together in the bot's next reply.
+	queued []string
+	// heard is signalled whenever the user sends an IM or typing event.
+	heard chan struct{}
 }
 
-func (c chatContext) tryLock() bool {
+func (c *chatContext) tryLock() bool {
 	select {
 	case c.semaphore <- struct{}{}:
 		return true
@@ -48,52 +62,58 @@ func (c chatContext) tryLock() bool {
 	}
 }
 
-func (c chatContext) releaseLock() {
+func (c *chatContext) releaseLock() {
 	<-c.semaphore
 }
 
-// Chat handles conversations with multiple users.
-func Chat(logger *slog.Logger, flapc FlapClient, authCookie string, chatBot ChatBot, config config.Config) error {
-	if _, err := flapc.ReceiveSignonFrame(); err != nil {
-		return err
-	}
+// chat signs on to the BOS server and handles conversations with multiple
+// users until the connection ends. onOnline is called once the bot has
+// finished signing on.
+func (s *SessionManager) chat(ctx context.Context, flapc FlapClient, authCookie string, onOnline func()) error {
+	logger := s.logger
 
-	tlv := []wire.TLV{
-		wire.NewTLV(wire.OServiceTLVTagsLoginCookie, []byte(authCookie)),
-	}
-	if err := flapc.SendSignonFrame(tlv); err != nil {
-		return err
-	}
+	// abandon in-flight bot requests once this connection is over
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	hostOnlineFrame := wire.SNACFrame{}
-	hostOnlineSNAC := wire.SNAC_0x01_0x03_OServiceHostOnline{}
-	if err := flapc.ReceiveSNAC(&hostOnlineFrame, &hostOnlineSNAC); err != nil {
-		return err
-	}
+	// buddies' statuses are reported again once the buddy list is loaded
+	s.presence.clear()
 
-	clientOnlineFrame := wire.SNACFrame{
-		FoodGroup: wire.OService,
-		SubGroup:  wire.OServiceClientOnline,
-	}
-	clientOnlineSNAC := wire.SNAC_0x01_0x02_OServiceClientOnline{}
-	if err := flapc.SendSNAC(clientOnlineFrame, clientOnlineSNAC); err != nil {
+	if err := signon(flapc, authCookie); err != nil {
 		return err
 	}
 
-	if err := sendInfoSNAC(flapc, config); err != nil {
+	profile := s.renderProfile(time.Now())
+	if err := sendInfoSNAC(flapc, profile); err != nil {
 		return err
 	}
 
-	msgCh := make(chan wire.SNACMessage, 10)
+	onOnline()
 
-	// send client->server messages
-	go sendSNACs(logger, flapc, msgCh)
+	// done signals the background goroutines that this connection is over
+	done := make(chan struct{})
+	defer close(done)
+
+	// send client->server messages, leaving out the ones that went stale
+	// while the bot was signed off
+	dropStaleSNACs(logger, s.msgCh)
+	go sendSNACs(logger, flapc, s.msgCh, done, s.dropConnection)
 
 	// send heartbeats to the server to keep the connection alive
-	go sendHeartbeat(msgCh)
+	go sendHeartbeat(s.msgCh, done)
+
+	// join chat rooms in the background since it requires talking to BOS
+	go s.joinChatRooms(ctx)
 
-	// keep track of all chat contexts per screen name
-	chatContexts := make(map[string]*chatContext)
+	// keep the away message and idle time up to date
+	go s.manageStatus(ctx)
+
+	// keep the stats in the profile up to date
+	go s.refreshProfile(ctx, profile)
+
+	// load the buddy list, and block banned users on the server in case
+	// they were banned while offline
+	go s.syncFeedbag(ctx)
 
 	logger.Info("listening for incoming IMs")
 
@@ -124,15 +144,33 @@ func Chat(logger *slog.Logger, flapc FlapClient, authCookie string, chatBot Chat
 			slog.String("subgroup", wire.SubGroupName(snacFrame.FoodGroup, snacFrame.SubGroup)),
 		))
 
+		if s.requests.deliver(snacFrame, flapBody) {
+			continue // this SNAC is a reply to one of our requests
+		}
+
 		switch {
 		case snacFrame.FoodGroup == wire.ICBM && snacFrame.SubGroup == wire.ICBMChannelMsgToClient:
 			// received an IM, let's respond
-			if err := exchangeMessages(logger, msgCh, flapBody, chatContexts, chatBot, config); err != nil {
+			if err := s.exchangeMessages(ctx, flapBody); err != nil {
+				return err
+			}
+		case snacFrame.FoodGroup == wire.ICBM && snacFrame.SubGroup == wire.ICBMClientEvent:
+			// the user started or stopped typing
+			if err := s.handleClientEvent(flapBody); err != nil {
 				return err
 			}
 		case snacFrame.FoodGroup == wire.OService && snacFrame.SubGroup == wire.OServiceEvilNotification:
 			// received a warning, let's respond
-			if err := reactToWarning(logger, msgCh, chatContexts, flapBody, chatBot, config); err != nil {
+			if err := s.reactToWarning(ctx, flapBody); err != nil {
+				return err
+			}
+		case snacFrame.FoodGroup == wire.Buddy && snacFrame.SubGroup == wire.BuddyArrived:
+			// a buddy signed on or changed their status
+			if err := s.updatePresence(ctx, flapBody, true); err != nil {
+				return err
+			}
+		case snacFrame.FoodGroup == wire.Buddy && snacFrame.SubGroup == wire.BuddyDeparted:
+			if err := s.updatePresence(ctx, flapBody, false); err != nil {
 				return err
 			}
 		}
@@ -142,64 +180,124 @@ func Chat(logger *slog.Logger, flapc FlapClient, authCookie string, chatBot Chat
 	return nil
 }
 
-func sendHeartbeat(msgCh chan wire.SNACMessage) {
+func sendHeartbeat(msgCh chan wire.SNACMessage, done <-chan struct{}) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.Tick(1 * time.Minute):
-			msgCh <- wire.SNACMessage{
+		case <-done:
+			return
+		case <-ticker.C:
+			select {
+			case <-done:
+				return
+			case msgCh <- wire.SNACMessage{
 				Frame: wire.SNACFrame{
 					FoodGroup: wire.OService,
 					SubGroup:  wire.OServiceNoop,
 				},
 				Body: struct{}{},
+			}:
+			}
+		}
+	}
+}
+
+// dropStaleSNACs empties msgCh of the SNACs that were queued for an earlier
+// connection, except for IMs. Typing events, status updates and requests are
+// meaningless on a new connection, which starts its own. IMs are put back in
+// the order they were queued, so that replies and reminders are delivered
+// late rather than never.
+func dropStaleSNACs(logger *slog.Logger, msgCh chan wire.SNACMessage) {
+	var ims []wire.SNACMessage
+	var dropped int
+	for drained := false; !drained; {
+		select {
+		case msgSNAC := <-msgCh:
+			if msgSNAC.Frame.FoodGroup == wire.ICBM && msgSNAC.Frame.SubGroup == wire.ICBMChannelMsgToHost {
+				ims = append(ims, msgSNAC)
+			} else {
+				dropped++
 			}
+		default:
+			drained = true
+		}
+	}
+	for _, msgSNAC := range ims {
+		select {
+		case msgCh <- msgSNAC:
+		default:
+			// someone else has filled the queue in the meantime
+			dropped++
 		}
 	}
+	if dropped > 0 {
+		logger.Debug("dropped stale SNACs", "count", dropped)
+	}
 }
 
-func sendSNACs(logger *slog.Logger, flapc FlapClient, msgCh chan wire.SNACMessage) {
-	for msgSNAC := range msgCh {
+// sendSNACs sends the SNACs queued on msgCh until done is closed. If a send
+// fails, the connection is broken, so fail is called to tear it down.
+func sendSNACs(logger *slog.Logger, flapc FlapClient, msgCh chan wire.SNACMessage, done <-chan struct{}, fail func(error)) {
+	for {
+		var msgSNAC wire.SNACMessage
+		select {
+		case <-done:
+			return
+		case msgSNAC = <-msgCh:
+		}
 		group := slog.Group(
 			"snac",
 			slog.String("foodgroup", wire.FoodGroupName(msgSNAC.Frame.FoodGroup)),
 			slog.String("subgroup", wire.SubGroupName(msgSNAC.Frame.FoodGroup, msgSNAC.Frame.SubGroup)),
 		)
 		if err := flapc.SendSNAC(msgSNAC.Frame, msgSNAC.Body); err != nil {
-			logger.Error("error sending SNAC", group)
+			logger.Error("error sending SNAC", group, "err", err.Error())
+			fail(fmt.Errorf("unable to send SNAC: %w", err))
 			return
 		}
 		logger.Debug("sent SNAC", group)
 	}
 }
 
-func reactToWarning(
-	logger *slog.Logger,
-	msgCh chan<- wire.SNACMessage,
-	chatContexts map[string]*chatContext,
-	flapBody *bytes.Buffer,
-	chatBot ChatBot,
-	config config.Config,
-) error {
+func (s *SessionManager) reactToWarning(ctx context.Context, flapBody *bytes.Buffer) error {
+	logger := s.logger
 
 	chatMsg := wire.SNAC_0x01_0x10_OServiceEvilNotification{}
 	if err := wire.UnmarshalBE(&chatMsg, flapBody); err != nil {
 		return err
 	}
 
+	warningsReceived.Inc()
+
 	if chatMsg.Snitcher == nil {
 		return nil // anonymous warning, nothing to do
 	}
-	chatCtx, ok := chatContexts[chatMsg.Snitcher.ScreenName]
+	if _, ok := s.permitted(chatMsg.Snitcher.ScreenName); !ok {
+		logger.Debug("ignoring warning from user who isn't permitted", "screen_name", chatMsg.Snitcher.ScreenName)
+		return nil
+	}
+
+	s.chatContextsMu.Lock()
+	chatCtx, ok := s.chatContexts[chatMsg.Snitcher.ScreenName]
 	// chatMsg.ScreenName is "" (anonymous), or hasn't sent us an IM yet
 	if !ok {
+		s.chatContextsMu.Unlock()
 		logger.Debug("can't find chat context, moving on")
 		return nil
 	}
-
 	chatCtx.warnCount++
+	warnCount := chatCtx.warnCount
+	cookie := chatCtx.cookie
+	s.chatContextsMu.Unlock()
+
+	if _, exhausted := s.checkBudget(chatMsg.Snitcher.ScreenName, time.Now()); exhausted {
+		logger.Info("usage budget exhausted, ignoring warning", "screen_name", chatMsg.Snitcher.ScreenName)
+		return nil
+	}
 
 	var userMessage string
-	switch chatCtx.warnCount {
+	switch warnCount {
 	case 1:
 		userMessage = "Respond in a pleasant tone to me warning you for the first time."
 	case 2:
@@ -210,61 +308,105 @@ func reactToWarning(
 		userMessage = "Respond in an outraged tone to me warning you a fourth time."
 	}
 
-	botResponse, err := chatBot.ExchangeMessage(userMessage, *chatCtx.lastExchange)
+	history, err := s.conversations.History(chatMsg.Snitcher.ScreenName)
 	if err != nil {
-		return fmt.Errorf("unable to get response from bot: %w", err)
+		return fmt.Errorf("unable to load conversation history: %w", err)
+	}
+
+	respCtx, cancel := context.WithTimeout(ctx, s.config.ResponseTimeout)
+	defer cancel()
+
+	resp, err := s.chatBot.Respond(respCtx, bot.Request{
+		ScreenName:   chatMsg.Snitcher.ScreenName,
+		Text:         userMessage,
+		History:      history,
+		WarningLevel: chatMsg.Snitcher.WarningLevel,
+		Channel:      bot.ChannelIM,
+		ReceivedAt:   time.Now(),
+	})
+	if err != nil {
+		// don't drop the connection just because the bot is having trouble
+		logger.Error("unable to get response from bot", "err", err.Error())
+		s.sendFailureReply(ctx, cookie, chatMsg.Snitcher.ScreenName, err)
+		return nil
 	}
+	s.recordUsage(chatMsg.Snitcher.ScreenName, resp)
+	botResponse := resp.Text
 
-	if err := sendMessageSNAC(msgCh, chatCtx.cookie, chatMsg.Snitcher.ScreenName, botResponse, config); err != nil {
+	if err := sendMessageSNAC(ctx, s.msgCh, cookie, chatMsg.Snitcher.ScreenName, botResponse, s.config); err != nil {
 		return fmt.Errorf("unable to send response: %w", err)
 	}
 
 	// store this exchange to be used as context for next bot request
-	chatCtx.lastExchange[0] = userMessage
-	chatCtx.lastExchange[1] = botResponse
+	turn := store.Turn{User: userMessage, Bot: botResponse, Time: time.Now()}
+	if err := s.conversations.Append(chatMsg.Snitcher.ScreenName, turn); err != nil {
+		logger.Error("unable to save conversation history", "err", err.Error())
+	}
 
-	if chatCtx.warnCount == 3 {
-		sendWarningSNAC(msgCh, chatMsg.Snitcher.ScreenName)
+	if warnCount == 3 {
+		sendWarningSNAC(ctx, s.msgCh, chatMsg.Snitcher.ScreenName)
 	}
 
 	return nil
 }
 
 // exchangeMessages receives an IM and responds with a bot message.
-func exchangeMessages(
-	logger *slog.Logger,
-	msgCh chan wire.SNACMessage,
-	flapBody *bytes.Buffer,
-	chatContexts map[string]*chatContext,
-	chatBot ChatBot,
-	config config.Config,
-) error {
+func (s *SessionManager) exchangeMessages(ctx context.Context, flapBody *bytes.Buffer) error {
+	logger := s.logger
+	msgCh := s.msgCh
+	config := s.config
+	receivedAt := time.Now()
 
 	msgSNAC := wire.SNAC_0x04_0x07_ICBMChannelMsgToClient{}
 	if err := wire.UnmarshalBE(&msgSNAC, flapBody); err != nil {
 		return err
 	}
 
-	if _, ok := chatContexts[msgSNAC.ScreenName]; !ok {
+	if reason, ok := s.permitted(msgSNAC.ScreenName); !ok {
+		logger.Debug("ignoring message from user who isn't permitted", "screen_name", msgSNAC.ScreenName, "reason", reason)
+		messagesRejected.Inc(string(bot.ChannelIM), reason)
+		return nil
+	}
+
+	if msgSNAC.ChannelID == icbmChannelRendezvous {
+		// not an IM, but possibly an invitation to a chat room
+		return s.handleRendezvous(ctx, msgSNAC)
+	}
+
+	imsReceived.Inc()
+	s.lastActivity.Store(receivedAt.UnixNano())
+
+	s.chatContextsMu.Lock()
+	_, known := s.chatContexts[msgSNAC.ScreenName]
+	if !known {
 		// this is the first message received from this user
-		chatContexts[msgSNAC.ScreenName] = &chatContext{
-			cookie:       msgSNAC.Cookie,
-			semaphore:    make(chan struct{}, 1),
-			lastExchange: new([2]string),
-			limiter:      rate.NewLimiter(rate.Every(time.Minute), config.MaxMsgPerMin),
+		s.chatContexts[msgSNAC.ScreenName] = &chatContext{
+			cookie:    msgSNAC.Cookie,
+			semaphore: make(chan struct{}, 1),
+			heard:     make(chan struct{}, 1),
+			limiter:   rate.NewLimiter(rate.Every(time.Minute), config.MaxMsgPerMin),
 		}
 	}
 
 	// Retrieve chat context for current user.
-	chatCtx := chatContexts[msgSNAC.ScreenName]
-
-	// Update context with the latest conversation unique ID.
+	chatCtx := s.chatContexts[msgSNAC.ScreenName]
+	chatCtx.lastActive = receivedAt
+	// Update context with the latest conversation unique ID. It's read by
+	// operator IMs, so it's guarded by chatContextsMu.
 	chatCtx.cookie = msgSNAC.Cookie
+	s.chatContextsMu.Unlock()
+
+	if !known {
+		go s.autoAddBuddy(ctx, msgSNAC.ScreenName)
+	}
 
-	// Ignore this message if the bot is currently processing a message
-	// exchange.
+	// Sending a message clears the user's typing indicator.
+	chatCtx.heardFrom(false, receivedAt)
+
+	// If the bot is currently processing a message exchange, hold on to this
+	// message and answer it next.
 	if !chatCtx.tryLock() {
-		return nil // currently responding to user, drop message
+		return s.queueMessage(ctx, chatCtx, msgSNAC, receivedAt)
 	}
 
 	var messageSent bool
@@ -280,70 +422,231 @@ func exchangeMessages(
 	// reach the rate limit threshold, inform the user that they are sending
 	// messages too quickly and ignore subsequent messages until the rate limit
 	// window passes.
-	if hitRateLimit := enforceRateLimit(logger, msgCh, chatCtx, msgSNAC, config); hitRateLimit {
+	isAdmin := s.admins.contains(msgSNAC.ScreenName)
+	if hitRateLimit := !isAdmin && enforceRateLimit(ctx, logger, msgCh, chatCtx, msgSNAC, config); hitRateLimit {
 		logger.Info("user hit message rate limit", "screen_name", msgSNAC.ScreenName)
+		messagesRejected.Inc(string(bot.ChannelIM), rejectRateLimit)
 		return nil
 	}
 
 	// Get the message text buried in the SNAC payload.
-	b, hasIMData := msgSNAC.TLVRestBlock.Slice(wire.ICBMTLVAOLIMData)
+	msgText, hasIMData, err := imText(msgSNAC)
+	if err != nil {
+		return err
+	}
 	if !hasIMData {
 		logger.Debug("received ICBMChannelMsgToClient with no AOLIMData")
 		return nil
 	}
 
-	msgText, err := wire.UnmarshalICBMMessageText(b)
-	if err != nil {
-		return fmt.Errorf("unable to unmarshal ICBM message text: %w", err)
+	// While an operator has taken over the conversation, relay the user's
+	// messages to them instead of replying automatically.
+	if s.relayToOperator(ctx, msgSNAC.ScreenName, msgText) {
+		return nil
 	}
 
-	// Strip HTML formatting so that we don't confuse the bot.
-	msgText = stripHTMLTags(msgText)
+	if isAdmin {
+		// Admins can run admin commands and, while they're taking over a
+		// conversation, reply to the user as the bot.
+		if cmd, args, isCommand := s.adminCommands.Match(msgSNAC.ScreenName, msgText); isCommand {
+			messageSent = true
+			go func() {
+				defer s.answerQueued(ctx, chatCtx, msgSNAC)
+				s.runCommand(ctx, cmd, args, msgSNAC)
+			}()
+			return nil
+		}
+		if user, ok := s.takeoverOf(msgSNAC.ScreenName); ok {
+			messageSent = true
+			go func() {
+				defer s.answerQueued(ctx, chatCtx, msgSNAC)
+				if err := s.TakeoverReply(ctx, user, msgText); err != nil {
+					logger.Error("unable to send operator reply", "err", err.Error())
+					s.sendFailureReply(ctx, msgSNAC.Cookie, msgSNAC.ScreenName, err)
+				}
+			}()
+			return nil
+		}
+	}
+
+	// Handle commands without consulting the bot.
+	if cmd, args, isCommand := s.commands.Match(msgSNAC.ScreenName, msgText); isCommand {
+		messageSent = true
+		go func() {
+			defer s.answerQueued(ctx, chatCtx, msgSNAC)
+			s.runCommand(ctx, cmd, args, msgSNAC)
+		}()
+		return nil
+	}
 
 	// Make sure the message is not too big in order to minimize cost. OpenAI
 	// charges per token (which is effectively a word).
-	if hitMsgSizeLimit := enforceMsgSizeLimit(logger, msgText, msgCh, msgSNAC, config); hitMsgSizeLimit {
+	if hitMsgSizeLimit := enforceMsgSizeLimit(ctx, logger, msgText, msgCh, msgSNAC, config); hitMsgSizeLimit {
 		logger.Info("user hit message size limit", "screen_name", msgSNAC.ScreenName)
+		messagesRejected.Inc(string(bot.ChannelIM), rejectSizeLimit)
+		return nil
+	}
+
+	// Stop consulting the bot once the user, or everyone combined, has used
+	// up their AI model budget.
+	if reply, exhausted := s.checkBudget(msgSNAC.ScreenName, receivedAt); exhausted {
+		logger.Info("user hit usage budget", "screen_name", msgSNAC.ScreenName)
+		messagesRejected.Inc(string(bot.ChannelIM), rejectBudget)
+		if err := sendMessageSNAC(ctx, msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, reply, config); err != nil {
+			return fmt.Errorf("unable to send budget reply: %w", err)
+		}
 		return nil
 	}
 
 	messageSent = true
+	go s.converse(ctx, chatCtx, msgSNAC, msgText, receivedAt)
 
-	go func() {
-		defer chatCtx.releaseLock()
+	return nil
+}
 
-		if _, wantsEvents := msgSNAC.TLVRestBlock.Slice(wire.ICBMTLVWantEvents); wantsEvents {
-			// Tell the client that the bot is "typing". Provides a visual
-			// indicator in the IM window that something is happening.
-			sendTypingEventSNAC(msgSNAC, msgCh, 0x0002)
-		}
+// converse replies to msgText, which was received at receivedAt, and then to
+// any messages the user sent in the meantime.
+func (s *SessionManager) converse(ctx context.Context, chatCtx *chatContext, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, msgText string, receivedAt time.Time) {
+	s.reply(ctx, chatCtx, msgSNAC, msgText, receivedAt)
+	s.answerQueued(ctx, chatCtx, msgSNAC)
+}
+
+// reply sends the bot's response to msgText, which was received at
+// receivedAt, and saves the exchange to the user's conversation history.
+func (s *SessionManager) reply(ctx context.Context, chatCtx *chatContext, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, msgText string, receivedAt time.Time) {
+	logger := s.logger
+	msgCh := s.msgCh
+	config := s.config
+
+	// Let the user finish their thought before replying.
+	if queued := s.awaitTyping(ctx, chatCtx, receivedAt); len(queued) > 0 {
+		msgText = strings.Join(append([]string{msgText}, queued...), "\n")
+	}
+	started := time.Now()
+
+	_, wantsEvents := msgSNAC.TLVRestBlock.Slice(wire.ICBMTLVWantEvents)
+	if wantsEvents {
+		// Tell the client that the bot is "typing". Provides a visual
+		// indicator in the IM window that something is happening.
+		sendTypingEventSNAC(ctx, msgSNAC, msgCh, typingBegun)
+	}
 
+	// Load the conversation so far to give the bot some context.
+	history, err := s.conversations.History(msgSNAC.ScreenName)
+	if err != nil {
+		logger.Error("unable to load conversation history", "err", err.Error())
+		sendTypingEventSNAC(ctx, msgSNAC, msgCh, typingStopped)
+		return
+	}
+
+	req := bot.Request{
+		ScreenName:   msgSNAC.ScreenName,
+		Text:         msgText,
+		History:      history,
+		WarningLevel: msgSNAC.WarningLevel,
+		Channel:      bot.ChannelIM,
+		Persona:      s.personas.get(msgSNAC.ScreenName),
+		ReceivedAt:   receivedAt,
+	}
+	if presence, ok := s.presence.get(msgSNAC.ScreenName); ok && presence.Online {
+		req.Presence = &presence
+	}
+	req.Profile = s.userProfile(ctx, msgSNAC.ScreenName)
+
+	// Give up on the bot if it takes too long so that it doesn't hold
+	// up the conversation indefinitely.
+	respCtx, cancel := context.WithTimeout(ctx, config.ResponseTimeout)
+	defer cancel()
+
+	var botResponse string
+	if streamer, canStream := s.chatBot.(StreamingChatBot); canStream && config.StreamResponses {
+		// Send the bot's response piece by piece as it's generated.
+		resp, err := s.streamResponse(respCtx, streamer, req, msgSNAC, wantsEvents)
+		// the model was paid for even if sending the reply failed
+		s.recordUsage(msgSNAC.ScreenName, resp)
+		if err != nil {
+			logger.Error("unable to stream response from bot", "err", err.Error())
+			s.sendFailureReply(ctx, msgSNAC.Cookie, msgSNAC.ScreenName, err)
+			return
+		}
+		botResponse = resp.Text
+	} else {
 		// Get the bot's response to this message.
-		botResponse, err := chatBot.ExchangeMessage(msgText, *chatCtx.lastExchange)
+		resp, err := s.chatBot.Respond(respCtx, req)
 		if err != nil {
 			logger.Error("unable to get response from bot", "err", err.Error())
-			sendTypingEventSNAC(msgSNAC, msgCh, 0x0000)
+			s.sendFailureReply(ctx, msgSNAC.Cookie, msgSNAC.ScreenName, err)
+			return
+		}
+		s.recordUsage(msgSNAC.ScreenName, resp)
+		botResponse = resp.Text
+
+		// Take about as long as a person would to type the response.
+		if !s.simulateTyping(ctx, botResponse, started) {
 			return
 		}
 
 		// Send the bot's response.
-		if err := sendMessageSNAC(msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
+		if err := sendMessageSNAC(ctx, msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
 			logger.Error("unable to send response", "err", err.Error())
-			sendTypingEventSNAC(msgSNAC, msgCh, 0x0000)
+			sendTypingEventSNAC(ctx, msgSNAC, msgCh, typingStopped)
 			return
 		}
+	}
+
+	// Save this interaction for use as context in the next bot request.
+	turn := store.Turn{User: msgText, Bot: botResponse, Time: time.Now()}
+	if err := s.conversations.Append(msgSNAC.ScreenName, turn); err != nil {
+		logger.Error("unable to save conversation history", "err", err.Error())
+	}
 
-		// Save this interaction for use as context in the next bot request.
-		chatCtx.lastExchange[0] = msgText
-		chatCtx.lastExchange[1] = botResponse
+	logger.Info("message exchange", "screen_name", msgSNAC.ScreenName, "incoming", msgText, "outgoing", botResponse)
+}
 
-		logger.Info("message exchange", "screen_name", msgSNAC.ScreenName, "incoming", msgText, "outgoing", botResponse)
-	}()
+// failureReplies are the replies sent when the bot can't respond because of
+// an upstream API error, by kind of error.
+var failureReplies = map[bot.ErrorKind]string{
+	bot.ErrorKindRateLimited: "Whoa, everybody wants to talk to me right now! Give me a minute and try again.",
+	bot.ErrorKindQuota:       "I've talked so much lately that I lost my voice. Try me again later!",
+	bot.ErrorKindAuth:        "I'm having some technical difficulties. Try me again later!",
+	bot.ErrorKindRequest:     "I'm having some technical difficulties. Try me again later!",
+	bot.ErrorKindMalformed:   "Sorry, I got a little confused there. Could you say that again?",
+}
 
-	return nil
+// timeoutReply is the reply sent when the bot takes too long to respond.
+const timeoutReply = "Hmm, I was thinking so hard that I lost my train of thought. Try asking me again!"
+
+// sendFailureReply lets the user know that the bot couldn't come up with a
+// reply because of err so that they aren't left waiting. The reply arriving
+// also clears the typing indicator. Nothing is sent if the connection is
+// gone.
+func (s *SessionManager) sendFailureReply(ctx context.Context, cookie uint64, screenName string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	reply := s.config.FailureReply
+	if kind, ok := bot.ErrorKindOf(err); ok && failureReplies[kind] != "" {
+		reply = failureReplies[kind]
+	}
+	if isTimeout(err) {
+		reply = timeoutReply
+	}
+	if err := sendMessageSNAC(ctx, s.msgCh, cookie, screenName, reply, s.config); err != nil {
+		s.logger.Error("unable to send failure reply", "err", err.Error())
+	}
+}
+
+// isTimeout reports whether err was caused by a deadline being exceeded.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 func enforceMsgSizeLimit(
+	ctx context.Context,
 	logger *slog.Logger,
 	text string,
 	msgCh chan wire.SNACMessage,
@@ -354,7 +657,7 @@ func enforceMsgSizeLimit(
 	tooLong := exceedsMsgSizeLimit(text, config)
 	if tooLong {
 		botResponse := "Your message is too long for me! I am but a simple bot!"
-		if err := sendMessageSNAC(msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
+		if err := sendMessageSNAC(ctx, msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
 			logger.Error("unable to send size limit warning", "err", err.Error())
 		}
 	}
@@ -375,6 +678,7 @@ func exceedsMsgSizeLimit(text string, config config.Config) bool {
 }
 
 func enforceRateLimit(
+	ctx context.Context,
 	logger *slog.Logger,
 	msgCh chan wire.SNACMessage,
 	chatCtx *chatContext,
@@ -386,7 +690,7 @@ func enforceRateLimit(
 			chatCtx.rateLimited = true
 			go func() {
 				botResponse := "You're sending me too many messages! Slow down!"
-				if err := sendMessageSNAC(msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
+				if err := sendMessageSNAC(ctx, msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, botResponse, config); err != nil {
 					logger.Error("unable to send rate limit limit warning", "err", err.Error())
 					return
 				}
@@ -399,7 +703,46 @@ func enforceRateLimit(
 	return false
 }
 
-func sendMessageSNAC(msgCh chan<- wire.SNACMessage, cookie uint64, screenName string, response string, config config.Config) error {
+// sendMessageSNAC sends response to the user. Responses too long to fit in a
+// single IM are split into numbered parts, which are sent MsgPartDelay apart
+// to stay clear of the server's rate limits. It gives up if ctx is done before
+// every part is queued.
+func sendMessageSNAC(ctx context.Context, msgCh chan<- wire.SNACMessage, cookie uint64, screenName string, response string, config config.Config) error {
+	parts := segmentMessage(response, config.MsgFormat, config.MaxMsgLen)
+
+	// build the response messages up front so that a bad part doesn't leave
+	// the user with half of a response
+	msgs := make([]wire.SNACMessage, 0, len(parts))
+	for _, part := range parts {
+		msg, err := newMessageSNAC(cookie, screenName, part, config)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for i, msg := range msgs {
+		if i > 0 {
+			select {
+			case <-time.After(config.MsgPartDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case msgCh <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		imsSent.Inc()
+	}
+
+	return nil
+}
+
+// newMessageSNAC builds a single IM containing response wrapped in the
+// configured message format.
+func newMessageSNAC(cookie uint64, screenName string, response string, config config.Config) (wire.SNACMessage, error) {
 	msgFrame := wire.SNACFrame{
 		FoodGroup: wire.ICBM,
 		SubGroup:  wire.ICBMChannelMsgToHost,
@@ -410,14 +753,14 @@ func sendMessageSNAC(msgCh chan<- wire.SNACMessage, cookie uint64, screenName st
 
 	frags, err := wire.ICBMFragmentList(response)
 	if err != nil {
-		return fmt.Errorf("unable to create ICBM fragment list: %w", err)
+		return wire.SNACMessage{}, fmt.Errorf("unable to create ICBM fragment list: %w", err)
 	}
 
-	msgCh <- wire.SNACMessage{
+	return wire.SNACMessage{
 		Frame: msgFrame,
 		Body: wire.SNAC_0x04_0x06_ICBMChannelMsgToHost{
 			Cookie:     cookie,
-			ChannelID:  1,
+			ChannelID:  icbmChannelIM,
 			ScreenName: screenName,
 			TLVRestBlock: wire.TLVRestBlock{
 				TLVList: wire.TLVList{
@@ -425,13 +768,11 @@ func sendMessageSNAC(msgCh chan<- wire.SNACMessage, cookie uint64, screenName st
 				},
 			},
 		},
-	}
-
-	return nil
+	}, nil
 }
 
-func sendWarningSNAC(msgCh chan<- wire.SNACMessage, screenName string) {
-	msgCh <- wire.SNACMessage{
+func sendWarningSNAC(ctx context.Context, msgCh chan<- wire.SNACMessage, screenName string) {
+	msg := wire.SNACMessage{
 		Frame: wire.SNACFrame{
 			FoodGroup: wire.ICBM,
 			SubGroup:  wire.ICBMEvilRequest,
@@ -440,10 +781,15 @@ func sendWarningSNAC(msgCh chan<- wire.SNACMessage, screenName string) {
 			ScreenName: screenName,
 		},
 	}
+	select {
+	case msgCh <- msg:
+		warningsSent.Inc()
+	case <-ctx.Done():
+	}
 }
 
-func sendTypingEventSNAC(chatMsg wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, msgCh chan<- wire.SNACMessage, event uint16) {
-	msgCh <- wire.SNACMessage{
+func sendTypingEventSNAC(ctx context.Context, chatMsg wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, msgCh chan<- wire.SNACMessage, event uint16) {
+	msg := wire.SNACMessage{
 		Frame: wire.SNACFrame{
 			FoodGroup: wire.ICBM,
 			SubGroup:  wire.ICBMClientEvent,
@@ -455,6 +801,27 @@ func sendTypingEventSNAC(chatMsg wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, msg
 			Event:      event,
 		},
 	}
+	select {
+	case msgCh <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// imText returns the plain text of the IM in msgSNAC. ok is false if the SNAC
+// holds no message.
+func imText(msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient) (text string, ok bool, err error) {
+	b, hasIMData := msgSNAC.TLVRestBlock.Slice(wire.ICBMTLVAOLIMData)
+	if !hasIMData {
+		return "", false, nil
+	}
+
+	text, err = wire.UnmarshalICBMMessageText(b)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to unmarshal ICBM message text: %w", err)
+	}
+
+	// Strip HTML formatting so that we don't confuse the bot.
+	return stripHTMLTags(text), true, nil
 }
 
 var stripHTMLRegex = regexp.MustCompile("<[^>]*>")
@@ -464,21 +831,8 @@ func stripHTMLTags(input string) string {
 }
 
 // Set the bot's profile
-func sendInfoSNAC(flapc FlapClient, config config.Config) error {
-	profileSNAC := wire.SNACMessage{
-		Frame: wire.SNACFrame{
-			FoodGroup: wire.Locate,
-			SubGroup:  wire.LocateSetInfo,
-		},
-		Body: wire.SNAC_0x02_0x04_LocateSetInfo{
-			TLVRestBlock: wire.TLVRestBlock{
-				TLVList: wire.TLVList{
-					wire.NewTLV(wire.LocateTLVTagsInfoSigMime, `text/aolrtf; charset="us-ascii"`),
-					wire.NewTLV(wire.LocateTLVTagsInfoSigData, config.ProfileHTML),
-				},
-			},
-		},
-	}
+func sendInfoSNAC(flapc FlapClient, profile string) error {
+	profileSNAC := newProfileSNAC(profile)
 	err := flapc.SendSNAC(profileSNAC.Frame, profileSNAC.Body)
 	if err != nil {
 		return err
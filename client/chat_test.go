@@ -0,0 +1,35 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/mk6i/retro-aim-server/wire"
+)
+
+func TestDropStaleSNACs(t *testing.T) {
+	snac := func(foodGroup, subGroup uint16, body any) wire.SNACMessage {
+		return wire.SNACMessage{
+			Frame: wire.SNACFrame{FoodGroup: foodGroup, SubGroup: subGroup},
+			Body:  body,
+		}
+	}
+	msgCh := make(chan wire.SNACMessage, 10)
+	msgCh <- snac(wire.ICBM, wire.ICBMClientEvent, "typing")
+	msgCh <- snac(wire.ICBM, wire.ICBMChannelMsgToHost, "first IM")
+	msgCh <- snac(wire.OService, wire.OServiceIdleNotification, "idle")
+	msgCh <- snac(wire.Locate, wire.LocateSetInfo, "away")
+	msgCh <- snac(wire.ICBM, wire.ICBMChannelMsgToHost, "second IM")
+	msgCh <- snac(wire.OService, wire.OServiceNoop, "heartbeat")
+
+	dropStaleSNACs(slog.New(slog.NewTextHandler(io.Discard, nil)), msgCh)
+
+	var got []any
+	for len(msgCh) > 0 {
+		got = append(got, (<-msgCh).Body)
+	}
+	if len(got) != 2 || got[0] != "first IM" || got[1] != "second IM" {
+		t.Errorf("queued after reconnect = %v, want [first IM second IM]", got)
+	}
+}
@@ -0,0 +1,584 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+const (
+	// icbmChannelIM is the ICBM channel for plain instant messages.
+	icbmChannelIM uint16 = 1
+	// icbmChannelRendezvous is the ICBM channel for rendezvous requests such
+	// as chat room invitations.
+	icbmChannelRendezvous uint16 = 2
+	// chatRoomChannel is the message channel used inside chat rooms.
+	chatRoomChannel uint16 = 3
+
+	// rendezvousTypePropose is the rendezvous message type of an invitation.
+	rendezvousTypePropose uint16 = 0
+	// rendezvousTLVServiceData holds the chat room info in a chat invitation.
+	rendezvousTLVServiceData uint16 = 0x2711
+	// rendezvousTLVInviteText holds the text that accompanies an invitation.
+	rendezvousTLVInviteText uint16 = 0x0C
+
+	// chatExchangePrivate is the exchange that hosts user-created rooms.
+	chatExchangePrivate uint16 = 4
+
+	// chatMessageTLVText holds the text of a chat room message.
+	chatMessageTLVText uint16 = 0x01
+	// chatMessageTLVCharset holds the charset of a chat room message.
+	chatMessageTLVCharset uint16 = 0x02
+	// chatMessageTLVLanguage holds the language of a chat room message.
+	chatMessageTLVLanguage uint16 = 0x03
+)
+
+// chatCapability is the rendezvous capability UUID that identifies a chat room
+// invitation (748F2420-6287-11D1-8222-444553540000).
+var chatCapability = [16]byte{
+	0x74, 0x8F, 0x24, 0x20, 0x62, 0x87, 0x11, 0xD1,
+	0x82, 0x22, 0x44, 0x45, 0x53, 0x54, 0x00, 0x00,
+}
+
+// rendezvousHeader is the fixed-size header of an ICBM channel 2 rendezvous
+// message. It's followed by a TLV block.
+type rendezvousHeader struct {
+	Type       uint16
+	Cookie     uint64
+	Capability [16]byte
+}
+
+// chatRoom stores context for the bot's participation in a single chat room.
+type chatRoom struct {
+	// info identifies the room to the chat service.
+	info wire.SNAC_0x01_0x04_TLVRoomInfo
+	// name is the room's display name. Rooms joined by invitation are named
+	// after their cookie until the chat service tells us the real name.
+	name   string
+	nameMu sync.Mutex
+	// limiter caps how often the bot speaks in the room so that a busy room
+	// can't run up the bot's bill or get the bot kicked.
+	limiter *rate.Limiter
+	// semaphore ensures that only 1 response for the room is in-flight at
+	// any given time.
+	semaphore chan struct{}
+}
+
+func (r *chatRoom) getName() string {
+	r.nameMu.Lock()
+	defer r.nameMu.Unlock()
+	return r.name
+}
+
+func (r *chatRoom) setName(name string) {
+	r.nameMu.Lock()
+	defer r.nameMu.Unlock()
+	r.name = name
+}
+
+func (r *chatRoom) tryLock() bool {
+	select {
+	case r.semaphore <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *chatRoom) releaseLock() {
+	<-r.semaphore
+}
+
+// historyKey is the conversation store key for the room's history. Screen
+// names can't contain '#', so room history never collides with a user's.
+func (r *chatRoom) historyKey() string {
+	return "#" + r.getName()
+}
+
+// joinChatRooms joins the chat rooms listed in the config, along with any
+// rooms the bot was invited to before the last reconnect.
+func (s *SessionManager) joinChatRooms(ctx context.Context) {
+	for _, name := range s.config.ChatRooms {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		info, err := s.findChatRoom(ctx, name)
+		if err != nil {
+			s.logger.Error("unable to find chat room", "room", name, "err", err.Error())
+			continue
+		}
+		// configured rooms are always joined
+		s.addChatRoom(info, name, 0)
+	}
+
+	s.roomsMu.Lock()
+	rooms := make([]*chatRoom, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMu.Unlock()
+
+	for _, room := range rooms {
+		go s.stayInChatRoom(ctx, room)
+	}
+}
+
+// stayInChatRoom runs room until its connection ends. If the room's
+// connection ended while the bot is still signed on, the room is forgotten so
+// that a later invitation joins it again. Otherwise it's kept so that it's
+// rejoined after reconnecting.
+func (s *SessionManager) stayInChatRoom(ctx context.Context, room *chatRoom) {
+	err := s.runChatRoom(ctx, room)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		s.logger.Error("left chat room", "room", room.getName(), "err", err.Error())
+	} else {
+		s.logger.Info("left chat room", "room", room.getName())
+	}
+	s.removeChatRoom(room)
+}
+
+// errTooManyRooms is returned by addChatRoom when the bot is already in as
+// many chat rooms as it may be.
+var errTooManyRooms = errors.New("too many chat rooms")
+
+// addChatRoom registers a chat room, returning the existing context if the
+// room is already registered. A new room isn't registered if there are
+// already limit rooms, unless limit is 0.
+func (s *SessionManager) addChatRoom(info wire.SNAC_0x01_0x04_TLVRoomInfo, name string, limit int) (*chatRoom, bool, error) {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	if room, ok := s.rooms[info.Cookie]; ok {
+		return room, false, nil
+	}
+	if limit > 0 && len(s.rooms) >= limit {
+		return nil, false, errTooManyRooms
+	}
+	room := &chatRoom{
+		info:      info,
+		name:      name,
+		limiter:   rate.NewLimiter(rate.Every(time.Minute), s.config.ChatRoomMaxMsgPerMin),
+		semaphore: make(chan struct{}, 1),
+	}
+	s.rooms[info.Cookie] = room
+	return room, true, nil
+}
+
+// removeChatRoom unregisters room, unless it has already been replaced.
+func (s *SessionManager) removeChatRoom(room *chatRoom) {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	if s.rooms[room.info.Cookie] == room {
+		delete(s.rooms, room.info.Cookie)
+	}
+}
+
+// findChatRoom looks up a chat room by name using the ChatNav service,
+// creating the room if it doesn't exist yet.
+func (s *SessionManager) findChatRoom(ctx context.Context, name string) (wire.SNAC_0x01_0x04_TLVRoomInfo, error) {
+	host, cookie, err := s.requestService(ctx, wire.ChatNav)
+	if err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, err
+	}
+
+	conn, err := s.dial(ctx, "tcp", host)
+	if err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, fmt.Errorf("unable to dial into ChatNav host: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	flapc := wire.NewFlapClient(0, conn, conn)
+	if err := signon(flapc, cookie); err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, fmt.Errorf("unable to sign on to ChatNav: %w", err)
+	}
+
+	createRoom := wire.SNAC_0x0E_0x02_ChatRoomInfoUpdate{
+		Exchange:       chatExchangePrivate,
+		Cookie:         "create",
+		InstanceNumber: 0xFFFF, // let the server pick
+		DetailLevel:    0x01,
+		TLVBlock: wire.TLVBlock{
+			TLVList: wire.TLVList{
+				wire.NewTLV(wire.ChatRoomTLVRoomName, name),
+			},
+		},
+	}
+	frame := wire.SNACFrame{
+		FoodGroup: wire.ChatNav,
+		SubGroup:  wire.ChatNavCreateRoom,
+	}
+	if err := flapc.SendSNAC(frame, createRoom); err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, err
+	}
+
+	navInfo := wire.SNAC_0x0D_0x09_ChatNavNavInfo{}
+	if err := flapc.ReceiveSNAC(&frame, &navInfo); err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, err
+	}
+	if frame.FoodGroup != wire.ChatNav || frame.SubGroup != wire.ChatNavNavInfo {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, fmt.Errorf("unable to create room: got %s",
+			wire.SubGroupName(frame.FoodGroup, frame.SubGroup))
+	}
+
+	b, hasRoomInfo := navInfo.Slice(wire.ChatNavTLVRoomInfo)
+	if !hasRoomInfo {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, errors.New("SNAC(0x0D,0x09) does not contain a room info TLV")
+	}
+	roomInfo := wire.SNAC_0x0E_0x02_ChatRoomInfoUpdate{}
+	if err := wire.UnmarshalBE(&roomInfo, bytes.NewBuffer(b)); err != nil {
+		return wire.SNAC_0x01_0x04_TLVRoomInfo{}, err
+	}
+
+	return wire.SNAC_0x01_0x04_TLVRoomInfo{
+		Exchange:       roomInfo.Exchange,
+		Cookie:         roomInfo.Cookie,
+		InstanceNumber: roomInfo.InstanceNumber,
+	}, nil
+}
+
+// handleRendezvous accepts chat room invitations sent over ICBM channel 2.
+// Other rendezvous requests such as file transfers are ignored.
+func (s *SessionManager) handleRendezvous(ctx context.Context, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient) error {
+	b, hasData := msgSNAC.TLVRestBlock.Slice(wire.ICBMTLVData)
+	if !hasData {
+		return nil
+	}
+
+	buf := bytes.NewBuffer(b)
+	hdr := rendezvousHeader{}
+	if err := binary.Read(buf, binary.BigEndian, &hdr); err != nil {
+		s.logger.Debug("unable to read rendezvous header", "err", err.Error())
+		return nil
+	}
+	if hdr.Type != rendezvousTypePropose || hdr.Capability != chatCapability {
+		s.logger.Debug("ignoring rendezvous request", "screen_name", msgSNAC.ScreenName)
+		return nil
+	}
+
+	tlvs := wire.TLVRestBlock{}
+	if err := wire.UnmarshalBE(&tlvs, buf); err != nil {
+		s.logger.Debug("unable to read rendezvous TLVs", "err", err.Error())
+		return nil
+	}
+	svcData, hasSvcData := tlvs.Slice(rendezvousTLVServiceData)
+	if !hasSvcData {
+		s.logger.Debug("chat invitation does not contain room info", "screen_name", msgSNAC.ScreenName)
+		return nil
+	}
+	info := wire.SNAC_0x01_0x04_TLVRoomInfo{}
+	if err := wire.UnmarshalBE(&info, bytes.NewBuffer(svcData)); err != nil {
+		s.logger.Debug("unable to read chat invitation room info", "err", err.Error())
+		return nil
+	}
+
+	inviteText, _ := tlvs.String(rendezvousTLVInviteText)
+	s.logger.Info("received chat room invitation", "screen_name", msgSNAC.ScreenName, "message", inviteText)
+
+	room, isNew, err := s.addChatRoom(info, info.Cookie, s.config.MaxChatRooms)
+	if errors.Is(err, errTooManyRooms) {
+		s.logger.Info("declined chat room invitation, in too many rooms", "screen_name", msgSNAC.ScreenName)
+		return sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName,
+			"Sorry, I'm in too many chat rooms right now to join another one.", s.config)
+	}
+	if !isNew {
+		return nil // already in the room
+	}
+	go s.stayInChatRoom(ctx, room)
+	return nil
+}
+
+// runChatRoom connects to the chat service for room and responds to
+// messages that address the bot until the connection ends.
+func (s *SessionManager) runChatRoom(ctx context.Context, room *chatRoom) (err error) {
+	ctx, dropConn := context.WithCancelCause(ctx)
+	defer dropConn(nil)
+	defer func() {
+		// report why the connection was dropped rather than the read error
+		// that dropping it caused
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			err = cause
+		}
+	}()
+
+	host, cookie, err := s.requestService(ctx, wire.Chat, wire.NewTLV(0x01, room.info))
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.dial(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("unable to dial into chat host: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	flapc := wire.NewFlapClient(0, conn, conn)
+	if err := signon(flapc, cookie); err != nil {
+		return fmt.Errorf("unable to sign on to chat service: %w", err)
+	}
+
+	s.logger.Info("joined chat room", "room", room.getName())
+
+	done := make(chan struct{})
+	defer close(done)
+
+	msgCh := make(chan wire.SNACMessage, 10)
+	go sendSNACs(s.logger, flapc, msgCh, done, dropConn)
+
+	for {
+		flap, err := flapc.ReceiveFLAP()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if flap.FrameType == wire.FLAPFrameSignoff {
+			return nil
+		}
+		if flap.FrameType != wire.FLAPFrameData {
+			continue
+		}
+
+		flapBody := bytes.NewBuffer(flap.Payload)
+		snacFrame := wire.SNACFrame{}
+		if err := wire.UnmarshalBE(&snacFrame, flapBody); err != nil {
+			return err
+		}
+
+		switch {
+		case snacFrame.FoodGroup == wire.Chat && snacFrame.SubGroup == wire.ChatRoomInfoUpdate:
+			roomInfo := wire.SNAC_0x0E_0x02_ChatRoomInfoUpdate{}
+			if err := wire.UnmarshalBE(&roomInfo, flapBody); err != nil {
+				return err
+			}
+			if name, hasName := roomInfo.String(wire.ChatRoomTLVRoomName); hasName {
+				room.setName(name)
+			}
+		case snacFrame.FoodGroup == wire.Chat && snacFrame.SubGroup == wire.ChatChannelMsgToClient:
+			if err := s.exchangeChatRoomMessages(ctx, room, msgCh, flapBody); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exchangeChatRoomMessages responds to a chat room message if it's addressed
+// to the bot.
+func (s *SessionManager) exchangeChatRoomMessages(ctx context.Context, room *chatRoom, msgCh chan<- wire.SNACMessage, flapBody *bytes.Buffer) error {
+	receivedAt := time.Now()
+
+	msgSNAC := wire.SNAC_0x0E_0x06_ChatChannelMsgToClient{}
+	if err := wire.UnmarshalBE(&msgSNAC, flapBody); err != nil {
+		return err
+	}
+
+	senderInfo, hasSender := msgSNAC.Slice(wire.ChatTLVSenderInformation)
+	if !hasSender {
+		return nil
+	}
+	sender := wire.TLVUserInfo{}
+	if err := wire.UnmarshalBE(&sender, bytes.NewBuffer(senderInfo)); err != nil {
+		return fmt.Errorf("unable to read chat message sender: %w", err)
+	}
+	if store.NormalizeScreenName(sender.ScreenName) == store.NormalizeScreenName(s.config.ScreenName) {
+		return nil // our own message reflected back
+	}
+	if _, ok := s.permitted(sender.ScreenName); !ok {
+		return nil
+	}
+
+	msgInfo, hasMsg := msgSNAC.Slice(wire.ChatTLVMessageInformation)
+	if !hasMsg {
+		return nil
+	}
+	msgTLVs := wire.TLVRestBlock{}
+	if err := wire.UnmarshalBE(&msgTLVs, bytes.NewBuffer(msgInfo)); err != nil {
+		return fmt.Errorf("unable to read chat message: %w", err)
+	}
+	msgText, _ := msgTLVs.String(chatMessageTLVText)
+	msgText = stripHTMLTags(msgText)
+
+	msgText, addressed := s.mentions.addressedToBot(msgText)
+	if !addressed {
+		return nil
+	}
+
+	chatRoomMessagesReceived.Inc()
+	s.lastActivity.Store(time.Now().UnixNano())
+
+	if exceedsMsgSizeLimit(msgText, s.config) {
+		s.logger.Info("chat room message exceeds size limit", "room", room.getName(), "screen_name", sender.ScreenName)
+		messagesRejected.Inc(string(bot.ChannelChatRoom), rejectSizeLimit)
+		return nil
+	}
+	if !room.limiter.Allow() {
+		s.logger.Info("chat room hit message rate limit", "room", room.getName())
+		messagesRejected.Inc(string(bot.ChannelChatRoom), rejectRateLimit)
+		return nil
+	}
+	if !room.tryLock() {
+		return nil // currently responding in this room, drop message
+	}
+	if reply, exhausted := s.checkBudget(sender.ScreenName, receivedAt); exhausted {
+		defer room.releaseLock()
+		s.logger.Info("user hit usage budget", "room", room.getName(), "screen_name", sender.ScreenName)
+		messagesRejected.Inc(string(bot.ChannelChatRoom), rejectBudget)
+		return sendChatRoomMessageSNAC(ctx, msgCh, sender.ScreenName+": "+reply, s.config.MsgFormat, s.config.MaxMsgLen, s.config.MsgPartDelay)
+	}
+
+	go func() {
+		defer room.releaseLock()
+
+		history, err := s.conversations.History(room.historyKey())
+		if err != nil {
+			s.logger.Error("unable to load chat room history", "err", err.Error())
+			return
+		}
+
+		respCtx, cancel := context.WithTimeout(ctx, s.config.ResponseTimeout)
+		defer cancel()
+
+		resp, err := s.chatBot.Respond(respCtx, bot.Request{
+			ScreenName:   sender.ScreenName,
+			Text:         msgText,
+			History:      history,
+			WarningLevel: sender.WarningLevel,
+			Channel:      bot.ChannelChatRoom,
+			ChatRoom:     room.getName(),
+			ReceivedAt:   receivedAt,
+		})
+		if err != nil {
+			s.logger.Error("unable to get response from bot", "err", err.Error())
+			return
+		}
+		s.recordUsage(sender.ScreenName, resp)
+
+		// address the reply to the user so that it's clear who the bot is
+		// talking to
+		botResponse := sender.ScreenName + ": " + resp.Text
+		if err := sendChatRoomMessageSNAC(ctx, msgCh, botResponse, s.config.MsgFormat, s.config.MaxMsgLen, s.config.MsgPartDelay); err != nil {
+			s.logger.Error("unable to send chat room response", "err", err.Error())
+			return
+		}
+
+		turn := store.Turn{User: sender.ScreenName + ": " + msgText, Bot: resp.Text, Time: time.Now()}
+		if err := s.conversations.Append(room.historyKey(), turn); err != nil {
+			s.logger.Error("unable to save chat room history", "err", err.Error())
+		}
+
+		s.logger.Info("chat room exchange", "room", room.getName(), "screen_name", sender.ScreenName,
+			"incoming", msgText, "outgoing", resp.Text)
+	}()
+
+	return nil
+}
+
+// mentionMatcher finds the places in chat room messages where the bot is
+// addressed by its screen name.
+type mentionMatcher struct {
+	// mention matches an @mention anywhere in a message.
+	mention *regexp.Regexp
+	// prefix matches the screen name at the start of a message.
+	prefix *regexp.Regexp
+}
+
+// newMentionMatcher creates a mentionMatcher for screenName. Matching is
+// case-insensitive and done against the original text, since lowercasing it
+// can change the byte offsets of what follows.
+func newMentionMatcher(screenName string) mentionMatcher {
+	quoted := regexp.QuoteMeta(screenName)
+	return mentionMatcher{
+		// \b keeps @name2 from matching @name
+		mention: regexp.MustCompile(`(?i)@` + quoted + `\b`),
+		prefix:  regexp.MustCompile(`^(?i)` + quoted),
+	}
+}
+
+// addressedToBot reports whether a chat room message is directed at the bot,
+// either with an @mention anywhere in the message or by starting the message
+// with the bot's screen name. It returns the message with the mention
+// removed.
+func (m mentionMatcher) addressedToBot(text string) (string, bool) {
+	if loc := m.mention.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]] + text[loc[1]:]
+		return strings.TrimSpace(text), true
+	}
+	if loc := m.prefix.FindStringIndex(text); loc != nil {
+		rest := text[loc[1]:]
+		if rest == "" || strings.ContainsAny(rest[:1], ":, ") {
+			return strings.TrimSpace(strings.TrimLeft(rest, ":,")), true
+		}
+	}
+	return text, false
+}
+
+// sendChatRoomMessageSNAC sends a message to a chat room, splitting it into
+// numbered parts if it's too long.
+func sendChatRoomMessageSNAC(ctx context.Context, msgCh chan<- wire.SNACMessage, text string, msgFormat string, maxLen int, partDelay time.Duration) error {
+	for i, part := range segmentMessage(text, msgFormat, maxLen) {
+		if i > 0 {
+			select {
+			case <-time.After(partDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		msgInfo := wire.TLVRestBlock{
+			TLVList: wire.TLVList{
+				wire.NewTLV(chatMessageTLVText, strings.ReplaceAll(msgFormat, "@MsgContent@", part)),
+				wire.NewTLV(chatMessageTLVCharset, "us-ascii"),
+				wire.NewTLV(chatMessageTLVLanguage, "en"),
+			},
+		}
+		msg := wire.SNACMessage{
+			Frame: wire.SNACFrame{
+				FoodGroup: wire.Chat,
+				SubGroup:  wire.ChatChannelMsgToHost,
+			},
+			Body: wire.SNAC_0x0E_0x05_ChatChannelMsgToHost{
+				Cookie:  rand.Uint64(),
+				Channel: chatRoomChannel,
+				TLVRestBlock: wire.TLVRestBlock{
+					TLVList: wire.TLVList{
+						wire.NewTLV(wire.ChatTLVPublicWhisperFlag, []byte{}),
+						wire.NewTLV(wire.ChatTLVMessageInformation, msgInfo),
+					},
+				},
+			},
+		}
+		select {
+		case msgCh <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
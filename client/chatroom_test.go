@@ -0,0 +1,31 @@
+package client
+
+import "testing"
+
+func TestAddressedToBot(t *testing.T) {
+	m := newMentionMatcher("SmarterChild")
+	tests := []struct {
+		text          string
+		wantText      string
+		wantAddressed bool
+	}{
+		{text: "@smarterchild what's up?", wantText: "what's up?", wantAddressed: true},
+		{text: "hey @SMARTERCHILD, how are you", wantText: "hey , how are you", wantAddressed: true},
+		{text: "@SmarterChild what about İstanbul?", wantText: "what about İstanbul?", wantAddressed: true},
+		{text: "İstanbul is nice, right @smarterchild", wantText: "İstanbul is nice, right", wantAddressed: true},
+		{text: "SmarterChild: tell me a joke", wantText: "tell me a joke", wantAddressed: true},
+		{text: "smarterchild, hi", wantText: "hi", wantAddressed: true},
+		{text: "smarterchild", wantText: "", wantAddressed: true},
+		{text: "@smarterchild2 hi", wantText: "@smarterchild2 hi"},
+		{text: "smarterchildren are cool", wantText: "smarterchildren are cool"},
+		{text: "I like smarterchild", wantText: "I like smarterchild"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			text, addressed := m.addressedToBot(tt.text)
+			if text != tt.wantText || addressed != tt.wantAddressed {
+				t.Errorf("addressedToBot() = %q, %v, want %q, %v", text, addressed, tt.wantText, tt.wantAddressed)
+			}
+		})
+	}
+}
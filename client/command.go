@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandHandler runs a command and returns the reply to send to the user.
+type CommandHandler func(ctx context.Context, call CommandCall) (string, error)
+
+// CommandCall describes a single invocation of a command.
+type CommandCall struct {
+	// ScreenName is the screen name of the user who sent the command.
+	ScreenName string
+	// Args is the text that follows the command name, with surrounding
+	// whitespace removed.
+	Args string
+	// Reply sends an IM to the user. It lets handlers respond after they
+	// return, e.g. to deliver a reminder.
+	Reply func(text string) error
+}
+
+// Command is a built-in bot feature that's handled deterministically instead
+// of being sent to the chat bot.
+type Command struct {
+	// Name is the command name without the leading slash, e.g. "help".
+	Name string
+	// Usage shows how to invoke the command, e.g. "/remind <duration> <msg>".
+	Usage string
+	// Description briefly explains what the command does.
+	Description string
+	// Allowed reports whether screenName may run the command. A nil Allowed
+	// lets everyone run it.
+	Allowed func(screenName string) bool
+	// Handler runs the command.
+	Handler CommandHandler
+}
+
+func (c Command) allowed(screenName string) bool {
+	return c.Allowed == nil || c.Allowed(screenName)
+}
+
+// NewCommandRouter creates a CommandRouter for commands that start with
+// prefix.
+func NewCommandRouter(prefix string) *CommandRouter {
+	return &CommandRouter{
+		prefix:   prefix,
+		commands: make(map[string]Command),
+	}
+}
+
+// CommandRouter intercepts messages that start with the command prefix and
+// dispatches them to registered commands.
+type CommandRouter struct {
+	prefix   string
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// Register adds a command to the router. It returns an error if the command
+// is already registered.
+func (r *CommandRouter) Register(cmd Command) error {
+	name := strings.ToLower(cmd.Name)
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %q has no handler", cmd.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[name]; exists {
+		return fmt.Errorf("command %q is already registered", cmd.Name)
+	}
+	r.commands[name] = cmd
+	return nil
+}
+
+// Match parses a message as a command. It returns false if the message
+// doesn't name a command that screenName may run, e.g. "/r/golang is great",
+// so that it's answered by the chat bot instead.
+func (r *CommandRouter) Match(screenName string, text string) (Command, string, bool) {
+	text = strings.TrimSpace(text)
+	rest, isCommand := strings.CutPrefix(text, r.prefix)
+	if !isCommand || rest == "" {
+		return Command{}, "", false
+	}
+
+	name, args, _ := strings.Cut(rest, " ")
+	name = strings.ToLower(name)
+
+	r.mu.RLock()
+	cmd, exists := r.commands[name]
+	r.mu.RUnlock()
+
+	if !exists || !cmd.allowed(screenName) {
+		return Command{}, "", false
+	}
+	return cmd, strings.TrimSpace(args), true
+}
+
+// Help returns a listing of the commands screenName is allowed to run.
+func (r *CommandRouter) Help(screenName string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.commands))
+	for name, cmd := range r.commands {
+		if cmd.allowed(screenName) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Here's what I can do:")
+	for _, name := range names {
+		cmd := r.commands[name]
+		usage := cmd.Usage
+		if usage == "" {
+			usage = r.prefix + name
+		}
+		fmt.Fprintf(&sb, "<BR>%s - %s", html.EscapeString(usage), html.EscapeString(cmd.Description))
+	}
+	return sb.String()
+}
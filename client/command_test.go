@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCommandRouterMatch(t *testing.T) {
+	r := NewCommandRouter("/")
+	handler := func(context.Context, CommandCall) (string, error) { return "", nil }
+	if err := r.Register(Command{Name: "remind", Handler: handler}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(Command{
+		Name:    "secret",
+		Handler: handler,
+		Allowed: func(screenName string) bool { return screenName == "admin" },
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		screenName string
+		text       string
+		wantName   string
+		wantArgs   string
+		wantMatch  bool
+	}{
+		{screenName: "user", text: "/remind 5m stretch", wantName: "remind", wantArgs: "5m stretch", wantMatch: true},
+		{screenName: "user", text: "  /REMIND  ", wantName: "remind", wantMatch: true},
+		{screenName: "user", text: "/r/golang is great"},
+		{screenName: "user", text: "/shrug"},
+		{screenName: "user", text: "/"},
+		{screenName: "user", text: "remind me later"},
+		{screenName: "user", text: "/secret"},
+		{screenName: "admin", text: "/secret", wantName: "secret", wantMatch: true},
+	}
+	for _, tt := range tests {
+		cmd, args, ok := r.Match(tt.screenName, tt.text)
+		if ok != tt.wantMatch || cmd.Name != tt.wantName || args != tt.wantArgs {
+			t.Errorf("Match(%q, %q) = %q, %q, %v; want %q, %q, %v",
+				tt.screenName, tt.text, cmd.Name, args, ok, tt.wantName, tt.wantArgs, tt.wantMatch)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+const (
+	// commandPrefix marks an IM as a command rather than a message for the
+	// chat bot.
+	commandPrefix = "/"
+	// maxPersonaLen is the longest persona description a user may set.
+	maxPersonaLen = 200
+	// maxReminderDelay is the furthest in the future a reminder may be set.
+	// Reminders are not persisted, so long delays are unlikely to survive a
+	// restart anyway.
+	maxReminderDelay = 24 * time.Hour
+	// maxWeatherReplyLen caps how much of the weather service's reply is
+	// relayed to the user.
+	maxWeatherReplyLen = 512
+	// maxRemindersPerUser is the number of reminders a user may have pending
+	// at once.
+	maxRemindersPerUser = 5
+	// reminderSendTimeout is how long a due reminder waits to be queued
+	// while the bot is signed off before it's dropped.
+	reminderSendTimeout = 5 * time.Minute
+)
+
+// ErrTooManyReminders is returned when scheduling a reminder for a user who
+// already has maxRemindersPerUser reminders pending.
+var ErrTooManyReminders = errors.New("too many pending reminders")
+
+// RegisterCommand adds cmd to the commands the bot handles on its own. It
+// must be called before Run.
+func (s *SessionManager) RegisterCommand(cmd Command) error {
+	return s.commands.Register(cmd)
+}
+
+// registerBuiltinCommands registers the commands that ship with the bot.
+func (s *SessionManager) registerBuiltinCommands() {
+	builtins := []Command{
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List the commands I understand.",
+			Handler:     s.helpCommand,
+		},
+		{
+			Name:        "reset",
+			Usage:       "/reset",
+			Description: "Make me forget our conversation so far.",
+			Handler:     s.resetCommand,
+		},
+		{
+			Name:        "persona",
+			Usage:       "/persona [description|off]",
+			Description: "Change who I pretend to be while chatting with you.",
+			Handler:     s.personaCommand,
+		},
+		{
+			Name:        "weather",
+			Usage:       "/weather [location]",
+			Description: "Get the current weather for a location.",
+			Handler:     s.weatherCommand,
+		},
+		{
+			Name:        "remind",
+			Usage:       "/remind [duration] [message]",
+			Description: "Have me IM you a reminder later, e.g. /remind 10m stretch.",
+			Handler:     s.remindCommand,
+		},
+		{
+			Name:        "subscribe",
+			Usage:       "/subscribe",
+			Description: "Have me say hi whenever you sign on.",
+			Handler:     s.subscribeCommand,
+		},
+		{
+			Name:        "unsubscribe",
+			Usage:       "/unsubscribe",
+			Description: "Stop me from saying hi when you sign on.",
+			Handler:     s.unsubscribeCommand,
+		},
+	}
+	for _, cmd := range builtins {
+		if err := s.commands.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// runCommand runs cmd on behalf of the user who sent msgSNAC and sends them
+// the result.
+func (s *SessionManager) runCommand(ctx context.Context, cmd Command, args string, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient) {
+	logger := s.logger.With("screen_name", msgSNAC.ScreenName, "command", cmd.Name)
+
+	reply := func(text string) error {
+		return sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, text, s.config)
+	}
+
+	response, err := cmd.Handler(ctx, CommandCall{
+		ScreenName: msgSNAC.ScreenName,
+		Args:       args,
+		Reply:      reply,
+	})
+	if err != nil {
+		logger.Error("command failed", "err", err.Error())
+		response = "Sorry, something went wrong with that command. Try again later!"
+	}
+	if response == "" {
+		return
+	}
+	if err := reply(response); err != nil {
+		logger.Error("unable to send command response", "err", err.Error())
+		return
+	}
+
+	logger.Info("command exchange", "args", args, "outgoing", response)
+}
+
+func (s *SessionManager) helpCommand(_ context.Context, call CommandCall) (string, error) {
+	return s.commands.Help(call.ScreenName), nil
+}
+
+func (s *SessionManager) resetCommand(_ context.Context, call CommandCall) (string, error) {
+	if err := s.conversations.Reset(call.ScreenName); err != nil {
+		return "", fmt.Errorf("unable to reset conversation history: %w", err)
+	}
+	return "Done! I've forgotten everything we talked about.", nil
+}
+
+func (s *SessionManager) personaCommand(_ context.Context, call CommandCall) (string, error) {
+	switch {
+	case call.Args == "":
+		if persona := s.personas.get(call.ScreenName); persona != "" {
+			return fmt.Sprintf("I'm currently pretending to be: %s", html.EscapeString(persona)), nil
+		}
+		return "I'm just being myself. Try /persona a grumpy pirate", nil
+	case strings.EqualFold(call.Args, "off"):
+		s.personas.set(call.ScreenName, "")
+		return "OK, I'm back to being myself.", nil
+	case len(call.Args) > maxPersonaLen:
+		return "That persona is too complicated for me! Keep it short.", nil
+	default:
+		s.personas.set(call.ScreenName, call.Args)
+		return fmt.Sprintf("OK, from now on I'm %s.", html.EscapeString(call.Args)), nil
+	}
+}
+
+func (s *SessionManager) weatherCommand(ctx context.Context, call CommandCall) (string, error) {
+	if call.Args == "" {
+		return "Where? Try /weather New York", nil
+	}
+	if s.config.WeatherURL == "" {
+		return "Sorry, I don't have a weather service set up.", nil
+	}
+
+	u := fmt.Sprintf(s.config.WeatherURL, url.PathEscape(call.Args))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to create weather request: %w", err)
+	}
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to reach weather service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("I couldn't find the weather for %s.", html.EscapeString(call.Args)), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("weather service returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWeatherReplyLen))
+	if err != nil {
+		return "", fmt.Errorf("unable to read weather response: %w", err)
+	}
+	return html.EscapeString(stripHTMLTags(strings.TrimSpace(string(body)))), nil
+}
+
+func (s *SessionManager) remindCommand(_ context.Context, call CommandCall) (string, error) {
+	durationArg, text, _ := strings.Cut(call.Args, " ")
+	text = strings.TrimSpace(text)
+	delay, err := time.ParseDuration(durationArg)
+	if err != nil || text == "" {
+		return "Try something like /remind 10m take out the trash", nil
+	}
+	if delay <= 0 || delay > maxReminderDelay {
+		return fmt.Sprintf("I can only remind you of things up to %s from now.", maxReminderDelay), nil
+	}
+
+	switch err := s.ScheduleReminder(call.ScreenName, delay, text); {
+	case errors.Is(err, ErrTooManyReminders):
+		return fmt.Sprintf("You already have %d reminders waiting. Try again once one of them goes off.", maxRemindersPerUser), nil
+	case err != nil:
+		return "", err
+	}
+	return fmt.Sprintf("OK, I'll remind you in %s.", delay), nil
+}
+
+// ScheduleReminder IMs text to screenName after delay. Reminders are kept in
+// memory and are lost if the bot restarts. Each user may have up to
+// maxRemindersPerUser reminders pending.
+func (s *SessionManager) ScheduleReminder(screenName string, delay time.Duration, text string) error {
+	if !s.reminders.add(screenName, maxRemindersPerUser) {
+		return ErrTooManyReminders
+	}
+	logger := s.logger.With("screen_name", screenName)
+	time.AfterFunc(delay, func() {
+		defer s.reminders.done(screenName)
+		// give up on the reminder if the bot stays signed off for too long
+		ctx, cancel := context.WithTimeout(s.lifetime, reminderSendTimeout)
+		defer cancel()
+		msg := "Reminder: " + html.EscapeString(text)
+		if err := sendMessageSNAC(ctx, s.msgCh, rand.Uint64(), screenName, msg, s.config); err != nil {
+			logger.Error("dropped reminder", "text", text, "err", err.Error())
+			return
+		}
+		logger.Info("sent reminder", "text", text)
+	})
+	return nil
+}
+
+// reminderSet counts the reminders each user has pending.
+type reminderSet struct {
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+// add counts a new reminder for screenName. It returns false if screenName
+// already has limit reminders pending.
+func (r *reminderSet) add(screenName string, limit int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[string]int)
+	}
+	key := store.NormalizeScreenName(screenName)
+	if r.pending[key] >= limit {
+		return false
+	}
+	r.pending[key]++
+	return true
+}
+
+// done stops counting one of screenName's reminders.
+func (r *reminderSet) done(screenName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := store.NormalizeScreenName(screenName)
+	if r.pending[key] <= 1 {
+		delete(r.pending, key)
+		return
+	}
+	r.pending[key]--
+}
+
+// personaSet holds the persona each user has asked the bot to adopt.
+type personaSet struct {
+	mu       sync.RWMutex
+	personas map[string]string
+}
+
+func (p *personaSet) get(screenName string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.personas[store.NormalizeScreenName(screenName)]
+}
+
+func (p *personaSet) set(screenName string, persona string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.personas == nil {
+		p.personas = make(map[string]string)
+	}
+	if persona == "" {
+		delete(p.personas, store.NormalizeScreenName(screenName))
+		return
+	}
+	p.personas[store.NormalizeScreenName(screenName)] = persona
+}
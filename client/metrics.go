@@ -0,0 +1,54 @@
+package client
+
+import "github.com/mk6i/smarter-smarter-child/metrics"
+
+// Reasons an incoming message is turned away without consulting the bot.
+const (
+	rejectRateLimit  = "rate_limit"
+	rejectSizeLimit  = "size_limit"
+	rejectBudget     = "budget"
+	rejectBanned     = "banned"
+	rejectNotAllowed = "not_allowed"
+	rejectQueueFull  = "queue_full"
+)
+
+var (
+	imsReceived = metrics.NewCounter("ssc_ims_received_total",
+		"IMs received from users.")
+	imsSent = metrics.NewCounter("ssc_ims_sent_total",
+		"IMs sent by the bot. A reply split into several parts counts once per part.")
+	chatRoomMessagesReceived = metrics.NewCounter("ssc_chat_room_messages_received_total",
+		"Chat room messages addressed to the bot.")
+	messagesRejected = metrics.NewCounter("ssc_messages_rejected_total",
+		"Messages that the bot refused to answer, by reason.", "channel", "reason")
+	warningsReceived = metrics.NewCounter("ssc_warnings_received_total",
+		"Warnings received from users, including anonymous warnings.")
+	warningsSent = metrics.NewCounter("ssc_warnings_sent_total",
+		"Warnings sent by the bot in retaliation.")
+	tokensConsumed = metrics.NewCounter("ssc_tokens_total",
+		"AI model tokens consumed, by type.", "type")
+	costConsumed = metrics.NewCounter("ssc_cost_dollars_total",
+		"Price of the AI model tokens consumed in US dollars, according to MODEL_PRICES.")
+)
+
+// registerMetrics exposes the session's connection and queue state as
+// metrics.
+func (s *SessionManager) registerMetrics() {
+	metrics.NewGaugeFunc("ssc_chat_contexts", "Users that have sent the bot an IM since it started.", func() float64 {
+		s.chatContextsMu.RLock()
+		defer s.chatContextsMu.RUnlock()
+		return float64(len(s.chatContexts))
+	})
+	metrics.NewGaugeFunc("ssc_snac_queue_depth", "SNACs waiting to be sent to the OSCAR server.", func() float64 {
+		return float64(len(s.msgCh))
+	})
+	metrics.NewCounterFunc("ssc_reconnects_total", "Times the bot reconnected to the OSCAR server.", func() float64 {
+		return float64(s.Reconnects())
+	})
+	metrics.NewGaugeFunc("ssc_online", "Whether the bot is signed on to the OSCAR server.", func() float64 {
+		if s.State() == StateOnline {
+			return 1
+		}
+		return 0
+	})
+}
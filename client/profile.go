@@ -0,0 +1,331 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+)
+
+// maxProfileLen is the largest profile the bot publishes. A template that
+// renders anything longer is considered broken.
+const maxProfileLen = 4096
+
+// profileTags are the HTML tags allowed in the profile, along with the
+// attributes allowed on each. These are the tags that AIM clients render.
+var profileTags = map[string][]string{
+	"a":     {"href"},
+	"b":     nil,
+	"big":   nil,
+	"body":  {"bgcolor"},
+	"br":    nil,
+	"font":  {"face", "color", "size", "back", "lang"},
+	"hr":    nil,
+	"html":  nil,
+	"i":     nil,
+	"p":     nil,
+	"s":     nil,
+	"small": nil,
+	"sub":   nil,
+	"sup":   nil,
+	"u":     nil,
+}
+
+var (
+	// profileTagRegex matches an HTML tag, capturing the closing slash, tag
+	// name and attributes.
+	profileTagRegex = regexp.MustCompile(`<(/?)([a-zA-Z]+)([^<>]*)>`)
+	// profileAttrRegex matches an HTML attribute, capturing its name and
+	// value, which may be quoted.
+	profileAttrRegex = regexp.MustCompile(`([a-zA-Z]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	// profileScriptRegex matches script and style elements, which are
+	// removed along with their contents.
+	profileScriptRegex = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+)
+
+// profileData is what the profile template can show.
+type profileData struct {
+	// ScreenName is the bot's screen name.
+	ScreenName string
+	// Persona is the name the bot goes by, PERSONA_NAME.
+	Persona string
+	// Model is the AI model that wrote the bot's latest reply.
+	Model string
+	// Uptime is how long the bot has been running, e.g. "3 days, 4 hours".
+	Uptime string
+	// ConversationsToday is the number of users who IMed the bot today.
+	ConversationsToday int
+	// Conversations is the number of users who IMed the bot since it
+	// started.
+	Conversations int
+	// BuddiesOnline is the number of the bot's buddies who are signed on.
+	BuddiesOnline int
+	// Fact is the fact of the day.
+	Fact string
+	// Now is when the profile was rendered.
+	Now time.Time
+}
+
+// newProfileTemplate parses PROFILE_HTML as a text/template. If it doesn't
+// parse, the profile is published as is.
+func (s *SessionManager) newProfileTemplate() *template.Template {
+	tmpl, err := template.New("profile").Option("missingkey=error").Parse(s.config.ProfileHTML)
+	if err != nil {
+		s.logger.Error("unable to parse profile template, publishing it as is", "err", err.Error())
+		return nil
+	}
+	return tmpl
+}
+
+// renderProfile returns the bot's profile as of time now. If the template
+// fails, the last good profile is returned instead.
+func (s *SessionManager) renderProfile(now time.Time) string {
+	s.mu.Lock()
+	fallback := s.lastProfile
+	s.mu.Unlock()
+	if fallback == "" {
+		fallback = sanitizeProfile(s.config.ProfileHTML)
+	}
+	if s.profile == nil {
+		return fallback
+	}
+
+	var sb strings.Builder
+	if err := s.profile.Execute(&sb, s.profileData(now)); err != nil {
+		s.logger.Error("unable to render profile", "err", err.Error())
+		return fallback
+	}
+	if sb.Len() > maxProfileLen {
+		s.logger.Error("rendered profile is too long", "len", sb.Len(), "max", maxProfileLen)
+		return fallback
+	}
+	profile := sanitizeProfile(sb.String())
+
+	s.mu.Lock()
+	s.lastProfile = profile
+	s.mu.Unlock()
+	return profile
+}
+
+// profileData gathers the values shown in the profile as of time now.
+// Strings are HTML-escaped.
+func (s *SessionManager) profileData(now time.Time) profileData {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	data := profileData{
+		ScreenName:    html.EscapeString(s.config.ScreenName),
+		Persona:       html.EscapeString(s.config.PersonaName),
+		Model:         html.EscapeString(s.Model()),
+		Uptime:        formatUptime(now.Sub(s.started)),
+		BuddiesOnline: len(s.OnlineBuddies()),
+		Fact:          html.EscapeString(factOfTheDay(now)),
+		Now:           now,
+	}
+
+	s.chatContextsMu.RLock()
+	data.Conversations = len(s.chatContexts)
+	for _, chatCtx := range s.chatContexts {
+		if !chatCtx.lastActive.Before(today) {
+			data.ConversationsToday++
+		}
+	}
+	s.chatContextsMu.RUnlock()
+	return data
+}
+
+// Model returns the AI model that wrote the bot's latest reply, or MODEL if
+// the bot hasn't replied yet.
+func (s *SessionManager) Model() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastModel != "" {
+		return s.lastModel
+	}
+	return s.config.Model
+}
+
+// setModel records the AI model that wrote the bot's latest reply.
+func (s *SessionManager) setModel(model string) {
+	if model == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastModel = model
+}
+
+// refreshProfile re-publishes the bot's profile every
+// PROFILE_REFRESH_INTERVAL until ctx is done, whenever it has changed since
+// it was last published.
+func (s *SessionManager) refreshProfile(ctx context.Context, published string) {
+	if s.profile == nil || s.config.ProfileRefresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.config.ProfileRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		profile := s.renderProfile(time.Now())
+		if profile == published {
+			continue
+		}
+		select {
+		case s.msgCh <- newProfileSNAC(profile):
+		case <-ctx.Done():
+			return
+		}
+		published = profile
+		s.logger.Debug("re-published profile")
+	}
+}
+
+// newProfileSNAC sets the bot's profile.
+func newProfileSNAC(profile string) wire.SNACMessage {
+	return wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Locate,
+			SubGroup:  wire.LocateSetInfo,
+		},
+		Body: wire.SNAC_0x02_0x04_LocateSetInfo{
+			TLVRestBlock: wire.TLVRestBlock{
+				TLVList: wire.TLVList{
+					wire.NewTLV(wire.LocateTLVTagsInfoSigMime, `text/aolrtf; charset="us-ascii"`),
+					wire.NewTLV(wire.LocateTLVTagsInfoSigData, profile),
+				},
+			},
+		},
+	}
+}
+
+// sanitizeProfile removes the tags and attributes from profile that AIM
+// clients don't render, along with links that aren't web links, and escapes
+// stray angle brackets.
+func sanitizeProfile(profile string) string {
+	profile = profileScriptRegex.ReplaceAllString(profile, "")
+	var sb strings.Builder
+	for {
+		loc := profileTagRegex.FindStringSubmatchIndex(profile)
+		if loc == nil {
+			sb.WriteString(escapeAngleBrackets(profile))
+			return sb.String()
+		}
+		sb.WriteString(escapeAngleBrackets(profile[:loc[0]]))
+		closing := profile[loc[2]:loc[3]] == "/"
+		name := strings.ToLower(profile[loc[4]:loc[5]])
+		attrs := profile[loc[6]:loc[7]]
+		profile = profile[loc[1]:]
+
+		allowed, ok := profileTags[name]
+		if !ok {
+			continue // drop the tag, keep what's inside it
+		}
+		sb.WriteString("<")
+		if closing {
+			sb.WriteString("/")
+		}
+		sb.WriteString(strings.ToUpper(name))
+		if !closing {
+			for _, attr := range profileAttrRegex.FindAllStringSubmatch(attrs, -1) {
+				attrName := strings.ToLower(attr[1])
+				value := strings.Trim(attr[2], `"'`)
+				if !containsString(allowed, attrName) {
+					continue
+				}
+				if attrName == "href" && !isWebLink(value) {
+					continue
+				}
+				fmt.Fprintf(&sb, ` %s="%s"`, strings.ToUpper(attrName), strings.ReplaceAll(value, `"`, "&quot;"))
+			}
+		}
+		sb.WriteString(">")
+	}
+}
+
+// escapeAngleBrackets escapes the angle brackets in text that are not part of
+// a tag.
+func escapeAngleBrackets(text string) string {
+	return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// isWebLink reports whether link is an http, https or aim link.
+func isWebLink(link string) bool {
+	link = strings.ToLower(strings.TrimSpace(link))
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "aim:")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// formatUptime formats d in days, hours and minutes, e.g. "3 days, 4 hours".
+func formatUptime(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case days > 0:
+		return plural(days, "day") + ", " + plural(hours, "hour")
+	case hours > 0:
+		return plural(hours, "hour") + ", " + plural(minutes, "minute")
+	default:
+		return plural(minutes, "minute")
+	}
+}
+
+// facts are shown in the profile, one per day.
+var facts = []string{
+	"Honey never spoils. Edible honey has been found in ancient Egyptian tombs.",
+	"Octopuses have three hearts and blue blood.",
+	"A day on Venus is longer than a year on Venus.",
+	"Bananas are berries, but strawberries are not.",
+	"The Eiffel Tower grows about 6 inches taller in the summer heat.",
+	"Wombat poop is cube-shaped.",
+	"There are more possible games of chess than atoms in the observable universe.",
+	"Sea otters hold hands while they sleep so they don't drift apart.",
+	"The first computer bug was a real moth found in a relay in 1947.",
+	"A group of flamingos is called a flamboyance.",
+	"Sharks existed before trees.",
+	"The shortest war in history lasted 38 minutes.",
+	"Hot water can freeze faster than cold water. It's called the Mpemba effect.",
+	"Cows have best friends and get stressed when they're apart.",
+	"The inventor of the Pringles can was buried in one.",
+	"Scotland's national animal is the unicorn.",
+	"A bolt of lightning is about five times hotter than the surface of the sun.",
+	"Koalas have fingerprints that are nearly identical to humans'.",
+	"The dot over a lowercase i or j is called a tittle.",
+	"Butterflies taste with their feet.",
+	"An ostrich's eye is bigger than its brain.",
+	"The original name of Google was BackRub.",
+	"Saturn would float if you could find a bathtub big enough.",
+	"Some turtles can breathe through their butts.",
+	"The heart of a blue whale is about the size of a small car.",
+	"Peanuts aren't nuts. They're legumes.",
+	"A jiffy is an actual unit of time: 1/100th of a second.",
+	"Snails can sleep for up to three years.",
+	"Humans share about 60% of their DNA with bananas.",
+	"The first email was sent in 1971.",
+}
+
+// factOfTheDay returns the fact to show in the profile on the day of t.
+func factOfTheDay(t time.Time) string {
+	return facts[t.YearDay()%len(facts)]
+}
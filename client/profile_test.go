@@ -0,0 +1,109 @@
+package client
+
+import "testing"
+
+func TestSanitizeProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    string
+	}{
+		{
+			name:    "allowed tags are kept and upper-cased",
+			profile: `<b>bold</b> <font color="red" face='Arial'>red</font>`,
+			want:    `<B>bold</B> <FONT COLOR="red" FACE="Arial">red</FONT>`,
+		},
+		{
+			name:    "script element is removed with its contents",
+			profile: `<b>hi</b><script>alert(1)</script>there`,
+			want:    `<B>hi</B>there`,
+		},
+		{
+			name:    "style element is removed with its contents",
+			profile: `<STYLE type="text/css">body{color:red}</STYLE>ok`,
+			want:    `ok`,
+		},
+		{
+			name:    "unclosed script tag is dropped",
+			profile: `<script>alert(1)`,
+			want:    `alert(1)`,
+		},
+		{
+			name:    "script tag split around another script element",
+			profile: `<scr<script>x</script>ipt>alert(1)</script>`,
+			want:    `alert(1)`,
+		},
+		{
+			name:    "event handler attributes are stripped",
+			profile: `<font color="red" onmouseover="alert(1)">x</font>`,
+			want:    `<FONT COLOR="red">x</FONT>`,
+		},
+		{
+			name:    "unquoted event handler attribute is stripped",
+			profile: `<a href="https://example.com/" onclick=alert(1)>link</a>`,
+			want:    `<A HREF="https://example.com/">link</A>`,
+		},
+		{
+			name:    "javascript href is stripped",
+			profile: `<a href="javascript:alert(1)">click</a>`,
+			want:    `<A>click</A>`,
+		},
+		{
+			name:    "javascript href with mixed case and padding is stripped",
+			profile: `<a href=" JavaScript:alert(1)">click</a>`,
+			want:    `<A>click</A>`,
+		},
+		{
+			name:    "aim href is kept",
+			profile: `<a href="aim:goim?screenname=bot">im</a>`,
+			want:    `<A HREF="aim:goim?screenname=bot">im</A>`,
+		},
+		{
+			name:    "quotes in attribute values are escaped",
+			profile: `<a href='http://x"onclick="alert(1)'>q</a>`,
+			want:    `<A HREF="http://x&quot;onclick=&quot;alert(1)">q</A>`,
+		},
+		{
+			name:    "disallowed tags are dropped but their text is kept",
+			profile: `<div><img src=x onerror=alert(1)>text</div>`,
+			want:    `text`,
+		},
+		{
+			name:    "nested tags",
+			profile: `<b><i><u>deep</u></i></b>`,
+			want:    `<B><I><U>deep</U></I></B>`,
+		},
+		{
+			name:    "unclosed element",
+			profile: `<b>unclosed`,
+			want:    `<B>unclosed`,
+		},
+		{
+			name:    "unterminated tag is escaped",
+			profile: `<b>broken <i`,
+			want:    `<B>broken &lt;i`,
+		},
+		{
+			name:    "stray angle brackets are escaped",
+			profile: `1 < 2 > 0`,
+			want:    `1 &lt; 2 &gt; 0`,
+		},
+		{
+			name:    "entities pass through",
+			profile: `&lt;b&gt; &amp; &quot;hi&quot; &#169;`,
+			want:    `&lt;b&gt; &amp; &quot;hi&quot; &#169;`,
+		},
+		{
+			name:    "entities in attribute values pass through",
+			profile: `<a href="https://example.com/?a=1&amp;b=2">link</a>`,
+			want:    `<A HREF="https://example.com/?a=1&amp;b=2">link</A>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeProfile(tt.profile); got != tt.want {
+				t.Errorf("sanitizeProfile(%q) = %q, want %q", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
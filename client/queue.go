@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// queueMessage holds on to an IM that arrived while the bot was busy with the
+// user's previous one, so that it's answered in the bot's next reply. Up to
+// MAX_QUEUED_MSGS messages are held; the rest are dropped. Commands are run
+// right away.
+func (s *SessionManager) queueMessage(ctx context.Context, chatCtx *chatContext, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient, receivedAt time.Time) error {
+	logger := s.logger.With("screen_name", msgSNAC.ScreenName)
+
+	msgText, hasIMData, err := imText(msgSNAC)
+	if err != nil || !hasIMData {
+		return err
+	}
+
+	// Conversations that are taken over don't need to wait for the bot.
+	if s.relayToOperator(ctx, msgSNAC.ScreenName, msgText) {
+		return nil
+	}
+	isAdmin := s.admins.contains(msgSNAC.ScreenName)
+	if user, ok := s.takeoverOf(msgSNAC.ScreenName); isAdmin && ok {
+		if _, _, isCommand := s.adminCommands.Match(msgSNAC.ScreenName, msgText); !isCommand {
+			go func() {
+				if err := s.TakeoverReply(ctx, user, msgText); err != nil {
+					logger.Error("unable to send operator reply", "err", err.Error())
+					s.sendFailureReply(ctx, msgSNAC.Cookie, msgSNAC.ScreenName, err)
+				}
+			}()
+			return nil
+		}
+	}
+
+	if hitRateLimit := !isAdmin && enforceRateLimit(ctx, logger, s.msgCh, chatCtx, msgSNAC, s.config); hitRateLimit {
+		logger.Info("user hit message rate limit")
+		messagesRejected.Inc(string(bot.ChannelIM), rejectRateLimit)
+		return nil
+	}
+
+	// Commands don't consult the chat bot, so there's no need to wait for
+	// its reply.
+	if cmd, args, isCommand := s.adminCommands.Match(msgSNAC.ScreenName, msgText); isAdmin && isCommand {
+		go s.runCommand(ctx, cmd, args, msgSNAC)
+		return nil
+	}
+	if cmd, args, isCommand := s.commands.Match(msgSNAC.ScreenName, msgText); isCommand {
+		go s.runCommand(ctx, cmd, args, msgSNAC)
+		return nil
+	}
+	if hitMsgSizeLimit := enforceMsgSizeLimit(ctx, logger, msgText, s.msgCh, msgSNAC, s.config); hitMsgSizeLimit {
+		logger.Info("user hit message size limit")
+		messagesRejected.Inc(string(bot.ChannelIM), rejectSizeLimit)
+		return nil
+	}
+
+	idle, queued := chatCtx.enqueue(msgText, s.config.MaxQueuedMsgs)
+	switch {
+	case idle:
+		// the bot finished its previous reply in the meantime
+		if reply, exhausted := s.checkBudget(msgSNAC.ScreenName, receivedAt); exhausted {
+			chatCtx.releaseLock()
+			logger.Info("user hit usage budget")
+			messagesRejected.Inc(string(bot.ChannelIM), rejectBudget)
+			return sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, reply, s.config)
+		}
+		go s.converse(ctx, chatCtx, msgSNAC, msgText, receivedAt)
+	case !queued:
+		logger.Info("too many messages queued for user, drop message")
+		messagesRejected.Inc(string(bot.ChannelIM), rejectQueueFull)
+	default:
+		logger.Debug("currently responding to user, queued message", "incoming", msgText)
+	}
+	return nil
+}
+
+// answerQueued replies to the messages the user sent while the bot was busy
+// with their previous one, until there are none left. Then it releases
+// chatCtx.
+func (s *SessionManager) answerQueued(ctx context.Context, chatCtx *chatContext, msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient) {
+	logger := s.logger.With("screen_name", msgSNAC.ScreenName)
+	for {
+		queued := chatCtx.takeQueuedOrRelease()
+		if len(queued) == 0 {
+			return
+		}
+		receivedAt := time.Now()
+
+		if ctx.Err() != nil {
+			// the connection is gone, so there's no one to reply to
+			continue
+		}
+		if s.takenOver(msgSNAC.ScreenName) {
+			for _, msgText := range queued {
+				s.relayToOperator(ctx, msgSNAC.ScreenName, msgText)
+			}
+			continue
+		}
+		if reply, exhausted := s.checkBudget(msgSNAC.ScreenName, receivedAt); exhausted {
+			logger.Info("user hit usage budget")
+			messagesRejected.Inc(string(bot.ChannelIM), rejectBudget)
+			if err := sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, reply, s.config); err != nil {
+				logger.Error("unable to send budget reply", "err", err.Error())
+			}
+			continue
+		}
+
+		s.reply(ctx, chatCtx, msgSNAC, strings.Join(queued, "\n"), receivedAt)
+	}
+}
+
+// enqueue queues text to be answered once the bot is done with the user's
+// previous message. It holds up to limit messages; queued is false if text
+// didn't fit. If the bot finished with the previous message in the meantime,
+// enqueue locks chatCtx instead and returns idle, and the caller must reply to
+// text itself.
+func (c *chatContext) enqueue(text string, limit int) (idle, queued bool) {
+	c.mu.Lock()
+	switch {
+	case c.tryLock():
+		c.mu.Unlock()
+		return true, false
+	case len(c.queued) >= limit:
+		c.mu.Unlock()
+		return false, false
+	}
+	c.queued = append(c.queued, text)
+	c.mu.Unlock()
+	return false, true
+}
+
+// takeQueued removes and returns the queued messages.
+func (c *chatContext) takeQueued() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := c.queued
+	c.queued = nil
+	return queued
+}
+
+// takeQueuedOrRelease removes and returns the queued messages. If there are
+// none, it releases chatCtx, so that the next message is answered right away
+// instead of being queued.
+func (c *chatContext) takeQueuedOrRelease() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := c.queued
+	c.queued = nil
+	if len(queued) == 0 {
+		c.releaseLock()
+	}
+	return queued
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"slices"
+	"testing"
+)
+
+func newTestChatContext() *chatContext {
+	return &chatContext{
+		semaphore: make(chan struct{}, 1),
+		heard:     make(chan struct{}, 1),
+	}
+}
+
+func TestChatContextEnqueue(t *testing.T) {
+	chatCtx := newTestChatContext()
+
+	// the first message locks the idle chat context
+	if idle, queued := chatCtx.enqueue("one", 2); !idle || queued {
+		t.Fatalf("enqueue on idle context = %v, %v; want true, false", idle, queued)
+	}
+	if chatCtx.tryLock() {
+		t.Fatal("chat context isn't locked after enqueue reported idle")
+	}
+
+	// messages sent while the bot is busy are queued up to the limit
+	for _, text := range []string{"two", "three"} {
+		if idle, queued := chatCtx.enqueue(text, 2); idle || !queued {
+			t.Fatalf("enqueue(%q) on busy context = %v, %v; want false, true", text, idle, queued)
+		}
+	}
+	if idle, queued := chatCtx.enqueue("four", 2); idle || queued {
+		t.Fatalf("enqueue on full queue = %v, %v; want false, false", idle, queued)
+	}
+
+	// queued messages are taken in the order they were sent, without
+	// unlocking the chat context
+	if got, want := chatCtx.takeQueuedOrRelease(), []string{"two", "three"}; !slices.Equal(got, want) {
+		t.Fatalf("takeQueuedOrRelease() = %q, want %q", got, want)
+	}
+	if chatCtx.tryLock() {
+		t.Fatal("chat context was unlocked while messages were taken")
+	}
+
+	// once the queue is empty the chat context is unlocked
+	if got := chatCtx.takeQueuedOrRelease(); len(got) != 0 {
+		t.Fatalf("takeQueuedOrRelease() = %q, want none", got)
+	}
+	if idle, queued := chatCtx.enqueue("five", 2); !idle || queued {
+		t.Fatalf("enqueue after release = %v, %v; want true, false", idle, queued)
+	}
+}
+
+func TestChatContextTakeQueued(t *testing.T) {
+	chatCtx := newTestChatContext()
+	if !chatCtx.tryLock() {
+		t.Fatal("unable to lock chat context")
+	}
+	chatCtx.enqueue("one", 5)
+	chatCtx.enqueue("two", 5)
+
+	if got, want := chatCtx.takeQueued(), []string{"one", "two"}; !slices.Equal(got, want) {
+		t.Fatalf("takeQueued() = %q, want %q", got, want)
+	}
+	if got := chatCtx.takeQueued(); len(got) != 0 {
+		t.Fatalf("takeQueued() = %q, want none", got)
+	}
+	// takeQueued never unlocks the chat context
+	if chatCtx.tryLock() {
+		t.Fatal("chat context was unlocked by takeQueued")
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// partLabelReserve is the room reserved at the end of each message segment
+// for its " (n/m)" part label.
+const partLabelReserve = len(" (99/99)")
+
+// segmentMessage splits text into parts that fit in an IM once wrapped in
+// msgFormat. maxLen is the maximum size of the wrapped IM in bytes. When
+// text needs to be split, each part is labeled with its position, e.g.
+// "(1/3)". Text is split at sentence boundaries where possible, then at word
+// boundaries, and only cut mid-word as a last resort.
+func segmentMessage(text string, msgFormat string, maxLen int) []string {
+	overhead := len(msgFormat) - len("@MsgContent@")
+	budget := maxLen - overhead
+	if maxLen <= 0 || len(text) <= budget {
+		return []string{text}
+	}
+
+	budget -= partLabelReserve
+	if budget <= 0 {
+		// the message format leaves no room for content, send as-is and let
+		// the server decide
+		return []string{text}
+	}
+
+	var parts []string
+	rest := strings.TrimSpace(text)
+	for len(rest) > budget {
+		cut := splitPoint(rest, budget)
+		parts = append(parts, strings.TrimSpace(rest[:cut]))
+		rest = strings.TrimSpace(rest[cut:])
+	}
+	if rest != "" {
+		parts = append(parts, rest)
+	}
+
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%s (%d/%d)", parts[i], i+1, len(parts))
+	}
+	return parts
+}
+
+// splitPoint returns the offset at which to split s so that the first part
+// is no longer than limit.
+func splitPoint(s string, limit int) int {
+	window := s[:limit+1]
+
+	// prefer the last sentence ending in the window, as long as it doesn't
+	// leave a tiny first part
+	if end := lastSentenceEnd(window); end > limit/2 {
+		return end
+	}
+
+	// otherwise break between words
+	if i := strings.LastIndexFunc(window, unicode.IsSpace); i > 0 {
+		return i
+	}
+
+	// no whitespace at all, cut mid-word without splitting a UTF-8 sequence
+	cut := limit
+	for cut > 0 && !isRuneStart(s[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		return limit
+	}
+	return cut
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegmentMessage(t *testing.T) {
+	const htmlFormat = "<HTML><BODY>@MsgContent@</BODY></HTML>"
+	tests := []struct {
+		name      string
+		text      string
+		msgFormat string
+		maxLen    int
+		want      []string
+	}{
+		{
+			name:      "fits in one message",
+			text:      "hi2u",
+			msgFormat: htmlFormat,
+			maxLen:    100,
+			want:      []string{"hi2u"},
+		},
+		{
+			name:      "no limit",
+			text:      strings.Repeat("a", 1000),
+			msgFormat: htmlFormat,
+			maxLen:    0,
+			want:      []string{strings.Repeat("a", 1000)},
+		},
+		{
+			name:      "fits without the HTML wrapper",
+			text:      "the quick brown fox jumps",
+			msgFormat: "@MsgContent@",
+			maxLen:    30,
+			want:      []string{"the quick brown fox jumps"},
+		},
+		{
+			name:      "split because of the HTML wrapper",
+			text:      "the quick brown fox jumps",
+			msgFormat: "<B>@MsgContent@</B>",
+			maxLen:    30,
+			want:      []string{"the quick brown (1/2)", "fox jumps (2/2)"},
+		},
+		{
+			name:      "split at sentence boundaries",
+			text:      "Hello there friend. How are you doing today? I am fine.",
+			msgFormat: "@MsgContent@",
+			maxLen:    33,
+			want:      []string{"Hello there friend. (1/3)", "How are you doing today? (2/3)", "I am fine. (3/3)"},
+		},
+		{
+			name:      "cut mid-word without whitespace",
+			text:      strings.Repeat("x", 20),
+			msgFormat: "@MsgContent@",
+			maxLen:    18,
+			want:      []string{"xxxxxxxxxx (1/2)", "xxxxxxxxxx (2/2)"},
+		},
+		{
+			name:      "no room for content",
+			text:      "the quick brown fox jumps",
+			msgFormat: htmlFormat,
+			maxLen:    20,
+			want:      []string{"the quick brown fox jumps"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := segmentMessage(tt.text, tt.msgFormat, tt.maxLen)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("segmentMessage() = %q, want %q", got, tt.want)
+			}
+			if len(got) == 1 {
+				return
+			}
+			for _, part := range got {
+				if wrapped := strings.Replace(tt.msgFormat, "@MsgContent@", part, 1); len(wrapped) > tt.maxLen {
+					t.Errorf("wrapped part %q is %d bytes, want at most %d", wrapped, len(wrapped), tt.maxLen)
+				}
+			}
+		})
+	}
+}
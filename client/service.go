@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mk6i/retro-aim-server/wire"
+)
+
+// snacResponse is a SNAC received from the server in reply to a request.
+type snacResponse struct {
+	frame wire.SNACFrame
+	body  *bytes.Buffer
+}
+
+// requestTracker matches SNACs received from the server with the requests
+// that solicited them using the SNAC request ID.
+type requestTracker struct {
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan snacResponse
+}
+
+// register reserves a request ID and returns a channel that receives the
+// server's reply. The caller must call cancel once it stops waiting.
+func (t *requestTracker) register() (uint32, <-chan snacResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[uint32]chan snacResponse)
+	}
+	t.nextID++
+	if t.nextID == 0 {
+		t.nextID++ // 0 is used by unsolicited SNACs
+	}
+	ch := make(chan snacResponse, 1)
+	t.pending[t.nextID] = ch
+	return t.nextID, ch
+}
+
+// cancel stops tracking request id.
+func (t *requestTracker) cancel(id uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pending, id)
+}
+
+// deliver hands a SNAC to the request that's waiting for it. It returns false
+// if no request is waiting for the SNAC.
+func (t *requestTracker) deliver(frame wire.SNACFrame, body *bytes.Buffer) bool {
+	if frame.RequestID == 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch, ok := t.pending[frame.RequestID]
+	if !ok {
+		return false
+	}
+	delete(t.pending, frame.RequestID)
+	ch <- snacResponse{frame: frame, body: body}
+	return true
+}
+
+// sendRequest sends req to the BOS server and waits for the reply. The
+// request ID of req is filled in by sendRequest.
+func (s *SessionManager) sendRequest(ctx context.Context, req wire.SNACMessage) (snacResponse, error) {
+	requestID, respCh := s.requests.register()
+	defer s.requests.cancel(requestID)
+
+	req.Frame.RequestID = requestID
+	select {
+	case s.msgCh <- req:
+	case <-ctx.Done():
+		return snacResponse{}, ctx.Err()
+	}
+
+	select {
+	case resp := <-respCh:
+		return resp, nil
+	case <-ctx.Done():
+		return snacResponse{}, ctx.Err()
+	}
+}
+
+// requestService asks the BOS server where to connect for food group
+// foodGroup. It returns the service host and the login cookie to present to
+// it.
+func (s *SessionManager) requestService(ctx context.Context, foodGroup uint16, tlvs ...wire.TLV) (string, string, error) {
+	resp, err := s.sendRequest(ctx, wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.OService,
+			SubGroup:  wire.OServiceServiceRequest,
+		},
+		Body: wire.SNAC_0x01_0x04_OServiceServiceRequest{
+			FoodGroup: foodGroup,
+			TLVRestBlock: wire.TLVRestBlock{
+				TLVList: tlvs,
+			},
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if resp.frame.FoodGroup != wire.OService || resp.frame.SubGroup != wire.OServiceServiceResponse {
+		return "", "", fmt.Errorf("service request for %s failed: got %s",
+			wire.FoodGroupName(foodGroup), wire.SubGroupName(resp.frame.FoodGroup, resp.frame.SubGroup))
+	}
+
+	svcResp := wire.SNAC_0x01_0x05_OServiceServiceResponse{}
+	if err := wire.UnmarshalBE(&svcResp, resp.body); err != nil {
+		return "", "", err
+	}
+	host, hasHost := svcResp.String(wire.OServiceTLVTagsReconnectHere)
+	if !hasHost {
+		return "", "", fmt.Errorf("service response for %s does not contain a hostname TLV", wire.FoodGroupName(foodGroup))
+	}
+	cookie, hasCookie := svcResp.String(wire.OServiceTLVTagsLoginCookie)
+	if !hasCookie {
+		return "", "", fmt.Errorf("service response for %s does not contain a login cookie TLV", wire.FoodGroupName(foodGroup))
+	}
+	return host, cookie, nil
+}
+
+// signon performs the FLAP signon handshake with an OSCAR service using the
+// login cookie issued by the auth server or BOS.
+func signon(flapc FlapClient, cookie string) error {
+	if _, err := flapc.ReceiveSignonFrame(); err != nil {
+		return err
+	}
+
+	tlv := []wire.TLV{
+		wire.NewTLV(wire.OServiceTLVTagsLoginCookie, []byte(cookie)),
+	}
+	if err := flapc.SendSignonFrame(tlv); err != nil {
+		return err
+	}
+
+	hostOnlineFrame := wire.SNACFrame{}
+	hostOnlineSNAC := wire.SNAC_0x01_0x03_OServiceHostOnline{}
+	if err := flapc.ReceiveSNAC(&hostOnlineFrame, &hostOnlineSNAC); err != nil {
+		return err
+	}
+
+	clientOnlineFrame := wire.SNACFrame{
+		FoodGroup: wire.OService,
+		SubGroup:  wire.OServiceClientOnline,
+	}
+	clientOnlineSNAC := wire.SNAC_0x01_0x02_OServiceClientOnline{}
+	return flapc.SendSNAC(clientOnlineFrame, clientOnlineSNAC)
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+// ConnState describes the state of the bot's connection to the OSCAR server.
+type ConnState int32
+
+const (
+	// StateDisconnected indicates that the bot is not connected and is
+	// waiting to reconnect.
+	StateDisconnected ConnState = iota
+	// StateAuthenticating indicates that the bot is performing the BUCP login
+	// flow with the auth server.
+	StateAuthenticating
+	// StateConnecting indicates that the bot is signing on to the BOS server.
+	StateConnecting
+	// StateOnline indicates that the bot is signed on and chatting.
+	StateOnline
+	// StateStopped indicates that the session manager has shut down.
+	StateStopped
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateConnecting:
+		return "connecting"
+	case StateOnline:
+		return "online"
+	case StateStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(s))
+	}
+}
+
+// Dialer opens a network connection to an OSCAR host.
+type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+// NewSessionManager creates a SessionManager that signs on as the bot
+// configured in cfg, relays IMs to chatBot and records each exchange in
+// conversations. The AI model usage of each reply is tallied in ledger,
+// access decides who the bot talks to and subscriptions holds the users to
+// greet when they sign on.
+func NewSessionManager(logger *slog.Logger, cfg config.Config, chatBot ChatBot, conversations ConversationStore, ledger UsageLedger, access AccessList, subscriptions SubscriptionStore) *SessionManager {
+	s := &SessionManager{
+		logger:        logger,
+		config:        cfg,
+		chatBot:       chatBot,
+		conversations: conversations,
+		ledger:        ledger,
+		access:        access,
+		subscriptions: subscriptions,
+		dial:          (&net.Dialer{}).DialContext,
+		chatContexts:  make(map[string]*chatContext),
+		rooms:         make(map[string]*chatRoom),
+		msgCh:         make(chan wire.SNACMessage, 10),
+		r:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		started:       time.Now(),
+		commands:      NewCommandRouter(commandPrefix),
+		adminCommands: NewCommandRouter(cfg.AdminCommandPrefix),
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		mentions:      newMentionMatcher(cfg.ScreenName),
+	}
+	s.lifetime, s.endLifetime = context.WithCancel(context.Background())
+	s.lastActivity.Store(s.started.UnixNano())
+	s.profile = s.newProfileTemplate()
+	if maintenance, err := ParseDailyWindow(cfg.MaintenanceWindow); err != nil {
+		logger.Error("ignoring invalid maintenance window", "err", err.Error())
+	} else {
+		s.maintenance = maintenance
+	}
+	for _, admin := range cfg.AdminScreenNames {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			s.admins.add(admin)
+		}
+	}
+	s.registerBuiltinCommands()
+	s.registerAdminCommands()
+	s.registerMetrics()
+	return s
+}
+
+// SessionManager supervises the bot's OSCAR session. It authenticates with
+// the auth server, signs on to BOS and, whenever the connection drops,
+// repeats the whole flow with exponential backoff. Conversation state is kept
+// across reconnects.
+type SessionManager struct {
+	logger  *slog.Logger
+	config  config.Config
+	chatBot ChatBot
+	dial    Dialer
+	r       *rand.Rand
+
+	// conversations holds each user's conversation history, which is fed
+	// back to the bot as context.
+	conversations ConversationStore
+	// ledger tallies the AI model usage of each user so that budgets can be
+	// enforced.
+	ledger UsageLedger
+	// chatContexts keeps track of all chat contexts per screen name. It
+	// outlives individual BOS connections.
+	chatContexts   map[string]*chatContext
+	chatContextsMu sync.RWMutex
+	// access holds the allow and deny lists.
+	access AccessList
+	// feedbagMu serializes edits of the bot's feedbag (server-side buddy
+	// list), which holds its buddies and deny list.
+	feedbagMu sync.Mutex
+	// buddies holds the screen names on the bot's buddy list.
+	buddies screenNameSet
+	// presence holds the online status of the bot's buddies.
+	presence presenceTable
+	// subscriptions holds the users who want to be greeted when they sign
+	// on.
+	subscriptions SubscriptionStore
+	// userProfiles caches the profiles and away messages of the users the
+	// bot chats with.
+	userProfiles userProfileCache
+	// commands handles IMs that start with the command prefix instead of
+	// the chat bot.
+	commands *CommandRouter
+	// adminCommands handles IMs from admins that start with the admin
+	// command prefix.
+	adminCommands *CommandRouter
+	// admins holds the screen names allowed to run admin commands.
+	admins screenNameSet
+	// takeovers holds the conversations that operators have taken over from
+	// the bot.
+	takeovers takeoverSet
+	// personas holds the persona each user has asked the bot to adopt.
+	personas personaSet
+	// reminders counts each user's pending reminders.
+	reminders reminderSet
+	// httpClient is used by commands that call out to web services.
+	httpClient *http.Client
+	// requests routes BOS replies to the requests that solicited them.
+	requests requestTracker
+	// rooms holds the chat rooms the bot participates in, keyed by room
+	// cookie. Rooms are rejoined after reconnecting.
+	rooms   map[string]*chatRoom
+	roomsMu sync.Mutex
+	// mentions finds where chat room messages address the bot.
+	mentions mentionMatcher
+	// lifetime is done once Run returns. Work that outlives a single BOS
+	// connection, like reminders, is bound to it.
+	lifetime    context.Context
+	endLifetime context.CancelFunc
+	// msgCh queues client->server SNACs. IMs queued while the bot is
+	// disconnected are sent once the next connection comes online, the
+	// other SNACs are dropped.
+	msgCh chan wire.SNACMessage
+
+	state      atomic.Int32
+	reconnects atomic.Int64
+	// lastActivity is when the bot last received a message, in Unix
+	// nanoseconds. The bot goes idle once it's been quiet for IDLE_AFTER.
+	lastActivity atomic.Int64
+	// maintenance is the daily window during which the bot shows the
+	// maintenance away message.
+	maintenance DailyWindow
+	// profile renders the bot's profile. It's nil if PROFILE_HTML isn't a
+	// valid template.
+	profile *template.Template
+
+	mu             sync.Mutex
+	stateListeners []func(ConnState)
+	// dropConn ends the current connection to the OSCAR server.
+	dropConn context.CancelCauseFunc
+	// started is when the session manager was created.
+	started time.Time
+	// awayMessage is the away message shown by the bot, if any.
+	awayMessage string
+	// lastProfile is the last profile that rendered successfully.
+	lastProfile string
+	// lastModel is the AI model that wrote the bot's latest reply.
+	lastModel string
+}
+
+// errReconnectRequested ends a connection that was dropped on purpose so
+// that the bot signs back on.
+var errReconnectRequested = errors.New("reconnect requested")
+
+// Reconnect drops the connection to the OSCAR server so that the bot signs
+// back on, which republishes its profile and rejoins its chat rooms.
+func (s *SessionManager) Reconnect() {
+	s.dropConnection(errReconnectRequested)
+}
+
+// dropConnection ends the current connection to the OSCAR server because of
+// cause. The session manager then signs back on.
+func (s *SessionManager) dropConnection(cause error) {
+	s.mu.Lock()
+	dropConn := s.dropConn
+	s.mu.Unlock()
+	if dropConn != nil {
+		dropConn(cause)
+	}
+}
+
+// State returns the current connection state.
+func (s *SessionManager) State() ConnState {
+	return ConnState(s.state.Load())
+}
+
+// Reconnects returns how many times the session has been re-established
+// after the initial signon.
+func (s *SessionManager) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+// OnStateChange registers fn to be called whenever the connection state
+// changes. fn is called synchronously and must not block.
+func (s *SessionManager) OnStateChange(fn func(ConnState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stateListeners = append(s.stateListeners, fn)
+}
+
+func (s *SessionManager) setState(state ConnState) {
+	if ConnState(s.state.Swap(int32(state))) == state {
+		return
+	}
+	s.logger.Info("connection state changed", "state", state.String())
+
+	s.mu.Lock()
+	listeners := s.stateListeners
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// Run keeps the bot signed on until ctx is cancelled or the server rejects
+// the bot's credentials.
+func (s *SessionManager) Run(ctx context.Context) error {
+	defer s.setState(StateStopped)
+	defer s.endLifetime()
+	defer context.AfterFunc(ctx, s.endLifetime)()
+
+	attempt := 0
+	for {
+		wentOnline, err := s.runOnce(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, ErrInvalidCredentials):
+			return err
+		case errors.Is(err, errReconnectRequested):
+			s.logger.Info("signing back on by request")
+		case err != nil:
+			s.logger.Error("session ended with error", "err", err.Error())
+		default:
+			s.logger.Info("session ended by server")
+		}
+		s.setState(StateDisconnected)
+
+		if wentOnline {
+			// the last session was healthy, so start the backoff over
+			attempt = 0
+		}
+		delay := s.backoff(attempt)
+		attempt++
+
+		s.logger.Info("reconnecting", "attempt", attempt, "delay", delay.String())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		s.reconnects.Add(1)
+	}
+}
+
+// backoff returns the delay before reconnection attempt n. The delay grows
+// exponentially from ReconnectMinDelay up to ReconnectMaxDelay, and is
+// randomized by up to 50% so that a fleet of bots doesn't reconnect in
+// lockstep after a server restart.
+func (s *SessionManager) backoff(n int) time.Duration {
+	delay := s.config.ReconnectMinDelay
+	for i := 0; i < n && delay < s.config.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.config.ReconnectMaxDelay {
+		delay = s.config.ReconnectMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(s.r.Int63n(int64(half)+1))
+}
+
+// runOnce authenticates, signs on to BOS and chats until the connection
+// ends. It reports whether the bot made it online.
+func (s *SessionManager) runOnce(ctx context.Context) (bool, error) {
+	ctx, dropConn := context.WithCancelCause(ctx)
+	defer dropConn(nil)
+	s.mu.Lock()
+	s.dropConn = dropConn
+	s.mu.Unlock()
+
+	s.setState(StateAuthenticating)
+
+	bosHost, authCookie, err := s.authenticate(ctx)
+	if err != nil {
+		return false, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	s.setState(StateConnecting)
+
+	conn, err := s.dial(ctx, "tcp", bosHost)
+	if err != nil {
+		return false, fmt.Errorf("unable to dial into BOS host: %w", err)
+	}
+	defer conn.Close()
+
+	s.logger.Info("connected to BOS server", "host", bosHost)
+
+	// unblock pending reads when the session is shut down
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	flapc := wire.NewFlapClient(0, conn, conn)
+	var wentOnline bool
+	err = s.chat(ctx, flapc, authCookie, func() {
+		wentOnline = true
+		s.setState(StateOnline)
+	})
+	// report why the connection was dropped rather than the read error that
+	// dropping it caused
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return wentOnline, cause
+	}
+	return wentOnline, err
+}
+
+// authenticate performs the BUCP login flow and returns the BOS host and
+// auth cookie.
+func (s *SessionManager) authenticate(ctx context.Context) (string, string, error) {
+	host := net.JoinHostPort(s.config.OSCARHost, s.config.OSCARPort)
+	conn, err := s.dial(ctx, "tcp", host)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to dial into auth host: %w", err)
+	}
+	defer func() {
+		s.logger.Debug("disconnected from auth service", "host", host)
+		conn.Close()
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	s.logger.Debug("connected to auth service", "host", host)
+
+	flapc := wire.NewFlapClient(0, conn, conn)
+	bosHost, authCookie, err := Authenticate(flapc, s.config.ScreenName, s.config.Password)
+	if err == nil {
+		s.logger.Debug("authentication succeeded, proceeding to BOS host", "host", bosHost, "authCookie", authCookie)
+	}
+	return bosHost, authCookie, err
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// statusCheckInterval is how often the bot reconsiders its away message and
+// idle time.
+const statusCheckInterval = 15 * time.Second
+
+// probeTimeout is how long the bot waits for an AI model API that's down to
+// answer a probe.
+const probeTimeout = 10 * time.Second
+
+// DailyWindow is a time of day range in local time. The range wraps past
+// midnight if End is before Start.
+type DailyWindow struct {
+	// Start and End are offsets from midnight.
+	Start, End time.Duration
+}
+
+// ParseDailyWindow parses a range of the form "HH:MM-HH:MM". An empty string
+// yields the zero DailyWindow, which contains no time.
+func ParseDailyWindow(s string) (DailyWindow, error) {
+	if s == "" {
+		return DailyWindow{}, nil
+	}
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return DailyWindow{}, fmt.Errorf("invalid time window %q: expected HH:MM-HH:MM", s)
+	}
+	var w DailyWindow
+	for _, part := range []struct {
+		str string
+		dst *time.Duration
+	}{{startStr, &w.Start}, {endStr, &w.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.str))
+		if err != nil {
+			return DailyWindow{}, fmt.Errorf("invalid time window %q: %w", s, err)
+		}
+		*part.dst = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return w, nil
+}
+
+// Contains reports whether t falls within the window.
+func (w DailyWindow) Contains(t time.Time) bool {
+	if w.Start == w.End {
+		return false
+	}
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// HealthChecker is implemented by chat bots that know whether their AI model
+// APIs are available.
+type HealthChecker interface {
+	// Healthy reports whether the chat bot can currently reach an AI model.
+	Healthy() bool
+	// Probe checks whether AI model APIs that were down have recovered. It
+	// returns the usage of the probes.
+	Probe(ctx context.Context) bot.Response
+}
+
+// Away returns the bot's current away message, or an empty string if it's
+// available.
+func (s *SessionManager) Away() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.awayMessage
+}
+
+// desiredAway returns the away message the bot should show at time now, or
+// an empty string if it should be available.
+func (s *SessionManager) desiredAway(now time.Time) string {
+	if s.maintenance.Contains(now) {
+		return s.config.MaintenanceAwayMsg
+	}
+	if checker, ok := s.chatBot.(HealthChecker); ok && !checker.Healthy() {
+		return s.config.UnhealthyAwayMessage
+	}
+	return ""
+}
+
+// idleSince returns how long the bot has gone without receiving a message,
+// or 0 if it isn't considered idle.
+func (s *SessionManager) idleSince(now time.Time) time.Duration {
+	if s.config.IdleAfter <= 0 {
+		return 0
+	}
+	idle := now.Sub(time.Unix(0, s.lastActivity.Load()))
+	if idle < s.config.IdleAfter {
+		return 0
+	}
+	return idle
+}
+
+// manageStatus keeps the bot's away message and idle time up to date until
+// ctx is done. Buddies see the away message in their buddy list, so they
+// know that the bot is down before they IM it.
+func (s *SessionManager) manageStatus(ctx context.Context) {
+	ticker := time.NewTicker(statusCheckInterval)
+	defer ticker.Stop()
+
+	// the server forgets the status when the connection drops, so start
+	// from scratch
+	s.mu.Lock()
+	s.awayMessage = ""
+	s.mu.Unlock()
+	var idle bool
+	// probing holds a token while a probe is in flight, so that a slow probe
+	// neither holds up status updates nor piles up behind the next one
+	probing := make(chan struct{}, 1)
+
+	for {
+		select {
+		case probing <- struct{}{}:
+			go func() {
+				defer func() { <-probing }()
+				s.probeChatBot(ctx)
+			}()
+		default:
+		}
+		now := time.Now()
+
+		if away := s.desiredAway(now); away != s.Away() {
+			if !s.sendStatusSNAC(ctx, newAwaySNAC(away)) {
+				return
+			}
+			s.mu.Lock()
+			s.awayMessage = away
+			s.mu.Unlock()
+			if away == "" {
+				s.logger.Info("cleared away message")
+			} else {
+				s.logger.Info("set away message", "message", away)
+			}
+		}
+
+		switch idleFor := s.idleSince(now); {
+		case idleFor > 0 && !idle:
+			if !s.sendStatusSNAC(ctx, newIdleSNAC(idleFor)) {
+				return
+			}
+			idle = true
+			s.logger.Debug("went idle", "idle_for", idleFor.String())
+		case idleFor == 0 && idle:
+			if !s.sendStatusSNAC(ctx, newIdleSNAC(0)) {
+				return
+			}
+			idle = false
+			s.logger.Debug("no longer idle")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeChatBot checks whether an unhealthy chat bot has recovered, so that
+// the bot comes back from being away without waiting for a user's message.
+// The usage of the probe is billed to the bot itself.
+func (s *SessionManager) probeChatBot(ctx context.Context) {
+	checker, ok := s.chatBot.(HealthChecker)
+	if !ok || checker.Healthy() {
+		return
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	s.recordUsage(s.config.ScreenName, checker.Probe(probeCtx))
+}
+
+// sendStatusSNAC queues msg. It returns false if ctx is done first.
+func (s *SessionManager) sendStatusSNAC(ctx context.Context, msg wire.SNACMessage) bool {
+	select {
+	case s.msgCh <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// newAwaySNAC sets the bot's away message. An empty message makes the bot
+// available again.
+func newAwaySNAC(away string) wire.SNACMessage {
+	return wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.Locate,
+			SubGroup:  wire.LocateSetInfo,
+		},
+		Body: wire.SNAC_0x02_0x04_LocateSetInfo{
+			TLVRestBlock: wire.TLVRestBlock{
+				TLVList: wire.TLVList{
+					wire.NewTLV(wire.LocateTLVTagsInfoUnavailableMime, `text/aolrtf; charset="us-ascii"`),
+					wire.NewTLV(wire.LocateTLVTagsInfoUnavailableData, away),
+				},
+			},
+		},
+	}
+}
+
+// newIdleSNAC reports that the bot has been idle for idleFor. An idleFor of
+// 0 means the bot is active again.
+func newIdleSNAC(idleFor time.Duration) wire.SNACMessage {
+	return wire.SNACMessage{
+		Frame: wire.SNACFrame{
+			FoodGroup: wire.OService,
+			SubGroup:  wire.OServiceIdleNotification,
+		},
+		Body: wire.SNAC_0x01_0x11_OServiceIdleNotification{
+			IdleTime: uint32(idleFor / time.Second),
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDailyWindow(t *testing.T) {
+	tests := []struct {
+		window  string
+		wantErr bool
+		// in and out are times of day, as HH:MM, inside and outside the
+		// window
+		in  []string
+		out []string
+	}{
+		{
+			window: "",
+			out:    []string{"00:00", "12:00", "23:59"},
+		},
+		{
+			window: "02:00-04:30",
+			in:     []string{"02:00", "03:15", "04:29"},
+			out:    []string{"01:59", "04:30", "23:00"},
+		},
+		{
+			window: "23:00-01:00",
+			in:     []string{"23:00", "23:59", "00:00", "00:59"},
+			out:    []string{"22:59", "01:00", "12:00"},
+		},
+		{
+			window: " 22:30 - 06:00 ",
+			in:     []string{"22:30", "03:00", "05:59"},
+			out:    []string{"06:00", "22:29"},
+		},
+		{
+			window: "00:00-00:00",
+			out:    []string{"00:00", "12:00"},
+		},
+		{window: "02:00", wantErr: true},
+		{window: "2am-4am", wantErr: true},
+		{window: "25:00-04:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			w, err := ParseDailyWindow(tt.window)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseDailyWindow() = %+v, want an error", w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDailyWindow() error = %v", err)
+			}
+			for _, clock := range tt.in {
+				if !w.Contains(timeOfDay(t, clock)) {
+					t.Errorf("window doesn't contain %s", clock)
+				}
+			}
+			for _, clock := range tt.out {
+				if w.Contains(timeOfDay(t, clock)) {
+					t.Errorf("window contains %s", clock)
+				}
+			}
+		})
+	}
+}
+
+// timeOfDay returns the time clock, as HH:MM, on an arbitrary day.
+func timeOfDay(t *testing.T, clock string) time.Time {
+	t.Helper()
+	tod, err := time.Parse("15:04", clock)
+	if err != nil {
+		t.Fatalf("invalid time of day %q: %v", clock, err)
+	}
+	return time.Date(2024, time.January, 15, tod.Hour(), tod.Minute(), 0, 0, time.Local)
+}
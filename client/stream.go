@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// streamChunkMinLen is the minimum length of a streamed chunk that ends on a
+// sentence boundary. It keeps the bot from sending a flurry of tiny IMs when
+// the reply consists of many short sentences. Paragraph breaks are always
+// flushed regardless of length.
+const streamChunkMinLen = 80
+
+// streamResponse gets the bot's reply as a stream and sends each complete
+// sentence or paragraph to the user as a separate IM. If the user's client
+// wants typing events, the typing indicator is turned back on after each
+// chunk so that the user knows more is coming. It returns the full reply.
+func (s *SessionManager) streamResponse(
+	ctx context.Context,
+	streamer StreamingChatBot,
+	req bot.Request,
+	msgSNAC wire.SNAC_0x04_0x07_ICBMChannelMsgToClient,
+	wantsEvents bool,
+) (bot.Response, error) {
+
+	send := func(chunk string) error {
+		if err := sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, chunk, s.config); err != nil {
+			return err
+		}
+		if wantsEvents {
+			// the client clears the typing indicator when a message arrives
+			sendTypingEventSNAC(ctx, msgSNAC, s.msgCh, typingBegun)
+		}
+		return nil
+	}
+
+	chunker := sentenceChunker{minLen: streamChunkMinLen}
+	resp, err := streamer.RespondStream(ctx, req, func(delta string) error {
+		for _, chunk := range chunker.Write(delta) {
+			if err := send(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return bot.Response{}, err
+	}
+
+	if rest := chunker.Flush(); rest != "" {
+		if err := sendMessageSNAC(ctx, s.msgCh, msgSNAC.Cookie, msgSNAC.ScreenName, rest, s.config); err != nil {
+			return resp, err
+		}
+	} else if wantsEvents {
+		sendTypingEventSNAC(ctx, msgSNAC, s.msgCh, typingStopped)
+	}
+
+	return resp, nil
+}
+
+// sentenceChunker accumulates streamed text and releases it in whole
+// paragraphs, or in runs of whole sentences at least minLen long.
+type sentenceChunker struct {
+	buf    string
+	minLen int
+}
+
+// Write appends delta to the buffer and returns any chunks that are ready to
+// be sent.
+func (c *sentenceChunker) Write(delta string) []string {
+	c.buf += delta
+
+	var chunks []string
+	for {
+		if para, rest, found := strings.Cut(c.buf, "\n\n"); found {
+			c.buf = rest
+			if para = strings.TrimSpace(para); para != "" {
+				chunks = append(chunks, para)
+			}
+			continue
+		}
+		if end := lastSentenceEnd(c.buf); end >= c.minLen {
+			chunks = append(chunks, strings.TrimSpace(c.buf[:end]))
+			c.buf = c.buf[end:]
+		}
+		return chunks
+	}
+}
+
+// Flush returns the remaining buffered text.
+func (c *sentenceChunker) Flush() string {
+	rest := strings.TrimSpace(c.buf)
+	c.buf = ""
+	return rest
+}
+
+// lastSentenceEnd returns the offset just past the last sentence-ending
+// punctuation in s, or -1 if there is none. Punctuation only counts when
+// it's followed by whitespace, which keeps a partially streamed "3.14" or
+// "e.g." from being split.
+func lastSentenceEnd(s string) int {
+	for i := len(s) - 2; i >= 0; i-- {
+		switch s[i] {
+		case '.', '!', '?':
+			if unicode.IsSpace(rune(s[i+1])) {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+const (
+	// maxTakeoverEvents is the number of recent messages kept per takeover
+	// so that an operator who starts watching can catch up.
+	maxTakeoverEvents = 50
+	// takeoverWatchBuffer is the number of events buffered per watcher. A
+	// watcher that falls further behind misses events.
+	takeoverWatchBuffer = 16
+)
+
+// ErrNoTakeover is returned when replying to a conversation that no operator
+// has taken over.
+var ErrNoTakeover = errors.New("conversation has not been taken over")
+
+// Who sent a TakeoverEvent message.
+const (
+	TakeoverFromUser     = "user"
+	TakeoverFromOperator = "operator"
+)
+
+// TakeoverEvent is a message sent during a takeover.
+type TakeoverEvent struct {
+	Time time.Time `json:"time"`
+	// From is TakeoverFromUser or TakeoverFromOperator.
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+// Takeover describes a conversation that an operator has taken over.
+type Takeover struct {
+	ScreenName string `json:"screen_name"`
+	// Operator is the admin screen name that the user's messages are
+	// relayed to over IM, or empty if the operator uses the admin API.
+	Operator string    `json:"operator"`
+	Started  time.Time `json:"started"`
+}
+
+// takeover is the state of a conversation that an operator has taken over.
+type takeover struct {
+	Takeover
+	// pending holds the messages the user sent since the operator last
+	// replied. They become the user side of the next conversation turn.
+	pending  []string
+	events   []TakeoverEvent
+	watchers map[chan TakeoverEvent]struct{}
+}
+
+// publish records ev and sends it to each watcher that has room for it.
+func (t *takeover) publish(ev TakeoverEvent) {
+	t.events = append(t.events, ev)
+	if len(t.events) > maxTakeoverEvents {
+		t.events = t.events[len(t.events)-maxTakeoverEvents:]
+	}
+	for ch := range t.watchers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// takeoverSet holds the active takeovers, keyed by normalized user screen
+// name.
+type takeoverSet struct {
+	mu     sync.Mutex
+	byUser map[string]*takeover
+}
+
+// StartTakeover suppresses the bot's automatic replies to screenName so that
+// an operator can reply instead. If operator is an admin screen name, the
+// user's messages are relayed to it over IM, and any conversation the
+// operator previously took over is handed back to the bot.
+func (s *SessionManager) StartTakeover(screenName string, operator string) {
+	if operator != "" {
+		if user, ok := s.takeoverOf(operator); ok && store.NormalizeScreenName(user) != store.NormalizeScreenName(screenName) {
+			s.EndTakeover(user)
+		}
+	}
+
+	s.takeovers.mu.Lock()
+	if s.takeovers.byUser == nil {
+		s.takeovers.byUser = make(map[string]*takeover)
+	}
+	key := store.NormalizeScreenName(screenName)
+	t, ok := s.takeovers.byUser[key]
+	if !ok {
+		t = &takeover{
+			Takeover: Takeover{ScreenName: screenName, Started: time.Now()},
+			watchers: make(map[chan TakeoverEvent]struct{}),
+		}
+		s.takeovers.byUser[key] = t
+	}
+	t.Operator = operator
+	s.takeovers.mu.Unlock()
+
+	s.logger.Info("operator took over conversation", "screen_name", screenName, "operator", operator)
+}
+
+// EndTakeover hands the conversation with screenName back to the bot. It
+// returns false if the conversation wasn't taken over.
+func (s *SessionManager) EndTakeover(screenName string) bool {
+	s.takeovers.mu.Lock()
+	key := store.NormalizeScreenName(screenName)
+	t, ok := s.takeovers.byUser[key]
+	if ok {
+		delete(s.takeovers.byUser, key)
+		for ch := range t.watchers {
+			delete(t.watchers, ch)
+			close(ch)
+		}
+	}
+	s.takeovers.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	s.logger.Info("operator handed conversation back to the bot", "screen_name", screenName, "operator", t.Operator)
+	return true
+}
+
+// Takeovers returns the conversations that operators have taken over, oldest
+// first.
+func (s *SessionManager) Takeovers() []Takeover {
+	s.takeovers.mu.Lock()
+	defer s.takeovers.mu.Unlock()
+	list := make([]Takeover, 0, len(s.takeovers.byUser))
+	for _, t := range s.takeovers.byUser {
+		list = append(list, t.Takeover)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.Before(list[j].Started)
+	})
+	return list
+}
+
+// TakeoverReply sends text to screenName as the bot on behalf of the
+// operator who took over the conversation. The exchange is saved to the
+// conversation history so that the bot can pick up where the operator left
+// off.
+func (s *SessionManager) TakeoverReply(ctx context.Context, screenName string, text string) error {
+	s.takeovers.mu.Lock()
+	t, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]
+	if !ok {
+		s.takeovers.mu.Unlock()
+		return ErrNoTakeover
+	}
+	pending := t.pending
+	t.pending = nil
+	screenName = t.ScreenName
+	s.takeovers.mu.Unlock()
+
+	if err := s.SendIM(ctx, screenName, escapeRelayed(text)); err != nil {
+		// the user's messages are still waiting for a reply
+		s.takeovers.mu.Lock()
+		if t, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]; ok {
+			t.pending = append(pending, t.pending...)
+		}
+		s.takeovers.mu.Unlock()
+		return err
+	}
+
+	s.takeovers.mu.Lock()
+	if t, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]; ok {
+		t.publish(TakeoverEvent{Time: time.Now(), From: TakeoverFromOperator, Text: text})
+	}
+	s.takeovers.mu.Unlock()
+
+	turn := store.Turn{User: strings.Join(pending, "\n"), Bot: text, Time: time.Now()}
+	if err := s.conversations.Append(screenName, turn); err != nil {
+		s.logger.Error("unable to save conversation history", "err", err.Error())
+	}
+	return nil
+}
+
+// WatchTakeover returns the recent messages of the conversation with
+// screenName and a channel that receives each new message as it's sent. The
+// channel is closed when the conversation is handed back to the bot. Call
+// stop once done watching. It returns false if the conversation hasn't been
+// taken over.
+func (s *SessionManager) WatchTakeover(screenName string) (recent []TakeoverEvent, events <-chan TakeoverEvent, stop func(), ok bool) {
+	s.takeovers.mu.Lock()
+	defer s.takeovers.mu.Unlock()
+	t, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	ch := make(chan TakeoverEvent, takeoverWatchBuffer)
+	t.watchers[ch] = struct{}{}
+	stop = func() {
+		s.takeovers.mu.Lock()
+		defer s.takeovers.mu.Unlock()
+		if _, watching := t.watchers[ch]; watching {
+			delete(t.watchers, ch)
+			close(ch)
+		}
+	}
+	return append([]TakeoverEvent(nil), t.events...), ch, stop, true
+}
+
+// relayToOperator passes a message from screenName on to the operator if the
+// conversation has been taken over. It reports whether the message was
+// relayed, in which case the bot must not reply.
+func (s *SessionManager) relayToOperator(ctx context.Context, screenName string, text string) bool {
+	s.takeovers.mu.Lock()
+	t, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]
+	if !ok {
+		s.takeovers.mu.Unlock()
+		return false
+	}
+	t.pending = append(t.pending, text)
+	t.publish(TakeoverEvent{Time: time.Now(), From: TakeoverFromUser, Text: text})
+	operator := t.Operator
+	s.takeovers.mu.Unlock()
+
+	s.logger.Info("relayed message to operator", "screen_name", screenName, "operator", operator, "incoming", text)
+	if operator != "" {
+		if err := s.SendIM(ctx, operator, "["+html.EscapeString(screenName)+"] "+escapeRelayed(text)); err != nil {
+			s.logger.Error("unable to relay message to operator", "err", err.Error())
+		}
+	}
+	return true
+}
+
+// escapeRelayed escapes text passed between a user and an operator so that
+// it's shown as typed. Text from an IM has had its tags stripped but may
+// still hold HTML entities, which are decoded first so they aren't escaped
+// twice.
+func escapeRelayed(text string) string {
+	return html.EscapeString(html.UnescapeString(text))
+}
+
+// takeoverOf returns the user whose conversation operator has taken over
+// over IM.
+func (s *SessionManager) takeoverOf(operator string) (string, bool) {
+	s.takeovers.mu.Lock()
+	defer s.takeovers.mu.Unlock()
+	for _, t := range s.takeovers.byUser {
+		if t.Operator != "" && store.NormalizeScreenName(t.Operator) == store.NormalizeScreenName(operator) {
+			return t.ScreenName, true
+		}
+	}
+	return "", false
+}
+
+// takenOver reports whether an operator has taken over the conversation with
+// screenName.
+func (s *SessionManager) takenOver(screenName string) bool {
+	s.takeovers.mu.Lock()
+	defer s.takeovers.mu.Unlock()
+	_, ok := s.takeovers.byUser[store.NormalizeScreenName(screenName)]
+	return ok
+}
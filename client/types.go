@@ -1,11 +1,91 @@
 package client
 
 import (
+	"context"
+	"time"
+
 	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/store"
 )
 
+// ChatBot generates the bot's replies to users.
 type ChatBot interface {
-	ExchangeMessage(send string, exchange [2]string) (receive string, err error)
+	// Respond returns the bot's reply to req. Implementations should give up
+	// and return ctx.Err() once ctx is cancelled.
+	Respond(ctx context.Context, req bot.Request) (bot.Response, error)
+}
+
+// StreamingChatBot is a ChatBot that can deliver its reply incrementally.
+type StreamingChatBot interface {
+	ChatBot
+	// RespondStream is like Respond, except that onDelta is called with each
+	// fragment of the reply as it's generated. The complete reply is
+	// returned once the stream ends.
+	RespondStream(ctx context.Context, req bot.Request, onDelta func(delta string) error) (bot.Response, error)
+}
+
+// ConversationStore keeps a rolling history of the bot's conversations with
+// each user.
+type ConversationStore interface {
+	// History returns the conversation history for screenName, oldest turn
+	// first.
+	History(screenName string) ([]store.Turn, error)
+	// Append adds a turn to the conversation history for screenName.
+	Append(screenName string, turn store.Turn) error
+	// Reset clears the conversation history for screenName.
+	Reset(screenName string) error
+}
+
+// UsageLedger tallies AI model usage per user and overall, by day and by
+// month.
+type UsageLedger interface {
+	// Record adds usage by screenName at time at.
+	Record(screenName string, usage store.Usage, at time.Time) error
+	// Usage returns screenName's usage on the day and in the month of time
+	// at.
+	Usage(screenName string, at time.Time) (day store.Usage, month store.Usage)
+	// Total returns the usage of all users on the day and in the month of
+	// time at.
+	Total(at time.Time) (day store.Usage, month store.Usage)
+}
+
+// AccessList holds the users who are allowed to talk to the bot and the
+// users who are denied.
+type AccessList interface {
+	// Allow adds screenName to the allow list.
+	Allow(screenName string) error
+	// Disallow removes screenName from the allow list.
+	Disallow(screenName string) error
+	// Deny adds screenName to the deny list.
+	Deny(screenName string) error
+	// Undeny removes screenName from the deny list.
+	Undeny(screenName string) error
+	// IsAllowed reports whether screenName is on the allow list.
+	IsAllowed(screenName string) bool
+	// IsDenied reports whether screenName is on the deny list.
+	IsDenied(screenName string) bool
+	// Allowed returns the allow list.
+	Allowed() []string
+	// Denied returns the deny list.
+	Denied() []string
+}
+
+// SubscriptionStore holds the users who asked the bot to greet them when
+// they sign on.
+type SubscriptionStore interface {
+	// Subscribe adds screenName to the subscribers. It returns false if
+	// screenName was already subscribed.
+	Subscribe(screenName string) (bool, error)
+	// Unsubscribe removes screenName from the subscribers. It returns false
+	// if screenName wasn't subscribed.
+	Unsubscribe(screenName string) (bool, error)
+	// Subscriber returns the subscription of screenName, if they're
+	// subscribed.
+	Subscriber(screenName string) (store.Subscriber, bool)
+	// Greeted records that the bot greeted screenName at time at.
+	Greeted(screenName string, at time.Time) error
 }
 
 type FlapClient interface {
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+)
+
+// Typing events, sent in ICBMClientEvent SNACs by clients that support typing
+// notifications.
+const (
+	// typingStopped means the user cleared their message or sent it.
+	typingStopped uint16 = 0x0000
+	// typingTyped means the user entered text but paused typing.
+	typingTyped uint16 = 0x0001
+	// typingBegun means the user is typing.
+	typingBegun uint16 = 0x0002
+)
+
+// handleClientEvent keeps track of whether a user is typing to the bot.
+// Events from users the bot hasn't chatted with yet are ignored.
+func (s *SessionManager) handleClientEvent(flapBody *bytes.Buffer) error {
+	event := wire.SNAC_0x04_0x14_ICBMClientEvent{}
+	if err := wire.UnmarshalBE(&event, flapBody); err != nil {
+		return err
+	}
+
+	s.chatContextsMu.RLock()
+	chatCtx, ok := s.chatContexts[event.ScreenName]
+	s.chatContextsMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	chatCtx.heardFrom(event.Event == typingBegun, time.Now())
+	return nil
+}
+
+// heardFrom records that the user sent a message or typing event at time at.
+// typing indicates whether they're still typing.
+func (c *chatContext) heardFrom(typing bool, at time.Time) {
+	c.mu.Lock()
+	c.typing = typing
+	c.lastHeard = at
+	c.mu.Unlock()
+
+	select {
+	case c.heard <- struct{}{}:
+	default:
+	}
+}
+
+// awaitTyping holds off replying to a message received at receivedAt while
+// the user is still typing, for up to TYPING_MAX_WAIT. The bot replies once
+// the user has been quiet for TYPING_WAIT. It returns the messages the user
+// sent in the meantime, which are answered along with the first one.
+func (s *SessionManager) awaitTyping(ctx context.Context, chatCtx *chatContext, receivedAt time.Time) []string {
+	if s.config.TypingWait <= 0 {
+		return nil
+	}
+
+	deadline := receivedAt.Add(s.config.TypingMaxWait)
+	for ctx.Err() == nil {
+		chatCtx.mu.Lock()
+		typing, lastHeard := chatCtx.typing, chatCtx.lastHeard
+		chatCtx.mu.Unlock()
+
+		wakeAt := deadline
+		if quietAt := lastHeard.Add(s.config.TypingWait); !typing && quietAt.Before(deadline) {
+			wakeAt = quietAt
+		}
+		wait := time.Until(wakeAt)
+		if wait <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-chatCtx.heard:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	return chatCtx.takeQueued()
+}
+
+// typingDelay returns how much longer the bot should appear to type reply,
+// given that it started working on it at started. The typing time is
+// TYPING_DELAY_PER_CHAR for each character of the reply, up to
+// TYPING_DELAY_MAX, less the time the bot already spent coming up with it.
+func (s *SessionManager) typingDelay(reply string, started time.Time) time.Duration {
+	if s.config.TypingDelayPerChar <= 0 {
+		return 0
+	}
+	typingFor := min(time.Duration(len([]rune(reply)))*s.config.TypingDelayPerChar, s.config.TypingDelayMax)
+	return typingFor - time.Since(started)
+}
+
+// simulateTyping waits until the bot has plausibly finished typing reply,
+// which it started working on at started. It returns false if ctx is done
+// first.
+func (s *SessionManager) simulateTyping(ctx context.Context, reply string, started time.Time) bool {
+	delay := s.typingDelay(reply, started)
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
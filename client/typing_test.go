@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/config"
+)
+
+func TestTypingDelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		perChar     time.Duration
+		max         time.Duration
+		reply       string
+		spent       time.Duration
+		wantAtLeast time.Duration
+		wantAtMost  time.Duration
+	}{
+		{
+			name:       "disabled",
+			max:        5 * time.Second,
+			reply:      "hello",
+			wantAtMost: 0,
+		},
+		{
+			name:        "per character",
+			perChar:     100 * time.Millisecond,
+			max:         5 * time.Second,
+			reply:       "hello",
+			wantAtLeast: 400 * time.Millisecond,
+			wantAtMost:  500 * time.Millisecond,
+		},
+		{
+			name:        "characters, not bytes",
+			perChar:     100 * time.Millisecond,
+			max:         5 * time.Second,
+			reply:       "héllo",
+			wantAtLeast: 400 * time.Millisecond,
+			wantAtMost:  500 * time.Millisecond,
+		},
+		{
+			name:        "capped at the maximum",
+			perChar:     time.Second,
+			max:         2 * time.Second,
+			reply:       "a long reply",
+			wantAtLeast: 1900 * time.Millisecond,
+			wantAtMost:  2 * time.Second,
+		},
+		{
+			name:        "less the time spent on the reply",
+			perChar:     100 * time.Millisecond,
+			max:         5 * time.Second,
+			reply:       "hello",
+			spent:       300 * time.Millisecond,
+			wantAtLeast: 100 * time.Millisecond,
+			wantAtMost:  200 * time.Millisecond,
+		},
+		{
+			name:       "already took longer than typing",
+			perChar:    100 * time.Millisecond,
+			max:        5 * time.Second,
+			reply:      "hello",
+			spent:      time.Second,
+			wantAtMost: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SessionManager{config: config.Config{
+				TypingDelayPerChar: tt.perChar,
+				TypingDelayMax:     tt.max,
+			}}
+			got := s.typingDelay(tt.reply, time.Now().Add(-tt.spent))
+			if got > tt.wantAtMost || (tt.wantAtLeast > 0 && got < tt.wantAtLeast) {
+				t.Errorf("typingDelay() = %s, want between %s and %s", got, tt.wantAtLeast, tt.wantAtMost)
+			}
+		})
+	}
+}
+
+func TestAwaitTyping(t *testing.T) {
+	const (
+		wait    = 50 * time.Millisecond
+		maxWait = 200 * time.Millisecond
+	)
+	s := &SessionManager{config: config.Config{
+		TypingWait:    wait,
+		TypingMaxWait: maxWait,
+	}}
+
+	t.Run("disabled", func(t *testing.T) {
+		s := &SessionManager{config: config.Config{TypingMaxWait: maxWait}}
+		chatCtx := newTestChatContext()
+		chatCtx.queued = []string{"left alone"}
+		if got := s.awaitTyping(context.Background(), chatCtx, time.Now()); got != nil {
+			t.Errorf("awaitTyping() = %q, want nil", got)
+		}
+		if len(chatCtx.queued) != 1 {
+			t.Error("awaitTyping took the queued messages")
+		}
+	})
+
+	t.Run("replies once the user is quiet", func(t *testing.T) {
+		chatCtx := newTestChatContext()
+		receivedAt := time.Now()
+		chatCtx.heardFrom(false, receivedAt)
+
+		s.awaitTyping(context.Background(), chatCtx, receivedAt)
+		if elapsed := time.Since(receivedAt); elapsed < wait || elapsed >= maxWait {
+			t.Errorf("waited %s, want about %s", elapsed, wait)
+		}
+	})
+
+	t.Run("waits while the user types", func(t *testing.T) {
+		chatCtx := newTestChatContext()
+		receivedAt := time.Now()
+		chatCtx.heardFrom(true, receivedAt)
+		go func() {
+			time.Sleep(wait)
+			chatCtx.mu.Lock()
+			chatCtx.queued = append(chatCtx.queued, "and another thing")
+			chatCtx.mu.Unlock()
+			chatCtx.heardFrom(false, time.Now())
+		}()
+
+		got := s.awaitTyping(context.Background(), chatCtx, receivedAt)
+		if elapsed := time.Since(receivedAt); elapsed < 2*wait || elapsed >= maxWait {
+			t.Errorf("waited %s, want about %s", elapsed, 2*wait)
+		}
+		if want := []string{"and another thing"}; !slices.Equal(got, want) {
+			t.Errorf("awaitTyping() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("gives up on a user who keeps typing", func(t *testing.T) {
+		chatCtx := newTestChatContext()
+		receivedAt := time.Now()
+		chatCtx.heardFrom(true, receivedAt)
+
+		s.awaitTyping(context.Background(), chatCtx, receivedAt)
+		if elapsed := time.Since(receivedAt); elapsed < maxWait || elapsed >= 2*maxWait {
+			t.Errorf("waited %s, want about %s", elapsed, maxWait)
+		}
+	})
+
+	t.Run("stops when ctx is done", func(t *testing.T) {
+		chatCtx := newTestChatContext()
+		receivedAt := time.Now()
+		chatCtx.heardFrom(true, receivedAt)
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+
+		s.awaitTyping(ctx, chatCtx, receivedAt)
+		if elapsed := time.Since(receivedAt); elapsed >= maxWait {
+			t.Errorf("waited %s after ctx was done", elapsed)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mk6i/retro-aim-server/wire"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+	"github.com/mk6i/smarter-smarter-child/store"
+)
+
+const (
+	// userProfileTimeout caps how long a reply waits on a profile lookup.
+	userProfileTimeout = 5 * time.Second
+	// maxUserProfileLen caps how much of a user's profile or away message
+	// is passed to the bot, in characters.
+	maxUserProfileLen = 500
+)
+
+// UserProfile looks up what screenName says about themselves in their AIM
+// profile and away message, with the HTML removed. Lookups are cached for
+// PROFILE_CACHE_TTL. Both are empty if the user is offline.
+func (s *SessionManager) UserProfile(ctx context.Context, screenName string) (bot.UserProfile, error) {
+	now := time.Now()
+	if profile, ok := s.userProfiles.get(screenName, now); ok {
+		return profile, nil
+	}
+	if s.State() != StateOnline {
+		return bot.UserProfile{}, fmt.Errorf("not connected to AIM")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, userProfileTimeout)
+	defer cancel()
+	reply, online, err := s.queryUserInfo(ctx, screenName, wire.LocateTypeSig|wire.LocateTypeUnavailable)
+	if err != nil {
+		return bot.UserProfile{}, err
+	}
+
+	var profile bot.UserProfile
+	if online {
+		sig, _ := reply.LocateInfo.String(wire.LocateTLVTagsInfoSigData)
+		away, _ := reply.LocateInfo.String(wire.LocateTLVTagsInfoUnavailableData)
+		profile.Profile = s.profileText(sig)
+		profile.AwayMessage = s.profileText(away)
+	}
+	s.userProfiles.set(screenName, profile, now.Add(s.config.ProfileCacheTTL))
+	return profile, nil
+}
+
+// userProfile returns what screenName says about themselves for the bot's
+// prompt, or nil if PERSONALIZE_PROFILES is off or there's nothing to say.
+func (s *SessionManager) userProfile(ctx context.Context, screenName string) *bot.UserProfile {
+	if !s.config.PersonalizeProfiles {
+		return nil
+	}
+	profile, err := s.UserProfile(ctx, screenName)
+	if err != nil {
+		s.logger.Error("unable to look up user profile", "screen_name", screenName, "err", err.Error())
+		return nil
+	}
+	if profile == (bot.UserProfile{}) {
+		return nil
+	}
+	return &profile
+}
+
+// profileText converts a profile or away message to plain text. AIM clients
+// replace %n with the screen name of whoever is reading it, which is the bot.
+func (s *SessionManager) profileText(profileHTML string) string {
+	// replace tags with spaces so that line breaks don't run words together
+	text := html.UnescapeString(stripHTMLRegex.ReplaceAllString(profileHTML, " "))
+	text = strings.ReplaceAll(text, "%n", s.config.ScreenName)
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxUserProfileLen {
+		text = string(runes[:maxUserProfileLen]) + "..."
+	}
+	return text
+}
+
+// userProfileCache holds the profiles of the users the bot has looked up
+// recently.
+type userProfileCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedUserProfile
+}
+
+type cachedUserProfile struct {
+	profile bot.UserProfile
+	expires time.Time
+}
+
+// get returns screenName's profile unless it's missing or expired at time
+// now.
+func (c *userProfileCache) get(screenName string, now time.Time) (bot.UserProfile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[store.NormalizeScreenName(screenName)]
+	if !ok || !now.Before(entry.expires) {
+		return bot.UserProfile{}, false
+	}
+	return entry.profile, true
+}
+
+// set caches screenName's profile until expires. Expired entries are evicted
+// along the way so that the cache doesn't grow without bound.
+func (c *userProfileCache) set(screenName string, profile bot.UserProfile, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedUserProfile)
+	}
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[store.NormalizeScreenName(screenName)] = cachedUserProfile{profile: profile, expires: expires}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/mk6i/smarter-smarter-child/bot"
+)
+
+// welcomeBackPrompt asks the bot to greet a subscriber who just signed on.
+const welcomeBackPrompt = "(I just signed on to AIM. Greet me and welcome me back in a sentence or two, " +
+	"maybe picking up on something we talked about before.)"
+
+// defaultWelcomeBack is sent when the bot can't come up with a greeting.
+const defaultWelcomeBack = "Welcome back, %s!"
+
+func (s *SessionManager) subscribeCommand(ctx context.Context, call CommandCall) (string, error) {
+	if !s.buddies.contains(call.ScreenName) {
+		if len(s.buddies.list()) >= maxBuddies {
+			return "Sorry, my buddy list is full, so I can't keep an eye out for you.", nil
+		}
+		if err := s.AddBuddy(ctx, call.ScreenName); err != nil {
+			return "", fmt.Errorf("unable to add buddy: %w", err)
+		}
+	}
+	added, err := s.subscriptions.Subscribe(call.ScreenName)
+	if err != nil {
+		return "", fmt.Errorf("unable to subscribe: %w", err)
+	}
+	if !added {
+		return "You're already subscribed! I'll say hi when you sign on. Type /unsubscribe to stop.", nil
+	}
+	return "OK! I'll say hi when you sign on. Type /unsubscribe to stop.", nil
+}
+
+func (s *SessionManager) unsubscribeCommand(ctx context.Context, call CommandCall) (string, error) {
+	removed, err := s.subscriptions.Unsubscribe(call.ScreenName)
+	if err != nil {
+		return "", fmt.Errorf("unable to unsubscribe: %w", err)
+	}
+	if !removed {
+		return "You're not subscribed. Type /subscribe if you want me to say hi when you sign on.", nil
+	}
+	if !s.config.AutoAddBuddies {
+		// the user was only on the buddy list for the greetings
+		if err := s.RemoveBuddy(ctx, call.ScreenName); err != nil {
+			s.logger.Error("unable to remove buddy", "screen_name", call.ScreenName, "err", err.Error())
+		}
+	}
+	return "OK, I won't say hi when you sign on anymore.", nil
+}
+
+// welcomeBack greets a subscriber who just signed on, unless they were
+// greeted within WELCOME_BACK_INTERVAL.
+func (s *SessionManager) welcomeBack(ctx context.Context, info bot.BuddyInfo) {
+	screenName := info.ScreenName
+	logger := s.logger.With("screen_name", screenName)
+
+	sub, ok := s.subscriptions.Subscriber(screenName)
+	switch {
+	case !ok:
+		return
+	case time.Since(sub.LastGreeted) < s.config.WelcomeBackInterval:
+		logger.Debug("not welcoming back user who was greeted recently", "last_greeted", sub.LastGreeted)
+		return
+	}
+	if _, ok := s.permitted(screenName); !ok {
+		return
+	}
+	if s.takenOver(screenName) {
+		return
+	}
+
+	// record the greeting up front so that a flapping connection doesn't
+	// get the user greeted twice
+	now := time.Now()
+	if err := s.subscriptions.Greeted(screenName, now); err != nil {
+		logger.Error("unable to record greeting", "err", err.Error())
+		return
+	}
+
+	greeting := s.welcomeBackGreeting(ctx, info, now)
+	if err := sendMessageSNAC(ctx, s.msgCh, rand.Uint64(), screenName, greeting, s.config); err != nil {
+		logger.Error("unable to send welcome back greeting", "err", err.Error())
+		return
+	}
+	logger.Info("sent welcome back greeting", "outgoing", greeting)
+}
+
+// welcomeBackGreeting returns WELCOME_BACK_MESSAGE for screenName, or asks the
+// bot for a greeting if there's no message configured.
+func (s *SessionManager) welcomeBackGreeting(ctx context.Context, info bot.BuddyInfo, now time.Time) string {
+	screenName := info.ScreenName
+	if msg := s.config.WelcomeBackMessage; msg != "" {
+		return strings.ReplaceAll(msg, "%s", screenName)
+	}
+
+	fallback := fmt.Sprintf(defaultWelcomeBack, screenName)
+	if _, exhausted := s.checkBudget(screenName, now); exhausted {
+		return fallback
+	}
+	history, err := s.conversations.History(screenName)
+	if err != nil {
+		s.logger.Error("unable to load conversation history", "err", err.Error())
+	}
+
+	respCtx, cancel := context.WithTimeout(ctx, s.config.ResponseTimeout)
+	defer cancel()
+	resp, err := s.chatBot.Respond(respCtx, bot.Request{
+		ScreenName: screenName,
+		Text:       welcomeBackPrompt,
+		History:    history,
+		Channel:    bot.ChannelIM,
+		Persona:    s.personas.get(screenName),
+		Presence:   &info,
+		Profile:    s.userProfile(ctx, screenName),
+		ReceivedAt: now,
+	})
+	if err != nil {
+		s.logger.Error("unable to get welcome back greeting from bot", "err", err.Error())
+		return fallback
+	}
+	s.recordUsage(screenName, resp)
+	return resp.Text
+}
@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
-	"github.com/mk6i/retro-aim-server/wire"
 
+	"github.com/mk6i/smarter-smarter-child/admin"
 	"github.com/mk6i/smarter-smarter-child/bot"
 	"github.com/mk6i/smarter-smarter-child/client"
 	"github.com/mk6i/smarter-smarter-child/config"
+	"github.com/mk6i/smarter-smarter-child/metrics"
+	"github.com/mk6i/smarter-smarter-child/store"
 )
 
+// defaultAdminAddr is where the admin server listens if ADMIN_ADDR is unset.
+const defaultAdminAddr = "127.0.0.1:8081"
+
 func main() {
 	var cfg config.Config
 	if err := envconfig.Process("", &cfg); err != nil {
@@ -24,60 +34,194 @@ func main() {
 
 	logger := NewLogger(cfg)
 
-	bosHost, authCookie, err := func() (string, string, error) {
-		host := net.JoinHostPort(cfg.OSCARHost, cfg.OSCARPort)
-		conn, err := net.Dial("tcp", host)
+	tools := bot.NewToolRegistry()
+
+	var chatBot client.ChatBot
+	if cfg.OfflineMode {
+		logger.Debug("offline mode enabled, using local chatbot backend")
+		chatBot = bot.Adapt(bot.NewStaticChatBot())
+	} else {
+		fallbackBot, err := newFallbackChatBot(logger, cfg, tools)
 		if err != nil {
-			return "", "", fmt.Errorf("unable to dial into auth host: %w", err)
+			logger.Error("unable to set up chatbot backend", "err", err.Error())
+			os.Exit(1)
 		}
-		defer func() {
-			logger.Debug("disconnected from auth service", "host", host)
-			conn.Close()
-		}()
+		chatBot = fallbackBot
+	}
 
-		logger.Debug("connected to auth service", "host", host)
+	if cfg.HistoryTurns < 1 {
+		logger.Error("invalid number of history turns, must be at least 1", "turns", cfg.HistoryTurns)
+		os.Exit(1)
+	}
 
-		flapc := wire.NewFlapClient(0, conn, conn)
-		host, authCookie, err := client.Authenticate(flapc, cfg.ScreenName, cfg.Password)
-		if err == nil {
-			logger.Debug("authentication succeeded, proceeding to BOS host", "host", host, "authCookie", authCookie)
+	var conversations client.ConversationStore
+	if cfg.ConversationStoreFile != "" {
+		fileStore, err := store.NewFileConversationStore(cfg.ConversationStoreFile, cfg.HistoryTurns)
+		if err != nil {
+			logger.Error("unable to open conversation store", "err", err.Error())
+			os.Exit(1)
 		}
-		return host, authCookie, err
-	}()
+		defer fileStore.Close()
+		logger.Debug("using file-backed conversation store", "path", cfg.ConversationStoreFile)
+		conversations = fileStore
+	} else {
+		logger.Debug("using in-memory conversation store")
+		conversations = store.NewInMemoryConversationStore(cfg.HistoryTurns)
+	}
 
+	switch cfg.BudgetUnit {
+	case "tokens", "usd":
+	default:
+		logger.Error("invalid budget unit", "unit", cfg.BudgetUnit)
+		os.Exit(1)
+	}
+
+	if _, err := client.ParseDailyWindow(cfg.MaintenanceWindow); err != nil {
+		logger.Error("invalid maintenance window", "err", err.Error())
+		os.Exit(1)
+	}
+
+	ledger, err := store.NewUsageLedger(cfg.UsageLedgerFile)
 	if err != nil {
-		logger.Error("authentication failed", "err", err.Error())
+		logger.Error("unable to open usage ledger", "err", err.Error())
 		os.Exit(1)
 	}
+	defer ledger.Close()
 
-	err = func() error {
-		conn, err := net.Dial("tcp", bosHost)
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
+	access, err := newAccessList(cfg)
+	if err != nil {
+		logger.Error("unable to open access list", "err", err.Error())
+		os.Exit(1)
+	}
+
+	subscriptions, err := store.NewSubscriptionStore(cfg.SubscriptionsFile)
+	if err != nil {
+		logger.Error("unable to open subscriptions", "err", err.Error())
+		os.Exit(1)
+	}
 
-		logger.Info("connected to BOS server", "host", bosHost)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		flapc := wire.NewFlapClient(0, conn, conn)
+	session := client.NewSessionManager(logger, cfg, chatBot, conversations, ledger, access, subscriptions)
 
-		var chatBot client.ChatBot
-		if cfg.OfflineMode {
-			logger.Debug("offline mode enabled, using local chatbot backend")
-			chatBot = bot.NewStaticChatBot()
-		} else {
-			logger.Debug("using OpenAI API chatbot backend")
-			chatBot = bot.NewChatGPTBot(cfg)
+	if err := registerTools(tools, cfg, session); err != nil {
+		logger.Error("unable to register tools", "err", err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		go serveHTTP(ctx, logger, "metrics", cfg.MetricsAddr, mux)
+	}
+	if cfg.AdminToken != "" {
+		prompt, _ := chatBot.(bot.PromptEditor)
+		adminServer := admin.NewServer(logger, cfg.AdminToken, session, conversations, prompt)
+		addr := cfg.AdminAddr
+		if addr == "" {
+			// never expose the admin API on all interfaces by accident
+			addr = defaultAdminAddr
 		}
-		return client.Chat(logger, flapc, authCookie, chatBot, cfg)
-	}()
+		go serveHTTP(ctx, logger, "admin", addr, adminServer)
+	}
 
-	if err != nil {
+	if err := session.Run(ctx); err != nil {
 		logger.Error("chat failed", "err", err.Error())
 		os.Exit(1)
 	}
 }
 
+// newFallbackChatBot creates a chat bot that calls the primary AI model,
+// then the secondary AI model and canned responses, as configured.
+func newFallbackChatBot(logger *slog.Logger, cfg config.Config, tools *bot.ToolRegistry) (*bot.FallbackChatBot, error) {
+	prices, err := bot.ParsePriceTable(cfg.ModelPrices)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := bot.NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("using AI model chatbot backend", "provider", cfg.Provider, "model", cfg.Model)
+	backends := []bot.NamedBackend{
+		{Name: "primary", Backend: bot.NewLLMChatBot(cfg, provider, tools, prices)},
+	}
+
+	if cfg.FallbackProvider != "" {
+		fallbackCfg := cfg
+		fallbackCfg.Provider = cfg.FallbackProvider
+		fallbackCfg.Model = cfg.FallbackModel
+		fallbackCfg.APIUrl = cfg.FallbackAPIUrl
+		provider, err := bot.NewProvider(fallbackCfg)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("using secondary AI model chatbot backend", "provider", fallbackCfg.Provider, "model", fallbackCfg.Model)
+		backends = append(backends, bot.NamedBackend{
+			Name:    "secondary",
+			Backend: bot.NewLLMChatBot(fallbackCfg, provider, tools, prices),
+		})
+	}
+
+	if cfg.FallbackStatic {
+		backends = append(backends, bot.NamedBackend{
+			Name:    "static",
+			Backend: bot.Adapt(bot.NewStaticChatBot()),
+			Canned:  true,
+		})
+	}
+
+	return bot.NewFallbackChatBot(logger, cfg.CircuitFailures, cfg.CircuitCooldown, backends...), nil
+}
+
+// serveHTTP serves handler on addr until ctx is done. name identifies the
+// server in logs.
+func serveHTTP(ctx context.Context, logger *slog.Logger, name string, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logger.Info("serving "+name, "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("unable to serve "+name, "err", err.Error())
+	}
+}
+
+// registerTools adds the tools enabled in cfg to the registry. The session
+// carries out the tools that act on the AIM network.
+func registerTools(tools *bot.ToolRegistry, cfg config.Config, session *client.SessionManager) error {
+	for _, name := range cfg.Tools {
+		var tool bot.Tool
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "time":
+			tool = bot.NewTimeTool()
+		case "calculator":
+			tool = bot.NewCalculatorTool()
+		case "dictionary":
+			tool = bot.NewDictionaryTool(&http.Client{Timeout: 10 * time.Second}, cfg.DictionaryURL)
+		case "reminder":
+			tool = bot.NewReminderTool(session)
+		case "buddy_info":
+			tool = bot.NewBuddyInfoTool(session)
+		default:
+			return fmt.Errorf("unknown tool %q", name)
+		}
+		if err := tools.Register(tool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewLogger(cfg config.Config) *slog.Logger {
 	var level slog.Level
 	switch strings.ToLower(cfg.LogLevel) {
@@ -98,3 +242,27 @@ func NewLogger(cfg config.Config) *slog.Logger {
 	}
 	return slog.New(slog.NewTextHandler(os.Stdout, opts))
 }
+
+// newAccessList opens the access list and adds the screen names from
+// ALLOW_LIST and DENY_LIST to it.
+func newAccessList(cfg config.Config) (*store.AccessList, error) {
+	access, err := store.NewAccessList(cfg.AccessListFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, screenName := range cfg.AllowList {
+		if screenName = strings.TrimSpace(screenName); screenName != "" {
+			if err := access.Allow(screenName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, screenName := range cfg.DenyList {
+		if screenName = strings.TrimSpace(screenName); screenName != "" {
+			if err := access.Deny(screenName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return access, nil
+}
@@ -1,23 +1,87 @@
 package config
 
+import "time"
+
 //go:generate go run github.com/mk6i/smarter-smarter-child/cmd/config_generator windows settings.bat
 //go:generate go run github.com/mk6i/smarter-smarter-child/cmd/config_generator unix settings.env
 type Config struct {
-	LogLevel        string  `envconfig:"LOG_LEVEL" required:"true" val:"info" description:"Set logging granularity. Possible values: 'debug', 'info', 'warn', 'error'."`
-	MaxMsgPerMin    int     `envconfig:"MAX_MSG_PER_MIN" required:"true" val:"10" description:"Specifies the maximum number of messages a user can send to the bot per minute before rate limiting is applied."`
-	OSCARHost       string  `envconfig:"OSCAR_HOST" required:"true" val:"127.0.0.1" description:"The OSCAR hostname to connect to."`
-	OSCARPort       string  `envconfig:"OSCAR_PORT" required:"true" val:"5190" description:"The OSCAR port to connect to."`
-	OfflineMode     bool    `envconfig:"OFFLINE_MODE" required:"false" val:"true" description:"Use a static chat bot that serves canned responses instead of OpenAI for testing."`
-	OpenAIKey       string  `envconfig:"OPEN_AI_KEY" required:"false" val:"" description:"Key required to connect to the OpenAI API."`
-	Password        string  `envconfig:"PASSWORD" required:"true" val:"" description:"The bot's account password."`
-	ScreenName      string  `envconfig:"SCREEN_NAME" required:"true" val:"smartersmarterchild" description:"The bot's screen name."`
-	WordCountLimit  int     `envconfig:"WORD_COUNT_LIMIT" required:"true" val:"25" description:"The maximum number of words sent to the bot in a single message."`
-	WordLengthLimit int     `envconfig:"WORD_LENGTH_LIMIT" required:"true" val:"15" description:"The maximum length of any word sent to the bot in a single message."`
-	ProfileHTML     string  `envconfig:"PROFILE_HTML" required:"true" val:"'<HTML><BODY BGCOLOR=\"#CDFFFE\"><FONT FACE=\"Courier New\" COLOR=\"#000080\" LANG=\"0\">Hello, %n!<BR>Send me an IM to get started!</FONT><BR><BR><HR><FONT SIZE=1>Powered by <A HREF=\"https://github.com/mk6i/smarter-smarter-child\">SmarterSmarterChild</A>.</FONT></BODY></HTML>'" description:"The bot's HTML profile information."`
-	MsgFormat       string  `envconfig:"MSG_FORMAT" required:"true" val:"'<HTML><BODY BGCOLOR=\"#CDFFFE\"><FONT FACE=\"Courier New\" COLOR=\"#000080\" LANG=\"0\">@MsgContent@</FONT></BODY></HTML>'" description:"The bot's message response. @MsgContent@ will be replaced with the content of the bot's response."`
-	TopP            float64 `envconfig:"TOP_P" required:"true" val:"0.5" description:"The top-p value to use when querying the OpenAI API."`
-	Temperature     float64 `envconfig:"TEMPERATURE" required:"true" val:"0.7" description:"The temperature value to use when querying the OpenAI API."`
-	Model           string  `envconfig:"MODEL" required:"true" val:"'gpt-4o-mini'" description:"The AI model to use."`
-	BotPrompt       string  `envconfig:"BOT_PROMPT" required:"true" val:"'You are SmarterChild, a dumb AIM chatbot.'" description:"The initial prompt to the OpenAI API when creating a new conversation."`
-	APIUrl          string  `envconfig:"API_URL" required:"true" val:"'https://api.openai.com/v1/chat/completions'" description:"OpenAI API URL."`
+	LogLevel              string        `envconfig:"LOG_LEVEL" required:"true" val:"info" description:"Set logging granularity. Possible values: 'debug', 'info', 'warn', 'error'."`
+	MaxMsgPerMin          int           `envconfig:"MAX_MSG_PER_MIN" required:"true" val:"10" description:"Specifies the maximum number of messages a user can send to the bot per minute before rate limiting is applied."`
+	MaxQueuedMsgs         int           `envconfig:"MAX_QUEUED_MSGS" required:"true" val:"5" description:"The maximum number of messages from a user that the bot holds on to while it is replying to them. Held messages are answered together in the bot's next reply. Further messages are dropped."`
+	OSCARHost             string        `envconfig:"OSCAR_HOST" required:"true" val:"127.0.0.1" description:"The OSCAR hostname to connect to."`
+	OSCARPort             string        `envconfig:"OSCAR_PORT" required:"true" val:"5190" description:"The OSCAR port to connect to."`
+	ReconnectMinDelay     time.Duration `envconfig:"RECONNECT_MIN_DELAY" required:"true" val:"1s" description:"How long to wait before reconnecting to the OSCAR server after the connection drops. The delay doubles after each failed attempt, with random jitter applied."`
+	ReconnectMaxDelay     time.Duration `envconfig:"RECONNECT_MAX_DELAY" required:"true" val:"2m" description:"The maximum delay between reconnection attempts."`
+	OfflineMode           bool          `envconfig:"OFFLINE_MODE" required:"false" val:"true" description:"Use a static chat bot that serves canned responses instead of an AI model for testing."`
+	Provider              string        `envconfig:"PROVIDER" required:"true" val:"openai" description:"The AI model API to use. Possible values: 'openai' (OpenAI or any OpenAI-compatible API, such as llama.cpp), 'anthropic' (Anthropic Messages API), 'ollama' (Ollama chat API)."`
+	OpenAIKey             string        `envconfig:"OPEN_AI_KEY" required:"false" val:"" description:"Key required to connect to the OpenAI API."`
+	AnthropicKey          string        `envconfig:"ANTHROPIC_API_KEY" required:"false" val:"" description:"Key required to connect to the Anthropic API."`
+	Password              string        `envconfig:"PASSWORD" required:"true" val:"" description:"The bot's account password."`
+	ScreenName            string        `envconfig:"SCREEN_NAME" required:"true" val:"smartersmarterchild" description:"The bot's screen name."`
+	WordCountLimit        int           `envconfig:"WORD_COUNT_LIMIT" required:"true" val:"25" description:"The maximum number of words sent to the bot in a single message."`
+	WordLengthLimit       int           `envconfig:"WORD_LENGTH_LIMIT" required:"true" val:"15" description:"The maximum length of any word sent to the bot in a single message."`
+	ProfileHTML           string        `envconfig:"PROFILE_HTML" required:"true" val:"'<HTML><BODY BGCOLOR=\"#CDFFFE\"><FONT FACE=\"Courier New\" COLOR=\"#000080\" LANG=\"0\">Hello, %n!<BR>Send me an IM to get started!<BR><BR>{{.ConversationsToday}} people have chatted with me today.<BR>Fact of the day: {{.Fact}}</FONT><BR><BR><HR><FONT SIZE=1>Powered by <A HREF=\"https://github.com/mk6i/smarter-smarter-child\">SmarterSmarterChild</A>.</FONT></BODY></HTML>'" description:"The bot's HTML profile information. It's a Go text/template that may use {{.ScreenName}}, {{.Persona}}, {{.Model}}, {{.Uptime}}, {{.ConversationsToday}}, {{.Conversations}}, {{.BuddiesOnline}} and {{.Fact}} (the fact of the day). Tags and attributes that AIM clients don't render are removed. AIM clients replace %n with the viewer's screen name."`
+	ProfileRefresh        time.Duration `envconfig:"PROFILE_REFRESH_INTERVAL" required:"true" val:"5m" description:"How often the bot re-renders PROFILE_HTML and republishes its profile if it changed. Set to 0s to only publish the profile at signon."`
+	PersonaName           string        `envconfig:"PERSONA_NAME" required:"true" val:"SmarterChild" description:"The name the bot goes by, shown in the profile as {{.Persona}}."`
+	PersonalizeProfiles   bool          `envconfig:"PERSONALIZE_PROFILES" required:"false" val:"false" description:"Whether the bot reads the AIM profile and away message of the users it chats with, so that it can bring up their interests."`
+	ProfileCacheTTL       time.Duration `envconfig:"PROFILE_CACHE_TTL" required:"true" val:"30m" description:"How long the bot remembers a user's profile and away message before looking them up again. Only used when PERSONALIZE_PROFILES is true."`
+	MsgFormat             string        `envconfig:"MSG_FORMAT" required:"true" val:"'<HTML><BODY BGCOLOR=\"#CDFFFE\"><FONT FACE=\"Courier New\" COLOR=\"#000080\" LANG=\"0\">@MsgContent@</FONT></BODY></HTML>'" description:"The bot's message response. @MsgContent@ will be replaced with the content of the bot's response."`
+	ChatRooms             []string      `envconfig:"CHAT_ROOMS" required:"false" val:"" description:"A comma-separated list of chat rooms to join at startup. In chat rooms, the bot only responds to messages that mention it, e.g. '@smartersmarterchild what's up?'. The bot also accepts chat room invitations."`
+	MaxChatRooms          int           `envconfig:"MAX_CHAT_ROOMS" required:"true" val:"5" description:"The maximum number of chat rooms the bot is in at once. Invitations to more rooms are declined. Rooms listed in CHAT_ROOMS count towards the limit but are always joined. Set to 0 for no limit."`
+	ChatRoomMaxMsgPerMin  int           `envconfig:"CHAT_ROOM_MAX_MSG_PER_MIN" required:"true" val:"6" description:"The maximum number of messages per minute the bot responds to in a single chat room."`
+	MaxMsgLen             int           `envconfig:"MAX_MSG_LEN" required:"true" val:"1024" description:"The maximum size in bytes of an IM sent by the bot, including the MSG_FORMAT HTML. Longer responses are split into numbered parts."`
+	MsgPartDelay          time.Duration `envconfig:"MSG_PART_DELAY" required:"true" val:"750ms" description:"How long to wait between sending the parts of a response that was split into multiple IMs."`
+	TopP                  float64       `envconfig:"TOP_P" required:"true" val:"0.5" description:"The top-p value to use when querying the AI model. Not used by the Anthropic provider."`
+	Temperature           float64       `envconfig:"TEMPERATURE" required:"true" val:"0.7" description:"The temperature value to use when querying the AI model."`
+	Model                 string        `envconfig:"MODEL" required:"true" val:"'gpt-4o-mini'" description:"The AI model to use."`
+	APITimeout            time.Duration `envconfig:"API_TIMEOUT" required:"true" val:"30s" description:"The maximum time to wait for a single AI model API request to finish, including reading a streamed reply."`
+	ResponseTimeout       time.Duration `envconfig:"RESPONSE_TIMEOUT" required:"true" val:"60s" description:"The maximum time the bot spends coming up with a reply, including retries, fallbacks and tool calls. When exceeded, the bot gives up and asks the user to try again."`
+	RetryAttempts         int           `envconfig:"RETRY_ATTEMPTS" required:"true" val:"3" description:"The maximum number of times a request to the AI model API is made when it fails with a temporary error, such as a rate limit or server error."`
+	RetryBaseDelay        time.Duration `envconfig:"RETRY_BASE_DELAY" required:"true" val:"500ms" description:"How long to wait before retrying a failed AI model API request. The delay doubles after each attempt, unless the API says how long to wait."`
+	RetryMaxDelay         time.Duration `envconfig:"RETRY_MAX_DELAY" required:"true" val:"10s" description:"The longest the bot waits before retrying a failed AI model API request. Requests the API asks to delay for longer are not retried."`
+	FallbackProvider      string        `envconfig:"FALLBACK_PROVIDER" required:"false" val:"" description:"A second AI model API to use when the primary one fails. Takes the same values as PROVIDER. If empty, there's no secondary AI model."`
+	FallbackModel         string        `envconfig:"FALLBACK_MODEL" required:"false" val:"" description:"The AI model to use with FALLBACK_PROVIDER."`
+	FallbackAPIUrl        string        `envconfig:"FALLBACK_API_URL" required:"false" val:"" description:"The API URL of FALLBACK_PROVIDER. If empty, the provider's default URL is used."`
+	FallbackStatic        bool          `envconfig:"FALLBACK_STATIC" required:"false" val:"false" description:"Fall back to canned responses when all AI models fail."`
+	CircuitFailures       int           `envconfig:"CIRCUIT_FAILURES" required:"true" val:"3" description:"The number of consecutive failures after which the bot stops calling an AI model API, so that it falls back to the next one without delay."`
+	CircuitCooldown       time.Duration `envconfig:"CIRCUIT_COOLDOWN" required:"true" val:"1m" description:"How long to wait before trying an AI model API again after it was taken out of rotation."`
+	FailureReply          string        `envconfig:"FAILURE_REPLY" required:"true" val:"'Uh oh, my brain is a little fried right now. Try me again in a bit!'" description:"The reply sent when the bot can't come up with a response."`
+	MaxTokens             int           `envconfig:"MAX_TOKENS" required:"true" val:"1024" description:"The maximum number of tokens the AI model may generate in a single reply. Only used by the Anthropic provider."`
+	BotPrompt             string        `envconfig:"BOT_PROMPT" required:"true" val:"'You are SmarterChild, a dumb AIM chatbot.'" description:"The initial prompt to the AI model when creating a new conversation."`
+	StreamResponses       bool          `envconfig:"STREAM_RESPONSES" required:"false" val:"false" description:"Stream the bot's response from the AI model API and send each complete sentence or paragraph as a separate IM as soon as it's ready."`
+	TypingWait            time.Duration `envconfig:"TYPING_WAIT" required:"true" val:"1s" description:"How long the bot waits after a message, or after the user stops typing, before it replies. Messages the user sends in the meantime are answered together. Set to 0s to reply right away."`
+	TypingMaxWait         time.Duration `envconfig:"TYPING_MAX_WAIT" required:"true" val:"30s" description:"The longest the bot waits for a user who is still typing before it replies."`
+	TypingDelayPerChar    time.Duration `envconfig:"TYPING_DELAY_PER_CHAR" required:"true" val:"0s" description:"How long the bot pretends to type each character of a reply, so that long replies take longer to arrive. Time spent waiting on the AI model counts towards it. Not used when STREAM_RESPONSES is true. Set to 0s to send replies as soon as they are ready."`
+	TypingDelayMax        time.Duration `envconfig:"TYPING_DELAY_MAX" required:"true" val:"5s" description:"The longest the bot pretends to type a reply."`
+	HistoryTurns          int           `envconfig:"HISTORY_TURNS" required:"true" val:"5" description:"The number of previous message exchanges with a user that are kept and sent to the bot as conversation context. Must be at least 1."`
+	ConversationStoreFile string        `envconfig:"CONVERSATION_STORE_FILE" required:"false" val:"" description:"Path to a database file where conversation history is saved so that users can pick up where they left off after a restart. If empty, history is kept in memory only."`
+	Tools                 []string      `envconfig:"TOOLS" required:"false" val:"time,calculator,dictionary,reminder,buddy_info" description:"A comma-separated list of tools the AI model may use while composing a reply. Possible values: 'time', 'calculator', 'dictionary', 'reminder', 'buddy_info'. Leave empty to disable tool use."`
+	MaxToolIterations     int           `envconfig:"MAX_TOOL_ITERATIONS" required:"true" val:"3" description:"The maximum number of rounds of tool calls the AI model may make before it must reply."`
+	DictionaryURL         string        `envconfig:"DICTIONARY_URL" required:"false" val:"'https://api.dictionaryapi.dev/api/v2/entries/en/%s'" description:"URL of the dictionary service used by the dictionary tool. %s is replaced with the word to look up."`
+	WeatherURL            string        `envconfig:"WEATHER_URL" required:"false" val:"'https://wttr.in/%s?format=3'" description:"URL of the weather service used by the /weather command. %s is replaced with the location the user asked about. If empty, /weather is disabled."`
+	UsageLedgerFile       string        `envconfig:"USAGE_LEDGER_FILE" required:"false" val:"" description:"Path to a database file where AI model usage is saved so that budgets survive a restart. If empty, usage is kept in memory only."`
+	BudgetUnit            string        `envconfig:"BUDGET_UNIT" required:"true" val:"tokens" description:"The unit of the budgets. Possible values: 'tokens' (prompt and completion tokens), 'usd' (US dollars, priced with MODEL_PRICES)."`
+	UserDailyBudget       float64       `envconfig:"USER_DAILY_BUDGET" required:"true" val:"0" description:"How much AI model usage a single user may consume per day, in BUDGET_UNIT. 0 means unlimited."`
+	UserMonthlyBudget     float64       `envconfig:"USER_MONTHLY_BUDGET" required:"true" val:"0" description:"How much AI model usage a single user may consume per month, in BUDGET_UNIT. 0 means unlimited."`
+	DailyBudget           float64       `envconfig:"DAILY_BUDGET" required:"true" val:"0" description:"How much AI model usage all users combined may consume per day, in BUDGET_UNIT. 0 means unlimited."`
+	MonthlyBudget         float64       `envconfig:"MONTHLY_BUDGET" required:"true" val:"0" description:"How much AI model usage all users combined may consume per month, in BUDGET_UNIT. 0 means unlimited."`
+	ModelPrices           []string      `envconfig:"MODEL_PRICES" required:"false" val:"gpt-4o-mini=0.15/0.60,gpt-4o=2.50/10.00,claude-3-5-haiku=0.80/4.00,claude-3-5-sonnet=3.00/15.00" description:"A comma-separated list of AI model prices in the form 'model=prompt/completion', where prompt and completion are US dollars per million tokens. Used to price usage when BUDGET_UNIT is 'usd'. Models that aren't listed are free."`
+	MetricsAddr           string        `envconfig:"METRICS_ADDR" required:"false" val:"" description:"The address to serve Prometheus metrics on at /metrics, e.g. '127.0.0.1:9090'. If empty, metrics are not served."`
+	AdminAddr             string        `envconfig:"ADMIN_ADDR" required:"false" val:"127.0.0.1:8081" description:"The address to serve the admin API and dashboard on. Defaults to 127.0.0.1:8081 if empty. Bind to a non-local address with care."`
+	AdminToken            string        `envconfig:"ADMIN_TOKEN" required:"false" val:"" description:"The token that admin API requests must carry, as a bearer token or as the basic auth password when opening the dashboard in a browser. If empty, the admin server is disabled."`
+	AdminScreenNames      []string      `envconfig:"ADMIN_SCREEN_NAMES" required:"false" val:"" description:"A comma-separated list of screen names that may administer the bot over IM with admin commands, e.g. '!takeover someuser'. Admins are exempt from the message rate limit."`
+	AdminCommandPrefix    string        `envconfig:"ADMIN_COMMAND_PREFIX" required:"true" val:"!" description:"The prefix of admin commands sent over IM. Type the prefix followed by 'help' to list them."`
+	AccessListFile        string        `envconfig:"ACCESS_LIST_FILE" required:"false" val:"" description:"Path to a file where the allow and deny lists are saved so that bans survive a restart. If empty, the lists are kept in memory only."`
+	AllowList             []string      `envconfig:"ALLOW_LIST" required:"false" val:"" description:"A comma-separated list of screen names added to the allow list at startup. If the allow list isn't empty, the bot ignores everyone else except admins."`
+	DenyList              []string      `envconfig:"DENY_LIST" required:"false" val:"" description:"A comma-separated list of screen names added to the deny list at startup. The bot ignores users on the deny list and blocks them on the OSCAR server so that they can't IM it."`
+	AutoAddBuddies        bool          `envconfig:"AUTO_ADD_BUDDIES" required:"false" val:"false" description:"Add users who IM the bot to its buddy list so that it knows when they're online, away or idle."`
+	BuddyGroup            string        `envconfig:"BUDDY_GROUP" required:"true" val:"Buddies" description:"The buddy list group that users are added to."`
+	SubscriptionsFile     string        `envconfig:"SUBSCRIPTIONS_FILE" required:"false" val:"" description:"Path to a file where the users who typed /subscribe are saved. If empty, subscriptions are kept in memory only."`
+	WelcomeBackInterval   time.Duration `envconfig:"WELCOME_BACK_INTERVAL" required:"true" val:"12h" description:"The minimum time between two welcome back greetings to the same user."`
+	WelcomeBackMessage    string        `envconfig:"WELCOME_BACK_MESSAGE" required:"false" val:"'Welcome back, %s! Wanna chat?'" description:"The greeting sent to users who typed /subscribe when they sign on. %s is replaced with the user's screen name. If empty, the AI model comes up with a greeting."`
+	UnhealthyAwayMessage  string        `envconfig:"UNHEALTHY_AWAY_MESSAGE" required:"false" val:"'My brain is a little fried right now. Try me again in a bit!'" description:"The away message the bot shows while it can't reach any AI model, so that users know before they IM it. If empty, the bot doesn't go away."`
+	MaintenanceWindow     string        `envconfig:"MAINTENANCE_WINDOW" required:"false" val:"" description:"A daily time range in local time during which the bot shows MAINTENANCE_AWAY_MESSAGE, e.g. '02:00-03:30'. If empty, there's no maintenance window."`
+	MaintenanceAwayMsg    string        `envconfig:"MAINTENANCE_AWAY_MESSAGE" required:"false" val:"'Down for scheduled maintenance. Be back soon!'" description:"The away message the bot shows during MAINTENANCE_WINDOW."`
+	IdleAfter             time.Duration `envconfig:"IDLE_AFTER" required:"true" val:"0s" description:"How long the bot goes without receiving a message before it shows up as idle. 0 means never."`
+	APIUrl                string        `envconfig:"API_URL" required:"false" val:"" description:"The AI model API URL. If empty, the default URL for the selected PROVIDER is used."`
 }
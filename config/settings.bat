@@ -5,19 +5,40 @@ rem Specifies the maximum number of messages a user can send to the bot per
 rem minute before rate limiting is applied.
 set MAX_MSG_PER_MIN=10
 
+rem The maximum number of messages from a user that the bot holds on to while it
+rem is replying to them. Held messages are answered together in the bot's next
+rem reply. Further messages are dropped.
+set MAX_QUEUED_MSGS=5
+
 rem The OSCAR hostname to connect to.
 set OSCAR_HOST=127.0.0.1
 
 rem The OSCAR port to connect to.
 set OSCAR_PORT=5190
 
-rem Use a static chat bot that serves canned responses instead of OpenAI for
-rem testing.
+rem How long to wait before reconnecting to the OSCAR server after the
+rem connection drops. The delay doubles after each failed attempt, with random
+rem jitter applied.
+set RECONNECT_MIN_DELAY=1s
+
+rem The maximum delay between reconnection attempts.
+set RECONNECT_MAX_DELAY=2m
+
+rem Use a static chat bot that serves canned responses instead of an AI model
+rem for testing.
 set OFFLINE_MODE=true
 
+rem The AI model API to use. Possible values: 'openai' (OpenAI or any
+rem OpenAI-compatible API, such as llama.cpp), 'anthropic' (Anthropic Messages
+rem API), 'ollama' (Ollama chat API).
+set PROVIDER=openai
+
 rem Key required to connect to the OpenAI API.
 set OPEN_AI_KEY=
 
+rem Key required to connect to the Anthropic API.
+set ANTHROPIC_API_KEY=
+
 rem The bot's account password.
 set PASSWORD=
 
@@ -30,25 +51,265 @@ set WORD_COUNT_LIMIT=25
 rem The maximum length of any word sent to the bot in a single message.
 set WORD_LENGTH_LIMIT=15
 
-rem The bot's HTML profile information.
-set PROFILE_HTML='<HTML><BODY BGCOLOR="#CDFFFE"><FONT FACE="Courier New" COLOR="#000080" LANG="0">Hello, %n!<BR>Send me an IM to get started!</FONT><BR><BR><HR><FONT SIZE=1>Powered by <A HREF="https://github.com/mk6i/smarter-smarter-child">SmarterSmarterChild</A>.</FONT></BODY></HTML>'
+rem The bot's HTML profile information. It's a Go text/template that may use
+rem {{.ScreenName}}, {{.Persona}}, {{.Model}}, {{.Uptime}},
+rem {{.ConversationsToday}}, {{.Conversations}}, {{.BuddiesOnline}} and
+rem {{.Fact}} (the fact of the day). Tags and attributes that AIM clients don't
+rem render are removed. AIM clients replace %n with the viewer's screen name.
+set PROFILE_HTML='<HTML><BODY BGCOLOR="#CDFFFE"><FONT FACE="Courier New" COLOR="#000080" LANG="0">Hello, %n!<BR>Send me an IM to get started!<BR><BR>{{.ConversationsToday}} people have chatted with me today.<BR>Fact of the day: {{.Fact}}</FONT><BR><BR><HR><FONT SIZE=1>Powered by <A HREF="https://github.com/mk6i/smarter-smarter-child">SmarterSmarterChild</A>.</FONT></BODY></HTML>'
+
+rem How often the bot re-renders PROFILE_HTML and republishes its profile if it
+rem changed. Set to 0s to only publish the profile at signon.
+set PROFILE_REFRESH_INTERVAL=5m
+
+rem The name the bot goes by, shown in the profile as {{.Persona}}.
+set PERSONA_NAME=SmarterChild
+
+rem Whether the bot reads the AIM profile and away message of the users it chats
+rem with, so that it can bring up their interests.
+set PERSONALIZE_PROFILES=false
+
+rem How long the bot remembers a user's profile and away message before looking
+rem them up again. Only used when PERSONALIZE_PROFILES is true.
+set PROFILE_CACHE_TTL=30m
 
 rem The bot's message response. @MsgContent@ will be replaced with the content
 rem of the bot's response.
 set MSG_FORMAT='<HTML><BODY BGCOLOR="#CDFFFE"><FONT FACE="Courier New" COLOR="#000080" LANG="0">@MsgContent@</FONT></BODY></HTML>'
 
-rem The top-p value to use when querying the OpenAI API.
+rem A comma-separated list of chat rooms to join at startup. In chat rooms, the
+rem bot only responds to messages that mention it, e.g. '@smartersmarterchild
+rem what's up?'. The bot also accepts chat room invitations.
+set CHAT_ROOMS=
+
+rem The maximum number of chat rooms the bot is in at once. Invitations to more
+rem rooms are declined. Rooms listed in CHAT_ROOMS count towards the limit but
+rem are always joined. Set to 0 for no limit.
+set MAX_CHAT_ROOMS=5
+
+rem The maximum number of messages per minute the bot responds to in a single
+rem chat room.
+set CHAT_ROOM_MAX_MSG_PER_MIN=6
+
+rem The maximum size in bytes of an IM sent by the bot, including the MSG_FORMAT
+rem HTML. Longer responses are split into numbered parts.
+set MAX_MSG_LEN=1024
+
+rem How long to wait between sending the parts of a response that was split into
+rem multiple IMs.
+set MSG_PART_DELAY=750ms
+
+rem The top-p value to use when querying the AI model. Not used by the Anthropic
+rem provider.
 set TOP_P=0.5
 
-rem The temperature value to use when querying the OpenAI API.
+rem The temperature value to use when querying the AI model.
 set TEMPERATURE=0.7
 
 rem The AI model to use.
 set MODEL='gpt-4o-mini'
 
-rem The initial prompt to the OpenAI API when creating a new conversation.
+rem The maximum time to wait for a single AI model API request to finish,
+rem including reading a streamed reply.
+set API_TIMEOUT=30s
+
+rem The maximum time the bot spends coming up with a reply, including retries,
+rem fallbacks and tool calls. When exceeded, the bot gives up and asks the user
+rem to try again.
+set RESPONSE_TIMEOUT=60s
+
+rem The maximum number of times a request to the AI model API is made when it
+rem fails with a temporary error, such as a rate limit or server error.
+set RETRY_ATTEMPTS=3
+
+rem How long to wait before retrying a failed AI model API request. The delay
+rem doubles after each attempt, unless the API says how long to wait.
+set RETRY_BASE_DELAY=500ms
+
+rem The longest the bot waits before retrying a failed AI model API request.
+rem Requests the API asks to delay for longer are not retried.
+set RETRY_MAX_DELAY=10s
+
+rem A second AI model API to use when the primary one fails. Takes the same
+rem values as PROVIDER. If empty, there's no secondary AI model.
+set FALLBACK_PROVIDER=
+
+rem The AI model to use with FALLBACK_PROVIDER.
+set FALLBACK_MODEL=
+
+rem The API URL of FALLBACK_PROVIDER. If empty, the provider's default URL is
+rem used.
+set FALLBACK_API_URL=
+
+rem Fall back to canned responses when all AI models fail.
+set FALLBACK_STATIC=false
+
+rem The number of consecutive failures after which the bot stops calling an AI
+rem model API, so that it falls back to the next one without delay.
+set CIRCUIT_FAILURES=3
+
+rem How long to wait before trying an AI model API again after it was taken out
+rem of rotation.
+set CIRCUIT_COOLDOWN=1m
+
+rem The reply sent when the bot can't come up with a response.
+set FAILURE_REPLY='Uh oh, my brain is a little fried right now. Try me again in a bit!'
+
+rem The maximum number of tokens the AI model may generate in a single reply.
+rem Only used by the Anthropic provider.
+set MAX_TOKENS=1024
+
+rem The initial prompt to the AI model when creating a new conversation.
 set BOT_PROMPT='You are SmarterChild, a dumb AIM chatbot.'
 
-rem OpenAI API URL.
-set API_URL='https://api.openai.com/v1/chat/completions'
+rem Stream the bot's response from the AI model API and send each complete
+rem sentence or paragraph as a separate IM as soon as it's ready.
+set STREAM_RESPONSES=false
+
+rem How long the bot waits after a message, or after the user stops typing,
+rem before it replies. Messages the user sends in the meantime are answered
+rem together. Set to 0s to reply right away.
+set TYPING_WAIT=1s
+
+rem The longest the bot waits for a user who is still typing before it replies.
+set TYPING_MAX_WAIT=30s
+
+rem How long the bot pretends to type each character of a reply, so that long
+rem replies take longer to arrive. Time spent waiting on the AI model counts
+rem towards it. Not used when STREAM_RESPONSES is true. Set to 0s to send
+rem replies as soon as they are ready.
+set TYPING_DELAY_PER_CHAR=0s
+
+rem The longest the bot pretends to type a reply.
+set TYPING_DELAY_MAX=5s
+
+rem The number of previous message exchanges with a user that are kept and sent
+rem to the bot as conversation context. Must be at least 1.
+set HISTORY_TURNS=5
+
+rem Path to a database file where conversation history is saved so that users
+rem can pick up where they left off after a restart. If empty, history is kept
+rem in memory only.
+set CONVERSATION_STORE_FILE=
+
+rem A comma-separated list of tools the AI model may use while composing a
+rem reply. Possible values: 'time', 'calculator', 'dictionary', 'reminder',
+rem 'buddy_info'. Leave empty to disable tool use.
+set TOOLS=time,calculator,dictionary,reminder,buddy_info
+
+rem The maximum number of rounds of tool calls the AI model may make before it
+rem must reply.
+set MAX_TOOL_ITERATIONS=3
+
+rem URL of the dictionary service used by the dictionary tool. %s is replaced
+rem with the word to look up.
+set DICTIONARY_URL='https://api.dictionaryapi.dev/api/v2/entries/en/%s'
+
+rem URL of the weather service used by the /weather command. %s is replaced with
+rem the location the user asked about. If empty, /weather is disabled.
+set WEATHER_URL='https://wttr.in/%s?format=3'
+
+rem Path to a database file where AI model usage is saved so that budgets
+rem survive a restart. If empty, usage is kept in memory only.
+set USAGE_LEDGER_FILE=
+
+rem The unit of the budgets. Possible values: 'tokens' (prompt and completion
+rem tokens), 'usd' (US dollars, priced with MODEL_PRICES).
+set BUDGET_UNIT=tokens
+
+rem How much AI model usage a single user may consume per day, in BUDGET_UNIT. 0
+rem means unlimited.
+set USER_DAILY_BUDGET=0
+
+rem How much AI model usage a single user may consume per month, in BUDGET_UNIT.
+rem 0 means unlimited.
+set USER_MONTHLY_BUDGET=0
+
+rem How much AI model usage all users combined may consume per day, in
+rem BUDGET_UNIT. 0 means unlimited.
+set DAILY_BUDGET=0
+
+rem How much AI model usage all users combined may consume per month, in
+rem BUDGET_UNIT. 0 means unlimited.
+set MONTHLY_BUDGET=0
+
+rem A comma-separated list of AI model prices in the form
+rem 'model=prompt/completion', where prompt and completion are US dollars per
+rem million tokens. Used to price usage when BUDGET_UNIT is 'usd'. Models that
+rem aren't listed are free.
+set MODEL_PRICES=gpt-4o-mini=0.15/0.60,gpt-4o=2.50/10.00,claude-3-5-haiku=0.80/4.00,claude-3-5-sonnet=3.00/15.00
+
+rem The address to serve Prometheus metrics on at /metrics, e.g.
+rem '127.0.0.1:9090'. If empty, metrics are not served.
+set METRICS_ADDR=
+
+rem The address to serve the admin API and dashboard on. Defaults to
+rem 127.0.0.1:8081 if empty. Bind to a non-local address with care.
+set ADMIN_ADDR=127.0.0.1:8081
+
+rem The token that admin API requests must carry, as a bearer token or as the
+rem basic auth password when opening the dashboard in a browser. If empty, the
+rem admin server is disabled.
+set ADMIN_TOKEN=
+
+rem A comma-separated list of screen names that may administer the bot over IM
+rem with admin commands, e.g. '!takeover someuser'. Admins are exempt from the
+rem message rate limit.
+set ADMIN_SCREEN_NAMES=
+
+rem The prefix of admin commands sent over IM. Type the prefix followed by
+rem 'help' to list them.
+set ADMIN_COMMAND_PREFIX=!
+
+rem Path to a file where the allow and deny lists are saved so that bans survive
+rem a restart. If empty, the lists are kept in memory only.
+set ACCESS_LIST_FILE=
+
+rem A comma-separated list of screen names added to the allow list at startup.
+rem If the allow list isn't empty, the bot ignores everyone else except admins.
+set ALLOW_LIST=
+
+rem A comma-separated list of screen names added to the deny list at startup.
+rem The bot ignores users on the deny list and blocks them on the OSCAR server
+rem so that they can't IM it.
+set DENY_LIST=
+
+rem Add users who IM the bot to its buddy list so that it knows when they're
+rem online, away or idle.
+set AUTO_ADD_BUDDIES=false
+
+rem The buddy list group that users are added to.
+set BUDDY_GROUP=Buddies
+
+rem Path to a file where the users who typed /subscribe are saved. If empty,
+rem subscriptions are kept in memory only.
+set SUBSCRIPTIONS_FILE=
+
+rem The minimum time between two welcome back greetings to the same user.
+set WELCOME_BACK_INTERVAL=12h
+
+rem The greeting sent to users who typed /subscribe when they sign on. %s is
+rem replaced with the user's screen name. If empty, the AI model comes up with a
+rem greeting.
+set WELCOME_BACK_MESSAGE='Welcome back, %s! Wanna chat?'
+
+rem The away message the bot shows while it can't reach any AI model, so that
+rem users know before they IM it. If empty, the bot doesn't go away.
+set UNHEALTHY_AWAY_MESSAGE='My brain is a little fried right now. Try me again in a bit!'
+
+rem A daily time range in local time during which the bot shows
+rem MAINTENANCE_AWAY_MESSAGE, e.g. '02:00-03:30'. If empty, there's no
+rem maintenance window.
+set MAINTENANCE_WINDOW=
+
+rem The away message the bot shows during MAINTENANCE_WINDOW.
+set MAINTENANCE_AWAY_MESSAGE='Down for scheduled maintenance. Be back soon!'
+
+rem How long the bot goes without receiving a message before it shows up as
+rem idle. 0 means never.
+set IDLE_AFTER=0s
+
+rem The AI model API URL. If empty, the default URL for the selected PROVIDER is
+rem used.
+set API_URL=
 
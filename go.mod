@@ -6,5 +6,8 @@ require (
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/mitchellh/go-wordwrap v1.0.1
 	github.com/mk6i/retro-aim-server v0.8.1-0.20240712013152-966f11528705
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/time v0.5.0
 )
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,317 @@
+// Package metrics implements counters, histograms and gauges that are exposed
+// over HTTP in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry that the package-level constructors add metrics
+// to.
+var Default = NewRegistry()
+
+// DefaultBuckets are histogram buckets suited to measuring latencies in
+// seconds, from 50ms to 1m.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metric is a named metric that can write its samples in the Prometheus text
+// format.
+type metric interface {
+	describe() (kind string, help string)
+	write(w io.Writer, name string)
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Registry holds a set of metrics by name. It's an http.Handler that serves
+// the metrics in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// register adds m to the registry. It panics if a metric named name already
+// exists, unless replace is set.
+func (r *Registry) register(name string, m metric, replace bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists && !replace {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// NewCounter adds a counter partitioned by the given label names to the
+// registry.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{help: help, vec: newVec[float64](labels)}
+	r.register(name, c, false)
+	return c
+}
+
+// NewHistogram adds a histogram with the given bucket upper bounds,
+// partitioned by the given label names, to the registry.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{help: help, buckets: buckets, vec: newVec[histogramValue](labels)}
+	r.register(name, h, false)
+	return h
+}
+
+// NewGaugeFunc adds a gauge whose value is read from fn whenever the metrics
+// are collected. An existing func metric with the same name is replaced.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{kind: "gauge", help: help, fn: fn}, true)
+}
+
+// NewCounterFunc adds a counter whose value is read from fn whenever the
+// metrics are collected. An existing func metric with the same name is
+// replaced.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{kind: "counter", help: help, fn: fn}, true)
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]metric, len(r.metrics))
+	for name, m := range r.metrics {
+		metrics[name] = m
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		m := metrics[name]
+		kind, help := m.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		m.write(w, name)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// NewCounter adds a counter to the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewHistogram adds a histogram to the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewGaugeFunc adds a func gauge to the default registry.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+// NewCounterFunc adds a func counter to the default registry.
+func NewCounterFunc(name, help string, fn func() float64) {
+	Default.NewCounterFunc(name, help, fn)
+}
+
+// vec holds one series per combination of label values.
+type vec[T any] struct {
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	labelValues []string
+	value       T
+}
+
+func newVec[T any](labels []string) vec[T] {
+	return vec[T]{labels: labels, series: make(map[string]*series[T])}
+}
+
+// with calls fn with the series for labelValues, creating it if necessary. It
+// panics if the number of label values is wrong.
+func (v *vec[T]) with(labelValues []string, fn func(value *T)) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("got %d label values, want %d", len(labelValues), len(v.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	fn(&s.value)
+}
+
+// each calls fn with each series, sorted by label values.
+func (v *vec[T]) each(fn func(labelValues []string, value T)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := v.series[key]
+		fn(s.labelValues, s.value)
+	}
+}
+
+// Counter is a count that only goes up, partitioned by label values.
+type Counter struct {
+	help string
+	vec  vec[float64]
+}
+
+// Inc adds 1 to the series identified by labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the series identified by
+// labelValues.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.vec.with(labelValues, func(value *float64) {
+		*value += delta
+	})
+}
+
+func (c *Counter) describe() (string, string) {
+	return "counter", c.help
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	c.vec.each(func(labelValues []string, value float64) {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.vec.labels, labelValues), formatValue(value))
+	})
+}
+
+// Histogram counts observations in buckets, partitioned by label values.
+type Histogram struct {
+	help    string
+	buckets []float64
+	vec     vec[histogramValue]
+}
+
+type histogramValue struct {
+	// counts holds the number of observations in each bucket, not
+	// cumulative.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v in the series identified by labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.vec.with(labelValues, func(value *histogramValue) {
+		if value.counts == nil {
+			value.counts = make([]uint64, len(h.buckets))
+		}
+		if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+			value.counts[i]++
+		}
+		value.count++
+		value.sum += v
+	})
+}
+
+func (h *Histogram) describe() (string, string) {
+	return "histogram", h.help
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	labels := append(append([]string(nil), h.vec.labels...), "le")
+	h.vec.each(func(labelValues []string, value histogramValue) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name,
+				formatLabels(labels, append(append([]string(nil), labelValues...), formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, append(append([]string(nil), labelValues...), "+Inf")), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(h.vec.labels, labelValues), formatValue(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(h.vec.labels, labelValues), value.count)
+	})
+}
+
+// funcMetric is a gauge or counter whose value is read on demand.
+type funcMetric struct {
+	kind string
+	help string
+	fn   func() float64
+}
+
+func (m *funcMetric) describe() (string, string) {
+	return m.kind, m.help
+}
+
+func (m *funcMetric) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatValue(m.fn()))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	msgs := r.NewCounter("msgs_total", "Messages received.\nBy channel.", "channel", "reason")
+	msgs.Inc("im", "")
+	msgs.Add(2, "chat", `say "hi"`)
+	msgs.Inc("chat", `back\slash`)
+	msgs.Inc("chat", "new\nline")
+	msgs.Add(-1, "im", "") // counters never go down
+
+	latency := r.NewHistogram("latency_seconds", `Reply latency, in C:\seconds.`, []float64{1, 0.5, 2.5}, "backend")
+	for _, v := range []float64{0.1, 0.5, 0.7, 2, 100} {
+		latency.Observe(v, "primary")
+	}
+	latency.Observe(3, "secondary")
+
+	r.NewGaugeFunc("buddies_online", "Buddies online.", func() float64 { return 3 })
+	r.NewCounterFunc("reconnects_total", "Reconnects.", func() float64 { return 1e6 })
+	r.NewGaugeFunc("up_since", "Never.", func() float64 { return math.Inf(-1) })
+
+	want := `# HELP buddies_online Buddies online.
+# TYPE buddies_online gauge
+buddies_online 3
+# HELP latency_seconds Reply latency, in C:\\seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{backend="primary",le="0.5"} 2
+latency_seconds_bucket{backend="primary",le="1"} 3
+latency_seconds_bucket{backend="primary",le="2.5"} 4
+latency_seconds_bucket{backend="primary",le="+Inf"} 5
+latency_seconds_sum{backend="primary"} 103.3
+latency_seconds_count{backend="primary"} 5
+latency_seconds_bucket{backend="secondary",le="0.5"} 0
+latency_seconds_bucket{backend="secondary",le="1"} 0
+latency_seconds_bucket{backend="secondary",le="2.5"} 0
+latency_seconds_bucket{backend="secondary",le="+Inf"} 1
+latency_seconds_sum{backend="secondary"} 3
+latency_seconds_count{backend="secondary"} 1
+# HELP msgs_total Messages received.\nBy channel.
+# TYPE msgs_total counter
+msgs_total{channel="chat",reason="back\\slash"} 1
+msgs_total{channel="chat",reason="new\nline"} 1
+msgs_total{channel="chat",reason="say \"hi\""} 2
+msgs_total{channel="im",reason=""} 1
+# HELP reconnects_total Reconnects.
+# TYPE reconnects_total counter
+reconnects_total 1e+06
+# HELP up_since Never.
+# TYPE up_since gauge
+up_since -Inf
+`
+
+	var sb strings.Builder
+	r.Write(&sb)
+	if got := sb.String(); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("msgs_total", "Messages received.").Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got, want := w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("Content-Type = %q, want %q", got, want)
+	}
+	if got, want := w.Body.String(), "# HELP msgs_total Messages received.\n# TYPE msgs_total counter\nmsgs_total 1\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestRegistryRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "Up.", func() float64 { return 0 })
+	// func metrics are replaced
+	r.NewGaugeFunc("up", "Up.", func() float64 { return 1 })
+
+	var sb strings.Builder
+	r.Write(&sb)
+	if !strings.HasSuffix(sb.String(), "up 1\n") {
+		t.Errorf("func metric wasn't replaced:\n%s", sb.String())
+	}
+
+	r.NewCounter("msgs_total", "Messages received.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a counter twice didn't panic")
+		}
+	}()
+	r.NewCounter("msgs_total", "Messages received.")
+}
+
+func TestCounterWrongLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounter("msgs_total", "Messages received.", "channel")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with the wrong number of label values didn't panic")
+		}
+	}()
+	c.Inc("im", "extra")
+}
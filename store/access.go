@@ -0,0 +1,137 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// accessData maps normalized screen names to the screen name as added.
+type accessData struct {
+	Allow map[string]string `json:"allow"`
+	Deny  map[string]string `json:"deny"`
+}
+
+// NewAccessList creates an AccessList that persists to a JSON file at path.
+// Existing entries are loaded from the file if it exists. If path is empty,
+// the lists are kept in memory only.
+func NewAccessList(path string) (*AccessList, error) {
+	l := &AccessList{
+		path: path,
+		data: accessData{
+			Allow: make(map[string]string),
+			Deny:  make(map[string]string),
+		},
+	}
+	if path == "" {
+		return l, nil
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return l, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read access list file: %w", err)
+	}
+	if err := json.Unmarshal(b, &l.data); err != nil {
+		return nil, fmt.Errorf("unable to parse access list file: %w", err)
+	}
+	if l.data.Allow == nil {
+		l.data.Allow = make(map[string]string)
+	}
+	if l.data.Deny == nil {
+		l.data.Deny = make(map[string]string)
+	}
+	return l, nil
+}
+
+// AccessList holds the screen names that are allowed to talk to the bot and
+// the screen names that are denied. Screen names are compared ignoring case
+// and spacing.
+type AccessList struct {
+	path string
+	mu   sync.RWMutex
+	data accessData
+}
+
+// Allow adds screenName to the allow list.
+func (l *AccessList) Allow(screenName string) error {
+	return l.update(l.data.Allow, screenName, true)
+}
+
+// Disallow removes screenName from the allow list.
+func (l *AccessList) Disallow(screenName string) error {
+	return l.update(l.data.Allow, screenName, false)
+}
+
+// Deny adds screenName to the deny list.
+func (l *AccessList) Deny(screenName string) error {
+	return l.update(l.data.Deny, screenName, true)
+}
+
+// Undeny removes screenName from the deny list.
+func (l *AccessList) Undeny(screenName string) error {
+	return l.update(l.data.Deny, screenName, false)
+}
+
+// IsAllowed reports whether screenName is on the allow list.
+func (l *AccessList) IsAllowed(screenName string) bool {
+	return l.contains(l.data.Allow, screenName)
+}
+
+// IsDenied reports whether screenName is on the deny list.
+func (l *AccessList) IsDenied(screenName string) bool {
+	return l.contains(l.data.Deny, screenName)
+}
+
+// Allowed returns the allow list, sorted.
+func (l *AccessList) Allowed() []string {
+	return l.list(l.data.Allow)
+}
+
+// Denied returns the deny list, sorted.
+func (l *AccessList) Denied() []string {
+	return l.list(l.data.Deny)
+}
+
+// update adds screenName to or removes it from names and persists the
+// change to disk.
+func (l *AccessList) update(names map[string]string, screenName string, add bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := NormalizeScreenName(screenName)
+	_, exists := names[key]
+	if add == exists {
+		return nil
+	}
+	if add {
+		names[key] = screenName
+	} else {
+		delete(names, key)
+	}
+	if l.path == "" {
+		return nil
+	}
+	return writeJSONFile(l.path, l.data)
+}
+
+func (l *AccessList) contains(names map[string]string, screenName string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := names[NormalizeScreenName(screenName)]
+	return ok
+}
+
+func (l *AccessList) list(names map[string]string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
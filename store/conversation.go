@@ -0,0 +1,188 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Turn is a single exchange between a user and the bot.
+type Turn struct {
+	// User is the message received from the user.
+	User string `json:"user"`
+	// Bot is the bot's response.
+	Bot string `json:"bot"`
+	// Time is when the exchange happened.
+	Time time.Time `json:"time"`
+}
+
+// NormalizeScreenName returns the canonical form of a screen name. AIM screen
+// names are case-insensitive and ignore spaces.
+func NormalizeScreenName(screenName string) string {
+	return strings.ToLower(strings.ReplaceAll(screenName, " ", ""))
+}
+
+// NewInMemoryConversationStore creates a conversation store that keeps up to
+// maxTurns turns per screen name in memory. If maxTurns is 0, history isn't
+// trimmed.
+func NewInMemoryConversationStore(maxTurns int) *InMemoryConversationStore {
+	return &InMemoryConversationStore{
+		maxTurns:      maxTurns,
+		conversations: make(map[string][]Turn),
+	}
+}
+
+// InMemoryConversationStore keeps a rolling window of conversation history
+// per screen name. History is lost when the process exits.
+type InMemoryConversationStore struct {
+	maxTurns      int
+	mu            sync.Mutex
+	conversations map[string][]Turn
+}
+
+// History returns the conversation history for screenName, oldest turn first.
+func (s *InMemoryConversationStore) History(screenName string) ([]Turn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	turns := s.conversations[NormalizeScreenName(screenName)]
+	return append([]Turn(nil), turns...), nil
+}
+
+// Append adds a turn to the conversation history for screenName, discarding
+// the oldest turns once the history exceeds maxTurns.
+func (s *InMemoryConversationStore) Append(screenName string, turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.append(screenName, turn)
+	return nil
+}
+
+func (s *InMemoryConversationStore) append(screenName string, turn Turn) {
+	key := NormalizeScreenName(screenName)
+	turns := append(s.conversations[key], turn)
+	if s.maxTurns > 0 && len(turns) > s.maxTurns {
+		turns = turns[len(turns)-s.maxTurns:]
+	}
+	s.conversations[key] = turns
+}
+
+// Reset clears the conversation history for screenName.
+func (s *InMemoryConversationStore) Reset(screenName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, NormalizeScreenName(screenName))
+	return nil
+}
+
+// conversationsBucket holds each user's conversation history, keyed by
+// normalized screen name.
+var conversationsBucket = []byte("conversations")
+
+// NewFileConversationStore creates a conversation store that keeps up to
+// maxTurns turns per screen name and persists them to an embedded database
+// file at path. Existing history is loaded from the file if it exists, and
+// trimmed to maxTurns. Call Close once done with the store.
+func NewFileConversationStore(path string, maxTurns int) (*FileConversationStore, error) {
+	db, err := openDB(path, conversationsBucket)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileConversationStore{
+		db:  db,
+		mem: NewInMemoryConversationStore(maxTurns),
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		return bucket.ForEach(func(k, v []byte) error {
+			var turns []Turn
+			if err := json.Unmarshal(v, &turns); err != nil {
+				return fmt.Errorf("unable to parse history of %q: %w", k, err)
+			}
+			for _, turn := range turns {
+				s.mem.append(string(k), turn)
+			}
+			if len(turns) == len(s.mem.conversations[string(k)]) {
+				return nil
+			}
+			// HISTORY_TURNS was lowered since the history was saved
+			return putJSON(bucket, string(k), s.mem.conversations[string(k)])
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to load conversation history: %w", err)
+	}
+	return s, nil
+}
+
+// FileConversationStore is a conversation store that survives restarts by
+// saving each user's history to an embedded database as it changes.
+type FileConversationStore struct {
+	db  *bbolt.DB
+	mem *InMemoryConversationStore
+}
+
+// History returns the conversation history for screenName, oldest turn first.
+func (s *FileConversationStore) History(screenName string) ([]Turn, error) {
+	return s.mem.History(screenName)
+}
+
+// Append adds a turn to the conversation history for screenName and persists
+// the user's history.
+func (s *FileConversationStore) Append(screenName string, turn Turn) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	s.mem.append(screenName, turn)
+	key := NormalizeScreenName(screenName)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return putJSON(tx.Bucket(conversationsBucket), key, s.mem.conversations[key])
+	})
+}
+
+// Reset clears the conversation history for screenName and persists the
+// change.
+func (s *FileConversationStore) Reset(screenName string) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	key := NormalizeScreenName(screenName)
+	delete(s.mem.conversations, key)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Delete([]byte(key))
+	})
+}
+
+// Close closes the database file.
+func (s *FileConversationStore) Close() error {
+	return s.db.Close()
+}
+
+// writeJSONFile atomically replaces the file at path with the JSON encoding
+// of v, so that a crash mid-write never leaves a truncated file behind.
+func writeJSONFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s: %w", path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace %s: %w", path, err)
+	}
+	return nil
+}
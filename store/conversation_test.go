@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConversationStoreTruncation(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxTurns int
+		appended int
+		want     []string
+	}{
+		{name: "under the limit", maxTurns: 3, appended: 2, want: []string{"msg 0", "msg 1"}},
+		{name: "at the limit", maxTurns: 3, appended: 3, want: []string{"msg 0", "msg 1", "msg 2"}},
+		{name: "over the limit", maxTurns: 3, appended: 5, want: []string{"msg 2", "msg 3", "msg 4"}},
+		{name: "unlimited", maxTurns: 0, appended: 4, want: []string{"msg 0", "msg 1", "msg 2", "msg 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewInMemoryConversationStore(tt.maxTurns)
+			for i := 0; i < tt.appended; i++ {
+				if err := s.Append("Some User", Turn{User: fmt.Sprintf("msg %d", i)}); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+			history, err := s.History("someuser")
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != len(tt.want) {
+				t.Fatalf("got %d turns, want %d", len(history), len(tt.want))
+			}
+			for i, turn := range history {
+				if turn.User != tt.want[i] {
+					t.Errorf("turn %d = %q, want %q", i, turn.User, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFileConversationStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+	at := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewFileConversationStore(path, 2)
+	if err != nil {
+		t.Fatalf("NewFileConversationStore() error = %v", err)
+	}
+	for _, turn := range []struct {
+		screenName string
+		turn       Turn
+	}{
+		{"Alice", Turn{User: "hi", Bot: "hi2u", Time: at}},
+		{"alice", Turn{User: "a/s/l?", Bot: "lol", Time: at.Add(time.Minute)}},
+		{"ALICE", Turn{User: "brb", Bot: "ttyl", Time: at.Add(2 * time.Minute)}},
+		{"Bob", Turn{User: "hello", Bot: "sup", Time: at}},
+		{"Carol", Turn{User: "forget me", Bot: "ok", Time: at}},
+	} {
+		if err := s.Append(turn.screenName, turn.turn); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	if err := s.Reset("carol"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewFileConversationStore(path, 2)
+	if err != nil {
+		t.Fatalf("NewFileConversationStore() error = %v", err)
+	}
+	defer reopened.Close()
+	tests := []struct {
+		screenName string
+		want       []Turn
+	}{
+		{
+			screenName: "Alice",
+			want: []Turn{
+				{User: "a/s/l?", Bot: "lol", Time: at.Add(time.Minute)},
+				{User: "brb", Bot: "ttyl", Time: at.Add(2 * time.Minute)},
+			},
+		},
+		{
+			screenName: "bob",
+			want:       []Turn{{User: "hello", Bot: "sup", Time: at}},
+		},
+		{
+			screenName: "Carol",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.screenName, func(t *testing.T) {
+			history, err := reopened.History(tt.screenName)
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != len(tt.want) {
+				t.Fatalf("got %d turns, want %d: %+v", len(history), len(tt.want), history)
+			}
+			for i, turn := range history {
+				if turn.User != tt.want[i].User || turn.Bot != tt.want[i].Bot || !turn.Time.Equal(tt.want[i].Time) {
+					t.Errorf("turn %d = %+v, want %+v", i, turn, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFileConversationStoreTrimsOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+	s, err := NewFileConversationStore(path, 5)
+	if err != nil {
+		t.Fatalf("NewFileConversationStore() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := s.Append("alice", Turn{User: fmt.Sprintf("msg %d", i)}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	s.Close()
+
+	// reopen with a lower limit, twice, to check that the trimmed history
+	// was saved
+	for _, maxTurns := range []int{2, 5} {
+		s, err = NewFileConversationStore(path, maxTurns)
+		if err != nil {
+			t.Fatalf("NewFileConversationStore() error = %v", err)
+		}
+		history, _ := s.History("alice")
+		s.Close()
+		if len(history) != 2 || history[0].User != "msg 3" || history[1].User != "msg 4" {
+			t.Errorf("history with max %d turns = %+v, want the last 2 turns", maxTurns, history)
+		}
+	}
+}
+
+func TestNewFileConversationStoreMissingFile(t *testing.T) {
+	s, err := NewFileConversationStore(filepath.Join(t.TempDir(), "missing.db"), 5)
+	if err != nil {
+		t.Fatalf("NewFileConversationStore() error = %v", err)
+	}
+	defer s.Close()
+	if history, _ := s.History("anyone"); len(history) != 0 {
+		t.Errorf("History() = %+v, want no turns", history)
+	}
+}
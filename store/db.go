@@ -0,0 +1,44 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// dbOpenTimeout is how long to wait for another process to release its lock
+// on a database file before giving up.
+const dbOpenTimeout = 5 * time.Second
+
+// openDB opens the bbolt database at path, creating it along with buckets if
+// they don't exist.
+func openDB(path string, buckets ...[]byte) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: dbOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create buckets in %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// putJSON stores the JSON encoding of v under key in bucket.
+func putJSON(bucket *bbolt.Bucket, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to encode %q: %w", key, err)
+	}
+	return bucket.Put([]byte(key), b)
+}
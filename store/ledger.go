@@ -0,0 +1,218 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// ledgerDays is the number of days of daily usage kept in the ledger.
+	ledgerDays = 31
+	// ledgerMonths is the number of months of monthly usage kept in the
+	// ledger.
+	ledgerMonths = 12
+)
+
+// Usage is an amount of AI model usage.
+type Usage struct {
+	// Tokens is the number of prompt and completion tokens used.
+	Tokens int64 `json:"tokens"`
+	// Cost is the price of the tokens in US dollars.
+	Cost float64 `json:"cost"`
+}
+
+// Add returns the sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{Tokens: u.Tokens + o.Tokens, Cost: u.Cost + o.Cost}
+}
+
+// usagePeriod is the usage within a single day or month.
+type usagePeriod struct {
+	Total Usage            `json:"total"`
+	Users map[string]Usage `json:"users"`
+}
+
+type ledgerData struct {
+	Days   map[string]*usagePeriod `json:"days"`
+	Months map[string]*usagePeriod `json:"months"`
+}
+
+var (
+	// ledgerDaysBucket holds the usage of each day, keyed by date.
+	ledgerDaysBucket = []byte("days")
+	// ledgerMonthsBucket holds the usage of each month, keyed by month.
+	ledgerMonthsBucket = []byte("months")
+)
+
+// NewUsageLedger creates a UsageLedger that persists to an embedded database
+// file at path. Existing usage is loaded from the file if it exists. If path
+// is empty, usage is kept in memory only. Call Close once done with the
+// ledger.
+func NewUsageLedger(path string) (*UsageLedger, error) {
+	l := &UsageLedger{
+		data: ledgerData{
+			Days:   make(map[string]*usagePeriod),
+			Months: make(map[string]*usagePeriod),
+		},
+	}
+	if path == "" {
+		return l, nil
+	}
+	db, err := openDB(path, ledgerDaysBucket, ledgerMonthsBucket)
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		for _, b := range []struct {
+			name    []byte
+			periods map[string]*usagePeriod
+		}{{ledgerDaysBucket, l.data.Days}, {ledgerMonthsBucket, l.data.Months}} {
+			err := tx.Bucket(b.name).ForEach(func(k, v []byte) error {
+				period := &usagePeriod{}
+				if err := json.Unmarshal(v, period); err != nil {
+					return fmt.Errorf("unable to parse usage of %s: %w", k, err)
+				}
+				if period.Users == nil {
+					period.Users = make(map[string]Usage)
+				}
+				b.periods[string(k)] = period
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to load usage ledger: %w", err)
+	}
+	l.db = db
+	return l, nil
+}
+
+// UsageLedger tallies AI model usage per screen name and overall, by day and
+// by month. Days and months are in local time.
+type UsageLedger struct {
+	// db persists the ledger. It's nil if the ledger is kept in memory only.
+	db   *bbolt.DB
+	mu   sync.Mutex
+	data ledgerData
+}
+
+func dayKey(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+func monthKey(t time.Time) string {
+	return t.Local().Format("2006-01")
+}
+
+// Record adds usage by screenName at time at to the ledger.
+func (l *UsageLedger) Record(screenName string, usage Usage, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user := NormalizeScreenName(screenName)
+	periods := []struct {
+		bucket  []byte
+		periods map[string]*usagePeriod
+		key     string
+		prune   func(key string) bool
+		pruned  []string
+	}{
+		{
+			bucket:  ledgerDaysBucket,
+			periods: l.data.Days,
+			key:     dayKey(at),
+			prune: func(key string) bool {
+				return key < dayKey(at.AddDate(0, 0, -ledgerDays))
+			},
+		},
+		{
+			bucket:  ledgerMonthsBucket,
+			periods: l.data.Months,
+			key:     monthKey(at),
+			prune: func(key string) bool {
+				return key < monthKey(at.AddDate(0, -ledgerMonths, 0))
+			},
+		},
+	}
+	for i := range periods {
+		p := &periods[i]
+		period, ok := p.periods[p.key]
+		if !ok {
+			period = &usagePeriod{Users: make(map[string]Usage)}
+			p.periods[p.key] = period
+			// a new period began, drop periods that are too old to matter
+			for key := range p.periods {
+				if p.prune(key) {
+					delete(p.periods, key)
+					p.pruned = append(p.pruned, key)
+				}
+			}
+		}
+		period.Total = period.Total.Add(usage)
+		period.Users[user] = period.Users[user].Add(usage)
+	}
+
+	if l.db == nil {
+		return nil
+	}
+	// only the current periods changed, so only they are written
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		for _, p := range periods {
+			bucket := tx.Bucket(p.bucket)
+			if err := putJSON(bucket, p.key, p.periods[p.key]); err != nil {
+				return err
+			}
+			for _, key := range p.pruned {
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Usage returns screenName's usage on the day and in the month of time at.
+func (l *UsageLedger) Usage(screenName string, at time.Time) (day Usage, month Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	user := NormalizeScreenName(screenName)
+	if p, ok := l.data.Days[dayKey(at)]; ok {
+		day = p.Users[user]
+	}
+	if p, ok := l.data.Months[monthKey(at)]; ok {
+		month = p.Users[user]
+	}
+	return day, month
+}
+
+// Total returns the usage of all users on the day and in the month of time
+// at.
+func (l *UsageLedger) Total(at time.Time) (day Usage, month Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p, ok := l.data.Days[dayKey(at)]; ok {
+		day = p.Total
+	}
+	if p, ok := l.data.Months[monthKey(at)]; ok {
+		month = p.Total
+	}
+	return day, month
+}
+
+// Close closes the database file, if any.
+func (l *UsageLedger) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	return l.db.Close()
+}
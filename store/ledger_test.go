@@ -0,0 +1,115 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageLedger(t *testing.T) {
+	day1 := time.Date(2024, time.May, 30, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2) // June 1st
+
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	l, err := NewUsageLedger(path)
+	if err != nil {
+		t.Fatalf("NewUsageLedger() error = %v", err)
+	}
+	for _, r := range []struct {
+		screenName string
+		usage      Usage
+		at         time.Time
+	}{
+		{"Alice", Usage{Tokens: 100, Cost: 0.01}, day1},
+		{"alice", Usage{Tokens: 50, Cost: 0.005}, day2},
+		{"Bob", Usage{Tokens: 10, Cost: 0.001}, day2},
+		{"Alice", Usage{Tokens: 7, Cost: 0.0007}, day3},
+	} {
+		if err := l.Record(r.screenName, r.usage, r.at); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// usage must survive a restart
+	reopened, err := NewUsageLedger(path)
+	if err != nil {
+		t.Fatalf("NewUsageLedger() error = %v", err)
+	}
+	defer reopened.Close()
+
+	tests := []struct {
+		name       string
+		screenName string
+		at         time.Time
+		wantDay    Usage
+		wantMonth  Usage
+	}{
+		{name: "alice day 1", screenName: "ALICE", at: day1, wantDay: Usage{100, 0.01}, wantMonth: Usage{150, 0.015}},
+		{name: "alice day 2", screenName: "Alice", at: day2, wantDay: Usage{50, 0.005}, wantMonth: Usage{150, 0.015}},
+		{name: "alice new month", screenName: "Alice", at: day3, wantDay: Usage{7, 0.0007}, wantMonth: Usage{7, 0.0007}},
+		{name: "bob", screenName: "bob", at: day2, wantDay: Usage{10, 0.001}, wantMonth: Usage{10, 0.001}},
+		{name: "bob idle day", screenName: "bob", at: day1, wantMonth: Usage{10, 0.001}},
+		{name: "all users", at: day2, wantDay: Usage{60, 0.006}, wantMonth: Usage{160, 0.016}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var day, month Usage
+			if tt.screenName == "" {
+				day, month = reopened.Total(tt.at)
+			} else {
+				day, month = reopened.Usage(tt.screenName, tt.at)
+			}
+			if !usageEqual(day, tt.wantDay) {
+				t.Errorf("day = %+v, want %+v", day, tt.wantDay)
+			}
+			if !usageEqual(month, tt.wantMonth) {
+				t.Errorf("month = %+v, want %+v", month, tt.wantMonth)
+			}
+		})
+	}
+}
+
+func TestUsageLedgerPrunesOldPeriods(t *testing.T) {
+	start := time.Date(2023, time.January, 15, 12, 0, 0, 0, time.Local)
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	l, err := NewUsageLedger(path)
+	if err != nil {
+		t.Fatalf("NewUsageLedger() error = %v", err)
+	}
+	if err := l.Record("alice", Usage{Tokens: 1}, start); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	later := start.AddDate(0, ledgerMonths+1, 0)
+	if err := l.Record("alice", Usage{Tokens: 1}, later); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	if day, month := l.Usage("alice", start); day != (Usage{}) || month != (Usage{}) {
+		t.Errorf("usage at %s = %+v, %+v, want it pruned", start.Format("2006-01-02"), day, month)
+	}
+	if len(l.data.Days) != 1 || len(l.data.Months) != 1 {
+		t.Errorf("ledger kept %d days and %d months, want 1 of each", len(l.data.Days), len(l.data.Months))
+	}
+
+	// the pruned periods must be gone from the file too
+	l.Close()
+	reopened, err := NewUsageLedger(path)
+	if err != nil {
+		t.Fatalf("NewUsageLedger() error = %v", err)
+	}
+	defer reopened.Close()
+	if len(reopened.data.Days) != 1 || len(reopened.data.Months) != 1 {
+		t.Errorf("ledger file kept %d days and %d months, want 1 of each", len(reopened.data.Days), len(reopened.data.Months))
+	}
+}
+
+// usageEqual compares usage, allowing for floating point error in the cost.
+func usageEqual(a, b Usage) bool {
+	diff := a.Cost - b.Cost
+	return a.Tokens == b.Tokens && diff < 1e-9 && diff > -1e-9
+}
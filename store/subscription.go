@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Subscriber is a user who asked the bot to greet them when they sign on.
+type Subscriber struct {
+	ScreenName string `json:"screen_name"`
+	// LastGreeted is when the bot last greeted the user, or zero if it
+	// hasn't yet.
+	LastGreeted time.Time `json:"last_greeted"`
+}
+
+// NewSubscriptionStore creates a SubscriptionStore that persists to a JSON
+// file at path. Existing subscribers are loaded from the file if it exists.
+// If path is empty, subscribers are kept in memory only.
+func NewSubscriptionStore(path string) (*SubscriptionStore, error) {
+	s := &SubscriptionStore{
+		path:        path,
+		subscribers: make(map[string]Subscriber),
+	}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read subscriptions file: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.subscribers); err != nil {
+		return nil, fmt.Errorf("unable to parse subscriptions file: %w", err)
+	}
+	if s.subscribers == nil {
+		s.subscribers = make(map[string]Subscriber)
+	}
+	return s, nil
+}
+
+// SubscriptionStore holds the users who asked to be greeted when they sign
+// on, keyed by normalized screen name.
+type SubscriptionStore struct {
+	path        string
+	mu          sync.RWMutex
+	subscribers map[string]Subscriber
+}
+
+// Subscribe adds screenName to the subscribers. It returns false if
+// screenName was already subscribed.
+func (s *SubscriptionStore) Subscribe(screenName string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := NormalizeScreenName(screenName)
+	if _, ok := s.subscribers[key]; ok {
+		return false, nil
+	}
+	s.subscribers[key] = Subscriber{ScreenName: screenName}
+	return true, s.save()
+}
+
+// Unsubscribe removes screenName from the subscribers. It returns false if
+// screenName wasn't subscribed.
+func (s *SubscriptionStore) Unsubscribe(screenName string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := NormalizeScreenName(screenName)
+	if _, ok := s.subscribers[key]; !ok {
+		return false, nil
+	}
+	delete(s.subscribers, key)
+	return true, s.save()
+}
+
+// Subscriber returns the subscription of screenName, if they're subscribed.
+func (s *SubscriptionStore) Subscriber(screenName string) (Subscriber, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subscribers[NormalizeScreenName(screenName)]
+	return sub, ok
+}
+
+// Greeted records that the bot greeted screenName at time at.
+func (s *SubscriptionStore) Greeted(screenName string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := NormalizeScreenName(screenName)
+	sub, ok := s.subscribers[key]
+	if !ok {
+		return nil // unsubscribed in the meantime
+	}
+	sub.LastGreeted = at
+	s.subscribers[key] = sub
+	return s.save()
+}
+
+// save persists the subscribers to disk. The caller must hold s.mu.
+func (s *SubscriptionStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.subscribers)
+}